@@ -0,0 +1,108 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	jwt "github.com/appleboy/gin-jwt"
+	"github.com/gin-gonic/gin"
+)
+
+// AudienceLogin wraps the jwt login handler, issuing tokens whose aud claim is
+// the client type selected by the optional client_type field of the login
+// request, such as "web", "mobile" or "cli". Client types outside of audiences
+// are rejected, while logins which don't select one are issued tokens without
+// an audience
+func AudienceLogin(mw *jwt.GinJWTMiddleware, audiences []string) gin.HandlerFunc {
+	logins := make(map[string]gin.HandlerFunc, len(audiences))
+	for _, audience := range audiences {
+		logins[audience] = audienceMiddleware(mw, audience).LoginHandler
+	}
+	return func(c *gin.Context) {
+		audience, err := clientType(c)
+		if err != nil {
+			// leave reporting malformed requests to the login handler
+			mw.LoginHandler(c)
+			return
+		}
+		if audience == "" {
+			mw.LoginHandler(c)
+			return
+		}
+		login, ok := logins[audience]
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"code":    http.StatusBadRequest,
+				"message": fmt.Sprintf("invalid client_type, must be one of %s", strings.Join(audiences, ", ")),
+			})
+			return
+		}
+		login(c)
+	}
+}
+
+// RequireAudience aborts requests authenticated with tokens
+// which weren't issued to one of the allowed client types
+func RequireAudience(allowed ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		audience, _ := jwt.ExtractClaims(c)["aud"].(string)
+		for _, a := range allowed {
+			if audience != "" && audience == a {
+				c.Next()
+				return
+			}
+		}
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+			"code":     http.StatusForbidden,
+			"response": "token was not issued to a client permitted to access this route",
+		})
+	}
+}
+
+// audienceMiddleware returns a copy of mw which adds
+// audience as the aud claim of the tokens it issues
+func audienceMiddleware(mw *jwt.GinJWTMiddleware, audience string) *jwt.GinJWTMiddleware {
+	amw := *mw
+	payload := mw.PayloadFunc
+	amw.PayloadFunc = func(userID string) map[string]interface{} {
+		claims := make(map[string]interface{})
+		if payload != nil {
+			for name, value := range payload(userID) {
+				claims[name] = value
+			}
+		}
+		claims["aud"] = audience
+		return claims
+	}
+	return &amw
+}
+
+// clientType returns the client type selected by a login request,
+// leaving the request body intact for the login handler
+func clientType(c *gin.Context) (string, error) {
+	var login struct {
+		ClientType string `json:"client_type"`
+	}
+	if err := peekLogin(c, &login); err != nil {
+		return "", err
+	}
+	return login.ClientType, nil
+}
+
+// peekLogin decodes the body of a login request into v, leaving
+// the request body intact for the login handler
+func peekLogin(c *gin.Context, v interface{}) error {
+	if c.Request.Body == nil {
+		return nil
+	}
+	body, err := ioutil.ReadAll(c.Request.Body)
+	if err != nil {
+		return err
+	}
+	c.Request.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return json.Unmarshal(body, v)
+}
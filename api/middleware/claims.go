@@ -0,0 +1,61 @@
+package middleware
+
+import (
+	"github.com/RTradeLtd/database/v2/models"
+	"github.com/jinzhu/gorm"
+)
+
+// reservedClaims are set by the api itself, and can't be overridden by a ClaimsAugmenter
+var reservedClaims = map[string]bool{
+	"id":           true,
+	"exp":          true,
+	"orig_iat":     true,
+	"impersonator": true,
+	"aud":          true,
+	"jti":          true,
+	"sid":          true,
+}
+
+// ClaimsAugmenter returns additional claims to embed within tokens issued to
+// username, such as the id of the organization the user belongs to
+type ClaimsAugmenter func(username string) map[string]interface{}
+
+// AugmentClaims adds the claims returned by augment to claims,
+// ignoring any that would override a reserved claim
+func AugmentClaims(claims map[string]interface{}, augment ClaimsAugmenter, username string) {
+	if augment == nil {
+		return
+	}
+	for name, value := range augment(username) {
+		if reservedClaims[name] {
+			continue
+		}
+		claims[name] = value
+	}
+}
+
+// ClaimsPayload returns a payload function for the jwt middleware which embeds
+// the claims returned by augment in issued tokens. Refreshed tokens retain them
+func ClaimsPayload(db *gorm.DB, augment ClaimsAugmenter) func(userID string) map[string]interface{} {
+	return func(userID string) map[string]interface{} {
+		// users may sign in with their email address, so ensure
+		// that the augmenter is always given their username
+		claims := make(map[string]interface{})
+		AugmentClaims(claims, augment, ResolveUserName(db, userID))
+		return claims
+	}
+}
+
+// ResolveUserName returns the username of the account signed in to with
+// userID, which may be its username or email address. Payload functions are
+// given the id as submitted rather than the username the Authenticator
+// resolved it to. If no account matches, userID is returned as is
+func ResolveUserName(db *gorm.DB, userID string) string {
+	userManager := models.NewUserManager(db)
+	if _, err := userManager.FindByUserName(userID); err != nil {
+		if usr, err := userManager.FindByEmail(userID); err == nil {
+			return usr.UserName
+		}
+	}
+	return userID
+}
@@ -0,0 +1,71 @@
+package middleware
+
+import (
+	"bytes"
+	"compress/gzip"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// DefaultCompressMinSize is the smallest response body that will be compressed,
+// below which the gzip overhead outweighs any savings
+const DefaultCompressMinSize = 1024
+
+// Compress gzip compresses response bodies of at least minSize bytes for clients
+// which opt in through the Accept-Encoding header. Responses are buffered in full,
+// so this must not be used on routes which stream large files
+func Compress(minSize int) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Writer.Header().Add("Vary", "Accept-Encoding")
+		if !acceptsGzip(c.GetHeader("Accept-Encoding")) {
+			c.Next()
+			return
+		}
+		bw := &bufferedWriter{ResponseWriter: c.Writer}
+		c.Writer = bw
+		c.Next()
+		c.Writer = bw.ResponseWriter
+		body := bw.buf.Bytes()
+		if len(body) < minSize || c.Writer.Header().Get("Content-Encoding") != "" {
+			c.Writer.Write(body)
+			return
+		}
+		var compressed bytes.Buffer
+		gz := gzip.NewWriter(&compressed)
+		if _, err := gz.Write(body); err != nil {
+			c.Writer.Write(body)
+			return
+		}
+		if err := gz.Close(); err != nil {
+			c.Writer.Write(body)
+			return
+		}
+		c.Writer.Header().Set("Content-Encoding", "gzip")
+		c.Writer.Header().Del("Content-Length")
+		c.Writer.Write(compressed.Bytes())
+	}
+}
+
+// acceptsGzip reports whether an Accept-Encoding header allows gzip
+func acceptsGzip(header string) bool {
+	for _, encoding := range strings.Split(header, ",") {
+		parts := strings.Split(encoding, ";")
+		if strings.TrimSpace(parts[0]) != "gzip" {
+			continue
+		}
+		// an encoding with a quality of zero has been explicitly refused
+		for _, param := range parts[1:] {
+			param = strings.TrimSpace(param)
+			if !strings.HasPrefix(param, "q=") {
+				continue
+			}
+			if q, err := strconv.ParseFloat(param[2:], 64); err == nil && q == 0 {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
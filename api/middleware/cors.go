@@ -25,6 +25,7 @@ func CORSMiddleware(devMode bool, debug bool, allowedOrigins []string) gin.Handl
 		"cache-control",
 		"Authorization",
 		"X-Request-ID",
+		CSRFHeaderName,
 		"Origin",
 		"Accept",
 		"Content-Type",
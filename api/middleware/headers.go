@@ -0,0 +1,30 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// DefaultMaxHeaderBytes is the maximum size of request headers accepted by the api
+	DefaultMaxHeaderBytes = 64 << 10
+	// DefaultMaxHeaders is the maximum number of request headers accepted by the api
+	DefaultMaxHeaders = 100
+)
+
+// HeaderLimits rejects requests with more than maxHeaders headers. The total
+// size of headers is limited by http.Server.MaxHeaderBytes, which rejects
+// oversized requests before they are parsed
+func HeaderLimits(maxHeaders int) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if len(c.Request.Header) > maxHeaders {
+			c.AbortWithStatusJSON(http.StatusRequestHeaderFieldsTooLarge, gin.H{
+				"code":     http.StatusRequestHeaderFieldsTooLarge,
+				"response": "too many request headers",
+			})
+			return
+		}
+		c.Next()
+	}
+}
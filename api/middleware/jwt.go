@@ -1,6 +1,11 @@
 package middleware
 
 import (
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
 	"time"
 
 	"github.com/RTradeLtd/database/v2/models"
@@ -16,9 +21,44 @@ type Login struct {
 	Password string `form:"password" json:"password" binding:"required"`
 }
 
-// JwtConfigGenerate is used to generate our JWT configuration
-func JwtConfigGenerate(jwtKey, realmName string, db *gorm.DB, l *zap.SugaredLogger) *jwt.GinJWTMiddleware {
+const (
+	// accountSuspendedKey is set on the request context when authentication fails
+	// because the account is suspended, so that a specific error can be returned
+	accountSuspendedKey = "account_suspended"
+	// emailUnverifiedKey is set on the request context when sign in fails
+	// with valid credentials because the email address isn't verified
+	emailUnverifiedKey = "email_unverified"
+)
+
+// EmailUnverified reports whether sign in failed because the account's email
+// address isn't verified, which is only known once its password was checked
+func EmailUnverified(c *gin.Context) bool {
+	return c.GetBool(emailUnverifiedKey)
+}
+
+// MinimumJWTKeyLength is the minimum length in bytes of the key used to sign tokens.
+// Tokens are signed with HS256, so anything shorter than the hash output weakens them
+const MinimumJWTKeyLength = 32
+
+// MinimumJWTKeyVariety is the minimum number of distinct bytes in the key used to
+// sign tokens, which rejects placeholders such as a single repeated character that
+// pass the length check. Random keys, even hex encoded ones, easily exceed it
+const MinimumJWTKeyVariety = 8
+
+// JwtConfigGenerate is used to generate our JWT configuration. Password
+// hashes below passwordCost are upgraded on sign in, 0 disables this
+func JwtConfigGenerate(jwtKey, realmName string, db *gorm.DB, l *zap.SugaredLogger, passwordCost int) (*jwt.GinJWTMiddleware, error) {
+	if jwtKey == "" {
+		return nil, errors.New("jwt signing key is empty")
+	}
 	l = l.Named("jwt-middleware")
+	// weak keys were accepted by earlier releases, so existing deployments
+	// are warned for now, and will fail to start in the next release
+	if err := weakJWTKey(jwtKey); err != nil {
+		l.Warnw("deprecated jwt signing key, which will be rejected in the next release",
+			"error", err.Error())
+	}
+	hasher := PasswordHasher{Cost: passwordCost}
 	authMiddleware := &jwt.GinJWTMiddleware{
 		Realm:      realmName,
 		Key:        []byte(jwtKey),
@@ -50,8 +90,31 @@ func JwtConfigGenerate(jwtKey, realmName string, db *gorm.DB, l *zap.SugaredLogg
 			}
 			// email enabled implies they have verified their email
 			if !usr.EmailEnabled {
+				c.Set(emailUnverifiedKey, true)
 				return "", false
 			}
+			if !usr.AccountEnabled {
+				lAuth.Warn("sign in to disabled account")
+				return "", false
+			}
+			// suspended accounts are disabled by an administrator
+			if suspension, err := FindAccountSuspension(db, usr.UserName); err != nil {
+				lAuth.Warn("failed to find account suspension", "error", err)
+				return "", false
+			} else if suspension != nil {
+				lAuth.Warn("sign in to suspended account")
+				c.Set(accountSuspendedKey, true)
+				return "", false
+			}
+			// upgrade the password hash if it is below our target cost,
+			// failing to do so shouldn't prevent login
+			if hash, changed, err := hasher.Rehash(usr.HashedPassword, password); err != nil {
+				lAuth.Warn("failed to rehash password", "error", err)
+			} else if changed {
+				if err := db.Model(usr).Update("hashed_password", hash).Error; err != nil {
+					lAuth.Warn("failed to update password hash", "error", err)
+				}
+			}
 			lAuth.Info("successful login", "username", usr.UserName)
 			return usr.UserName, true
 		},
@@ -62,9 +125,25 @@ func JwtConfigGenerate(jwtKey, realmName string, db *gorm.DB, l *zap.SugaredLogg
 			if err != nil {
 				return false
 			}
-			return usr.EmailEnabled && usr.AccountEnabled
+			if !usr.EmailEnabled || !usr.AccountEnabled {
+				return false
+			}
+			suspension, err := FindAccountSuspension(db, usr.UserName)
+			if err != nil {
+				return false
+			}
+			if suspension != nil {
+				c.Set(accountSuspendedKey, true)
+				return false
+			}
+			return true
 		},
 		Unauthorized: func(c *gin.Context, code int, message string) {
+			// tokens are provided as bearer tokens, so advertise that scheme along with our realm
+			c.Header("WWW-Authenticate", fmt.Sprintf("Bearer realm=%q", realmName))
+			if c.GetBool(accountSuspendedKey) {
+				code, message = http.StatusForbidden, "account suspended"
+			}
 			l.Error("invalid login detected")
 			c.JSON(code, gin.H{
 				"code":    code,
@@ -77,5 +156,36 @@ func JwtConfigGenerate(jwtKey, realmName string, db *gorm.DB, l *zap.SugaredLogg
 		TimeFunc:      time.Now,
 	}
 
-	return authMiddleware
+	return authMiddleware, nil
+}
+
+// validateJWTKey is used to catch signing key misconfigurations before
+// they silently result in weak tokens
+func validateJWTKey(jwtKey string) error {
+	if jwtKey == "" {
+		return errors.New("jwt signing key is empty")
+	}
+	return weakJWTKey(jwtKey)
+}
+
+// weakJWTKey reports why a non empty signing key is too weak to sign tokens
+// with, if it is. Keys are too weak if they are short, have little variety,
+// or are PEM encoded keys meant for asymmetric signing
+func weakJWTKey(jwtKey string) error {
+	if len(jwtKey) < MinimumJWTKeyLength {
+		return fmt.Errorf("jwt signing key is too short, must be at least %v bytes", MinimumJWTKeyLength)
+	}
+	distinct := make(map[byte]bool)
+	for i := 0; i < len(jwtKey); i++ {
+		distinct[jwtKey[i]] = true
+	}
+	if len(distinct) < MinimumJWTKeyVariety {
+		return fmt.Errorf("jwt signing key has too little entropy, must contain at least %v distinct bytes", MinimumJWTKeyVariety)
+	}
+	// tokens are signed with HMAC, so a pasted RSA or EC key would be used as
+	// a shared secret, whose public half may well be known to others
+	if block, _ := pem.Decode([]byte(strings.TrimSpace(jwtKey))); block != nil {
+		return fmt.Errorf("jwt signing key appears to be a PEM encoded %s, but tokens are signed with HMAC and require a random secret", strings.ToLower(block.Type))
+	}
+	return nil
 }
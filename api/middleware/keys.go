@@ -0,0 +1,94 @@
+package middleware
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync/atomic"
+
+	jwt "github.com/appleboy/gin-jwt"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// JWTKeyEnv is the environment variable the jwt signing key can be provided through
+	JWTKeyEnv = "TEMPORAL_JWT_KEY"
+	// JWTKeyFileEnv is the environment variable naming a file containing the jwt
+	// signing key, such as a mounted secret. It takes precedence over JWTKeyEnv
+	JWTKeyFileEnv = "TEMPORAL_JWT_KEY_FILE"
+)
+
+// LoadJWTKey returns the jwt signing key provided through the environment,
+// allowing it to be kept out of the configuration file. If neither JWTKeyFileEnv
+// or JWTKeyEnv are set the configured key is returned unchanged. It is called
+// again on SIGHUP, so that the key can be rotated without a restart
+func LoadJWTKey(configured string) (string, error) {
+	if path := os.Getenv(JWTKeyFileEnv); path != "" {
+		contents, err := ioutil.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("failed to read jwt signing key file: %s", err)
+		}
+		// secret files frequently end with a newline
+		key := strings.TrimSpace(string(contents))
+		return key, validateJWTKey(key)
+	}
+	if key := os.Getenv(JWTKeyEnv); key != "" {
+		return key, validateJWTKey(key)
+	}
+	return configured, nil
+}
+
+// SigningKey holds the jwt signing key, which can be replaced while requests
+// are being served, such as when it is reloaded on SIGHUP. Readers must call
+// Current for every use rather than keeping a copy of the key
+type SigningKey struct {
+	keys atomic.Value
+}
+
+// signingKeys is the value stored by SigningKey, so that
+// both keys are always replaced together
+type signingKeys struct {
+	current  string
+	previous string
+}
+
+// NewSigningKey returns a SigningKey holding key, which
+// is expected to have been validated by JwtConfigGenerate
+func NewSigningKey(key string) *SigningKey {
+	sk := &SigningKey{}
+	sk.keys.Store(signingKeys{current: key})
+	return sk
+}
+
+// Current returns the key tokens are signed with
+func (sk *SigningKey) Current() string {
+	return sk.keys.Load().(signingKeys).current
+}
+
+// Previous returns the key which was replaced by the last call to
+// Replace, or an empty string if the key was never replaced
+func (sk *SigningKey) Previous() string {
+	return sk.keys.Load().(signingKeys).previous
+}
+
+// Replace validates key and makes it the key tokens are signed and validated
+// with. Tokens signed with the previous key are rejected from then on
+func (sk *SigningKey) Replace(key string) error {
+	if err := validateJWTKey(key); err != nil {
+		return err
+	}
+	sk.keys.Store(signingKeys{current: key, previous: sk.Current()})
+	return nil
+}
+
+// Bind returns a handler which serves each request with the handler built from
+// a copy of mw using the current key. gin-jwt reads GinJWTMiddleware.Key without
+// synchronisation, so the key of a middleware can't be replaced while it is in use
+func (sk *SigningKey) Bind(mw *jwt.GinJWTMiddleware, handler func(*jwt.GinJWTMiddleware) gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		keyed := *mw
+		keyed.Key = []byte(sk.Current())
+		handler(&keyed)(c)
+	}
+}
@@ -1,13 +1,25 @@
 package middleware
 
 import (
+	"bytes"
+	"compress/gzip"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	"go.uber.org/zap/zaptest"
+	"golang.org/x/crypto/bcrypt"
 
+	ginjwt "github.com/appleboy/gin-jwt"
 	"github.com/gin-gonic/gin"
 
 	"github.com/RTradeLtd/config/v2"
@@ -41,7 +53,10 @@ func TestJwtMiddleware(t *testing.T) {
 		t.Fatal(err)
 	}
 	logger := zaptest.NewLogger(t).Sugar()
-	jwt := JwtConfigGenerate(cfg.JWT.Key, cfg.JWT.Realm, db.DB, logger)
+	jwt, err := JwtConfigGenerate(cfg.JWT.Key, cfg.JWT.Realm, db.DB, logger, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
 	if reflect.TypeOf(jwt).String() != "*jwt.GinJWTMiddleware" {
 		t.Fatal("failed to reflect correct middleware type")
 	}
@@ -71,6 +86,35 @@ func TestJwtMiddleware(t *testing.T) {
 	}
 }
 
+func TestJwtConfigGenerate_Validation(t *testing.T) {
+	logger := zaptest.NewLogger(t).Sugar()
+	// weak keys are only warned about by JwtConfigGenerate, so that
+	// existing deployments keep starting, but are rejected elsewhere
+	tests := []struct {
+		name    string
+		key     string
+		wantErr bool
+		weak    bool
+	}{
+		{"Empty-Key", "", true, true},
+		{"Short-Key", "tooshort", false, true},
+		{"Valid-Key", "thisisasigningkeythatislongenough", false, false},
+		{"Repeated-Character-Key", strings.Repeat("a", MinimumJWTKeyLength), false, true},
+		{"Low-Variety-Key", strings.Repeat("abc", MinimumJWTKeyLength), false, true},
+		{"PEM-Key", "-----BEGIN PUBLIC KEY-----\nMFkwEwYHKoZIzj0CAQYIKoZIzj0DAQcDQgAEZ2N0bWFrZXRoaXNsb25nZW5vdWdo\n-----END PUBLIC KEY-----\n", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := JwtConfigGenerate(tt.key, "temporal", nil, logger, 0); (err != nil) != tt.wantErr {
+				t.Fatalf("JwtConfigGenerate() err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err := validateJWTKey(tt.key); (err != nil) != tt.weak {
+				t.Fatalf("validateJWTKey() err = %v, weak %v", err, tt.weak)
+			}
+		})
+	}
+}
+
 func TestCORSMiddleware(t *testing.T) {
 	cors := CORSMiddleware(true, true, DefaultAllowedOrigins)
 	if reflect.TypeOf(cors).String() != "gin.HandlerFunc" {
@@ -98,7 +142,851 @@ func TestSecMiddleware(t *testing.T) {
 }
 
 func loadDatabase(cfg *config.TemporalConfig) (*database.Manager, error) {
-	return database.New(cfg, database.Options{
+	dbm, err := database.New(cfg, database.Options{
 		SSLModeDisable: true,
 	})
+	if err != nil {
+		return nil, err
+	}
+	// the api migrates account suspensions, which the authenticator checks
+	return dbm, dbm.DB.AutoMigrate(&AccountSuspension{}).Error
+}
+
+func TestSessionCookies(t *testing.T) {
+	testRecorder := httptest.NewRecorder()
+	_, engine := gin.CreateTestContext(testRecorder)
+	engine.POST("/login", SessionLogin(func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"token": "sometoken", "expire": time.Now().Add(time.Hour).Format(time.RFC3339)})
+	}, true))
+	engine.GET("/protected", SessionAuth(), func(c *gin.Context) {
+		c.String(http.StatusOK, c.GetHeader("Authorization"))
+	})
+	req := httptest.NewRequest("POST", "/login", nil)
+	engine.ServeHTTP(testRecorder, req)
+	if testRecorder.Code != http.StatusOK {
+		t.Fatal("bad status code from login")
+	}
+	if !strings.Contains(testRecorder.Body.String(), "sometoken") {
+		t.Fatal("login response body was modified")
+	}
+	var session, csrf *http.Cookie
+	for _, cookie := range testRecorder.Result().Cookies() {
+		switch cookie.Name {
+		case SessionCookieName:
+			session = cookie
+		case CSRFCookieName:
+			csrf = cookie
+		}
+	}
+	if session == nil || csrf == nil {
+		t.Fatal("failed to set session cookies")
+	}
+	if session.Value != "sometoken" || !session.HttpOnly || !session.Secure {
+		t.Fatal("bad session cookie")
+	}
+	if csrf.HttpOnly || csrf.Value == "" {
+		t.Fatal("bad csrf cookie")
+	}
+	tests := []struct {
+		name     string
+		csrf     string
+		wantCode int
+	}{
+		{"Valid-CSRF", csrf.Value, http.StatusOK},
+		{"Mismatched-CSRF", "notthetoken", http.StatusForbidden},
+		{"Missing-CSRF", "", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testRecorder := httptest.NewRecorder()
+			req := httptest.NewRequest("GET", "/protected", nil)
+			req.AddCookie(session)
+			req.AddCookie(csrf)
+			if tt.csrf != "" {
+				req.Header.Set(CSRFHeaderName, tt.csrf)
+			}
+			engine.ServeHTTP(testRecorder, req)
+			if testRecorder.Code != tt.wantCode {
+				t.Fatalf("got status %v, want %v", testRecorder.Code, tt.wantCode)
+			}
+			if tt.wantCode == http.StatusOK && testRecorder.Body.String() != "Bearer sometoken" {
+				t.Fatal("failed to bridge session cookie to authorization header")
+			}
+		})
+	}
+}
+
+func TestPasswordHasher_Rehash(t *testing.T) {
+	lowCost, err := bcrypt.GenerateFromPassword([]byte("password123"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name        string
+		cost        int
+		stored      string
+		wantChanged bool
+		wantHex     bool
+	}{
+		{"Disabled", 0, hex.EncodeToString(lowCost), false, true},
+		{"At-Cost", bcrypt.MinCost, hex.EncodeToString(lowCost), false, true},
+		{"Under-Cost-Hex", bcrypt.MinCost + 1, hex.EncodeToString(lowCost), true, true},
+		{"Under-Cost-Raw", bcrypt.MinCost + 1, string(lowCost), true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hash, changed, err := PasswordHasher{Cost: tt.cost}.Rehash(tt.stored, "password123")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if changed != tt.wantChanged {
+				t.Fatalf("Rehash() changed = %v, want %v", changed, tt.wantChanged)
+			}
+			if !changed {
+				if hash != tt.stored {
+					t.Fatal("unchanged hash should be returned as is")
+				}
+				return
+			}
+			decoded := []byte(hash)
+			if tt.wantHex {
+				if decoded, err = hex.DecodeString(hash); err != nil {
+					t.Fatal("hash encoding was not preserved")
+				}
+			}
+			if cost, err := bcrypt.Cost(decoded); err != nil || cost != tt.cost {
+				t.Fatalf("bad cost %v, err %v", cost, err)
+			}
+			if err := bcrypt.CompareHashAndPassword(decoded, []byte("password123")); err != nil {
+				t.Fatal("new hash does not match password")
+			}
+		})
+	}
+}
+
+func TestCORSMiddleware_Origins(t *testing.T) {
+	_, engine := gin.CreateTestContext(httptest.NewRecorder())
+	engine.Use(CORSMiddleware(false, false, []string{"https://temporal.cloud"}))
+	engine.GET("/foo", func(c *gin.Context) {
+		c.String(http.StatusOK, "hello")
+	})
+	tests := []struct {
+		name      string
+		method    string
+		origin    string
+		wantAllow bool
+	}{
+		{"Allowed-Origin", "GET", "https://temporal.cloud", true},
+		{"Disallowed-Origin", "GET", "https://example.org", false},
+		{"Allowed-Preflight", "OPTIONS", "https://temporal.cloud", true},
+		{"Disallowed-Preflight", "OPTIONS", "https://example.org", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testRecorder := httptest.NewRecorder()
+			req := httptest.NewRequest(tt.method, "/foo", nil)
+			req.Header.Set("Origin", tt.origin)
+			if tt.method == "OPTIONS" {
+				req.Header.Set("Access-Control-Request-Method", "GET")
+			}
+			engine.ServeHTTP(testRecorder, req)
+			allowed := testRecorder.Header().Get("Access-Control-Allow-Origin") == tt.origin
+			if allowed != tt.wantAllow {
+				t.Fatalf("origin allowed = %v, want %v", allowed, tt.wantAllow)
+			}
+		})
+	}
+}
+
+func TestJwtMiddleware_WWWAuthenticate(t *testing.T) {
+	logger := zaptest.NewLogger(t).Sugar()
+	jwt, err := JwtConfigGenerate("suchsecretmuchkeyverysecurewowsuchsecret", "temporal-test", nil, logger, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testRecorder := httptest.NewRecorder()
+	_, engine := gin.CreateTestContext(testRecorder)
+	engine.GET("/protected", jwt.MiddlewareFunc(), func(c *gin.Context) {
+		c.String(http.StatusOK, "hello")
+	})
+	engine.ServeHTTP(testRecorder, httptest.NewRequest("GET", "/protected", nil))
+	if testRecorder.Code != http.StatusUnauthorized {
+		t.Fatalf("got status %v, want %v", testRecorder.Code, http.StatusUnauthorized)
+	}
+	if got := testRecorder.Header().Get("WWW-Authenticate"); got != `Bearer realm="temporal-test"` {
+		t.Fatalf("got WWW-Authenticate header %q", got)
+	}
+}
+
+func TestHeaderLimits(t *testing.T) {
+	_, engine := gin.CreateTestContext(httptest.NewRecorder())
+	engine.Use(HeaderLimits(10))
+	engine.GET("/foo", func(c *gin.Context) {
+		c.String(http.StatusOK, "hello")
+	})
+	tests := []struct {
+		name     string
+		headers  int
+		wantCode int
+	}{
+		{"Within-Limit", 10, http.StatusOK},
+		{"Over-Limit", 11, http.StatusRequestHeaderFieldsTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testRecorder := httptest.NewRecorder()
+			req := httptest.NewRequest("GET", "/foo", nil)
+			for i := 0; i < tt.headers; i++ {
+				req.Header.Set(fmt.Sprintf("X-Test-%v", i), "value")
+			}
+			engine.ServeHTTP(testRecorder, req)
+			if testRecorder.Code != tt.wantCode {
+				t.Fatalf("got status %v, want %v", testRecorder.Code, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestCompress(t *testing.T) {
+	_, engine := gin.CreateTestContext(httptest.NewRecorder())
+	engine.Use(Compress(DefaultCompressMinSize))
+	// a large response, such as an account with many keys
+	var keys []string
+	for i := 0; i < 500; i++ {
+		keys = append(keys, fmt.Sprintf("ipfs-key-%v", i))
+	}
+	engine.GET("/large", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"code": http.StatusOK, "response": keys})
+	})
+	engine.GET("/small", func(c *gin.Context) {
+		c.String(http.StatusOK, "hello")
+	})
+	get := func(path, acceptEncoding string) *httptest.ResponseRecorder {
+		testRecorder := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", path, nil)
+		if acceptEncoding != "" {
+			req.Header.Set("Accept-Encoding", acceptEncoding)
+		}
+		engine.ServeHTTP(testRecorder, req)
+		return testRecorder
+	}
+	plain := get("/large", "")
+	if plain.Header().Get("Content-Encoding") != "" {
+		t.Fatal("response should not be compressed without opting in")
+	}
+	compressed := get("/large", "gzip, deflate")
+	if compressed.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatal("expected gzip content encoding")
+	}
+	if compressed.Body.Len() >= plain.Body.Len() {
+		t.Fatalf("compressed size %v is not smaller than %v", compressed.Body.Len(), plain.Body.Len())
+	}
+	gz, err := gzip.NewReader(compressed.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := ioutil.ReadAll(gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(body, plain.Body.Bytes()) {
+		t.Fatal("decompressed body does not match uncompressed response")
+	}
+	if resp := get("/large", "gzip;q=0"); resp.Header().Get("Content-Encoding") != "" {
+		t.Fatal("response should not be compressed when gzip is refused")
+	}
+	if resp := get("/small", "gzip"); resp.Header().Get("Content-Encoding") != "" || resp.Body.String() != "hello" {
+		t.Fatal("small responses should not be compressed")
+	}
+}
+
+func TestClaimsPayload(t *testing.T) {
+	cfg, err := config.LoadConfig("../../testenv/config.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := loadDatabase(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger := zaptest.NewLogger(t).Sugar()
+	jwt, err := JwtConfigGenerate(cfg.JWT.Key, cfg.JWT.Realm, db.DB, logger, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwt.PayloadFunc = ClaimsPayload(db.DB, func(username string) map[string]interface{} {
+		return map[string]interface{}{
+			"org_id": "org-" + username,
+			// reserved claims must not be overridden
+			"id":           "someoneelse",
+			"impersonator": "someoneelse",
+		}
+	})
+	_, engine := gin.CreateTestContext(httptest.NewRecorder())
+	engine.POST("/login", jwt.LoginHandler)
+	engine.GET("/claims", jwt.MiddlewareFunc(), func(c *gin.Context) {
+		c.JSON(http.StatusOK, ginjwt.ExtractClaims(c))
+	})
+	testRecorder := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/login", strings.NewReader(`{"username":"testuser","password":"admin"}`))
+	req.Header.Set("Content-Type", "application/json")
+	engine.ServeHTTP(testRecorder, req)
+	if testRecorder.Code != http.StatusOK {
+		t.Fatalf("failed to login, status %v", testRecorder.Code)
+	}
+	var login struct {
+		Token string `json:"token"`
+	}
+	if err := json.Unmarshal(testRecorder.Body.Bytes(), &login); err != nil {
+		t.Fatal(err)
+	}
+	testRecorder = httptest.NewRecorder()
+	req = httptest.NewRequest("GET", "/claims", nil)
+	req.Header.Set("Authorization", "Bearer "+login.Token)
+	engine.ServeHTTP(testRecorder, req)
+	if testRecorder.Code != http.StatusOK {
+		t.Fatalf("failed to authenticate, status %v", testRecorder.Code)
+	}
+	var claims map[string]interface{}
+	if err := json.Unmarshal(testRecorder.Body.Bytes(), &claims); err != nil {
+		t.Fatal(err)
+	}
+	if claims["org_id"] != "org-testuser" {
+		t.Fatalf("custom claim not found, got %v", claims["org_id"])
+	}
+	if claims["id"] != "testuser" {
+		t.Fatalf("id claim was overridden, got %v", claims["id"])
+	}
+	if _, ok := claims["impersonator"]; ok {
+		t.Fatal("impersonator claim should not be set")
+	}
+}
+
+func TestLoadJWTKey(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jwtkey")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	validKey := filepath.Join(dir, "valid")
+	if err := ioutil.WriteFile(validKey, []byte("thisisasigningkeythatislongenough\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	shortKey := filepath.Join(dir, "short")
+	if err := ioutil.WriteFile(shortKey, []byte("tooshort"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	repeatedKey := filepath.Join(dir, "repeated")
+	if err := ioutil.WriteFile(repeatedKey, []byte(strings.Repeat("x", 64)), 0600); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		file    string
+		env     string
+		wantKey string
+		wantErr bool
+	}{
+		{"Configured", "", "", "configuredkey", false},
+		{"File", validKey, "", "thisisasigningkeythatislongenough", false},
+		{"File-Too-Short", shortKey, "", "", true},
+		{"File-Low-Entropy", repeatedKey, "", "", true},
+		{"File-Missing", filepath.Join(dir, "missing"), "", "", true},
+		{"Env", "", "thisisanothersigningkeylongenough", "thisisanothersigningkeylongenough", false},
+		{"Env-Too-Short", "", "tooshort", "", true},
+		{"File-Over-Env", validKey, "thisisanothersigningkeylongenough", "thisisasigningkeythatislongenough", false},
+	}
+	defer os.Unsetenv(JWTKeyFileEnv)
+	defer os.Unsetenv(JWTKeyEnv)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			os.Setenv(JWTKeyFileEnv, tt.file)
+			os.Setenv(JWTKeyEnv, tt.env)
+			key, err := LoadJWTKey("configuredkey")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LoadJWTKey() err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && key != tt.wantKey {
+				t.Fatalf("LoadJWTKey() = %v, want %v", key, tt.wantKey)
+			}
+		})
+	}
+}
+
+func TestSigningKey(t *testing.T) {
+	const (
+		oldKey = "suchsecretmuchkeyverysecurewowsuchsecret"
+		newKey = "anothersecretkeythatisjustaslongandsecure"
+	)
+	logger := zaptest.NewLogger(t).Sugar()
+	mw, err := JwtConfigGenerate(oldKey, "temporal-test", nil, logger, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// avoid hitting the database
+	mw.Authenticator = func(userID, password string, c *gin.Context) (string, bool) { return userID, true }
+	mw.Authorizator = func(userID string, c *gin.Context) bool { return true }
+	sk := NewSigningKey(oldKey)
+	_, engine := gin.CreateTestContext(httptest.NewRecorder())
+	engine.POST("/login", sk.Bind(mw, func(mw *ginjwt.GinJWTMiddleware) gin.HandlerFunc {
+		return mw.LoginHandler
+	}))
+	engine.GET("/hello", sk.Bind(mw, (*ginjwt.GinJWTMiddleware).MiddlewareFunc), func(c *gin.Context) {
+		c.String(http.StatusOK, "hello")
+	})
+	login := func() string {
+		rec := httptest.NewRecorder()
+		engine.ServeHTTP(rec, httptest.NewRequest("POST", "/login", strings.NewReader(`{"username":"testuser","password":"admin"}`)))
+		var resp struct {
+			Token string `json:"token"`
+		}
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		return resp.Token
+	}
+	hello := func(token string) int {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/hello", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		engine.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	oldToken := login()
+	if code := hello(oldToken); code != http.StatusOK {
+		t.Fatalf("expected status %v, got %v", http.StatusOK, code)
+	}
+	// weak keys are rejected, leaving the current key in place
+	if err := sk.Replace(strings.Repeat("x", 64)); err == nil {
+		t.Fatal("expected weak key to be rejected")
+	}
+	if code := hello(oldToken); code != http.StatusOK {
+		t.Fatalf("expected status %v, got %v", http.StatusOK, code)
+	}
+	if err := sk.Replace(newKey); err != nil {
+		t.Fatal(err)
+	}
+	if sk.Current() != newKey || sk.Previous() != oldKey {
+		t.Fatalf("unexpected keys after replacement: %s, %s", sk.Current(), sk.Previous())
+	}
+	// tokens signed with the replaced key are rejected, without rebuilding the middleware
+	if code := hello(oldToken); code != http.StatusUnauthorized {
+		t.Fatalf("expected status %v, got %v", http.StatusUnauthorized, code)
+	}
+	if code := hello(login()); code != http.StatusOK {
+		t.Fatalf("expected status %v, got %v", http.StatusOK, code)
+	}
+}
+
+func TestAudienceLogin(t *testing.T) {
+	logger := zaptest.NewLogger(t).Sugar()
+	jwt, err := JwtConfigGenerate("suchsecretmuchkeyverysecurewowsuchsecret", "temporal-test", nil, logger, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// avoid hitting the database
+	jwt.Authenticator = func(userID, password string, c *gin.Context) (string, bool) { return userID, true }
+	jwt.Authorizator = func(userID string, c *gin.Context) bool { return true }
+	_, engine := gin.CreateTestContext(httptest.NewRecorder())
+	engine.POST("/login", AudienceLogin(jwt, []string{"web", "mobile", "cli"}))
+	engine.GET("/cli", jwt.MiddlewareFunc(), RequireAudience("cli"), func(c *gin.Context) {
+		c.String(http.StatusOK, "hello")
+	})
+	engine.GET("/web", jwt.MiddlewareFunc(), RequireAudience("web", "mobile"), func(c *gin.Context) {
+		c.String(http.StatusOK, "hello")
+	})
+	login := func(clientType string) (int, string) {
+		body := fmt.Sprintf(`{"username":"testuser","password":"admin","client_type":%q}`, clientType)
+		testRecorder := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "/login", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		engine.ServeHTTP(testRecorder, req)
+		var resp struct {
+			Token string `json:"token"`
+		}
+		json.Unmarshal(testRecorder.Body.Bytes(), &resp)
+		return testRecorder.Code, resp.Token
+	}
+	if code, _ := login("toaster"); code != http.StatusBadRequest {
+		t.Fatalf("got status %v for an unknown client type, want %v", code, http.StatusBadRequest)
+	}
+	code, webToken := login("web")
+	if code != http.StatusOK || webToken == "" {
+		t.Fatalf("got status %v for a web login, want %v", code, http.StatusOK)
+	}
+	code, noAudience := login("")
+	if code != http.StatusOK || noAudience == "" {
+		t.Fatalf("got status %v for a login without a client type, want %v", code, http.StatusOK)
+	}
+	tests := []struct {
+		name     string
+		path     string
+		token    string
+		wantCode int
+	}{
+		{"Web-Token-Web-Route", "/web", webToken, http.StatusOK},
+		{"Web-Token-CLI-Route", "/cli", webToken, http.StatusForbidden},
+		{"No-Audience-CLI-Route", "/cli", noAudience, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testRecorder := httptest.NewRecorder()
+			req := httptest.NewRequest("GET", tt.path, nil)
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+			engine.ServeHTTP(testRecorder, req)
+			if testRecorder.Code != tt.wantCode {
+				t.Fatalf("got status %v, want %v", testRecorder.Code, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestTTLLogin(t *testing.T) {
+	logger := zaptest.NewLogger(t).Sugar()
+	jwt, err := JwtConfigGenerate("suchsecretmuchkeyverysecurewowsuchsecret", "temporal-test", nil, logger, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// avoid hitting the database
+	jwt.Authenticator = func(userID, password string, c *gin.Context) (string, bool) { return userID, true }
+	jwt.Authorizator = func(userID string, c *gin.Context) bool { return true }
+	bounds := func(userID string) (TTLBounds, bool) {
+		return TTLBounds{Min: time.Minute, Max: time.Hour}, userID == "testuser"
+	}
+	loginHandler := func(mw *ginjwt.GinJWTMiddleware) gin.HandlerFunc { return mw.LoginHandler }
+	tests := []struct {
+		name       string
+		username   string
+		ttl        int64
+		clamp      bool
+		wantCode   int
+		wantExpire time.Duration
+	}{
+		{"Default", "testuser", 0, false, http.StatusOK, jwt.Timeout},
+		{"Within-Range", "testuser", 600, false, http.StatusOK, 10 * time.Minute},
+		{"Below-Min", "testuser", 30, false, http.StatusBadRequest, 0},
+		{"Above-Max", "testuser", 7200, false, http.StatusBadRequest, 0},
+		{"Below-Min-Clamped", "testuser", 30, true, http.StatusOK, time.Minute},
+		{"Above-Max-Clamped", "testuser", 7200, true, http.StatusOK, time.Hour},
+		{"No-Bounds", "otheruser", 600, true, http.StatusBadRequest, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, engine := gin.CreateTestContext(httptest.NewRecorder())
+			engine.POST("/login", TTLLogin(jwt, bounds, tt.clamp, loginHandler))
+			body := fmt.Sprintf(`{"username":%q,"password":"admin","ttl":%d}`, tt.username, tt.ttl)
+			testRecorder := httptest.NewRecorder()
+			req := httptest.NewRequest("POST", "/login", strings.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			now := time.Now()
+			engine.ServeHTTP(testRecorder, req)
+			if testRecorder.Code != tt.wantCode {
+				t.Fatalf("got status %v, want %v", testRecorder.Code, tt.wantCode)
+			}
+			if tt.wantCode != http.StatusOK {
+				return
+			}
+			var resp struct {
+				Expire time.Time `json:"expire"`
+			}
+			if err := json.Unmarshal(testRecorder.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			// the expiry is only reported to the second
+			if lifetime := resp.Expire.Sub(now); lifetime < tt.wantExpire-time.Second || lifetime > tt.wantExpire+time.Second {
+				t.Fatalf("got token lifetime %v, want %v", lifetime, tt.wantExpire)
+			}
+		})
+	}
+	// the default middleware is left untouched
+	if jwt.Timeout != time.Hour*24 {
+		t.Fatal("login changed the middleware timeout")
+	}
+}
+
+func TestMinimalClaimsPayload(t *testing.T) {
+	logger := zaptest.NewLogger(t).Sugar()
+	payload := func(userID string) map[string]interface{} {
+		return map[string]interface{}{
+			"org_id":      "suchorganizationmuchlongidentifier",
+			"permissions": []string{"upload", "pin", "ipns", "ens", "swarm", "database"},
+		}
+	}
+	store := NewMemoryClaimsStore()
+	engines := map[string]*gin.Engine{}
+	for mode, minimal := range map[string]bool{"full": false, "minimal": true} {
+		jwt, err := JwtConfigGenerate("suchsecretmuchkeyverysecurewowsuchsecret", "temporal-test", nil, logger, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// avoid hitting the database
+		jwt.Authenticator = func(userID, password string, c *gin.Context) (string, bool) { return userID, true }
+		jwt.Authorizator = func(userID string, c *gin.Context) bool { return true }
+		jwt.PayloadFunc = payload
+		authware := []gin.HandlerFunc{jwt.MiddlewareFunc()}
+		if minimal {
+			jwt.PayloadFunc = MinimalClaimsPayload(jwt.PayloadFunc, store, jwt.Timeout+jwt.MaxRefresh)
+			authware = append(authware, ResolveClaims(store))
+		}
+		_, engine := gin.CreateTestContext(httptest.NewRecorder())
+		engine.POST("/login", jwt.LoginHandler)
+		engine.GET("/org", append(authware, func(c *gin.Context) {
+			org, _ := ginjwt.ExtractClaims(c)["org_id"].(string)
+			c.String(http.StatusOK, org)
+		})...)
+		engines[mode] = engine
+	}
+	tokens := map[string]string{}
+	for mode, engine := range engines {
+		testRecorder := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "/login", strings.NewReader(`{"username":"testuser","password":"admin"}`))
+		req.Header.Set("Content-Type", "application/json")
+		engine.ServeHTTP(testRecorder, req)
+		var resp struct {
+			Token string `json:"token"`
+		}
+		if err := json.Unmarshal(testRecorder.Body.Bytes(), &resp); err != nil || resp.Token == "" {
+			t.Fatalf("failed to sign in with %s tokens: %s", mode, testRecorder.Body.String())
+		}
+		tokens[mode] = resp.Token
+	}
+	if len(tokens["minimal"]) >= len(tokens["full"]) {
+		t.Fatalf("minimal token is %v bytes, full token is %v bytes", len(tokens["minimal"]), len(tokens["full"]))
+	}
+	// omitted claims are resolved from the store
+	for mode, engine := range engines {
+		testRecorder := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/org", nil)
+		req.Header.Set("Authorization", "Bearer "+tokens[mode])
+		engine.ServeHTTP(testRecorder, req)
+		if testRecorder.Code != http.StatusOK || testRecorder.Body.String() != "suchorganizationmuchlongidentifier" {
+			t.Fatalf("%s token resolved org_id %q with status %v", mode, testRecorder.Body.String(), testRecorder.Code)
+		}
+	}
+	// minimal tokens are rejected once their claims are unavailable
+	testRecorder := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/org", nil)
+	req.Header.Set("Authorization", "Bearer "+tokens["minimal"])
+	store.entries = map[string]storedClaims{}
+	engines["minimal"].ServeHTTP(testRecorder, req)
+	if testRecorder.Code != http.StatusUnauthorized {
+		t.Fatalf("got status %v for a token with missing claims, want %v", testRecorder.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestStatefulSessions(t *testing.T) {
+	logger := zaptest.NewLogger(t).Sugar()
+	store := NewMemorySessionStore()
+	jwt, err := JwtConfigGenerate("suchsecretmuchkeyverysecurewowsuchsecret", "temporal-test", nil, logger, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// avoid hitting the database
+	jwt.Authenticator = func(userID, password string, c *gin.Context) (string, bool) { return userID, true }
+	jwt.Authorizator = func(userID string, c *gin.Context) bool { return true }
+	jwt.PayloadFunc = StatefulPayload(jwt.PayloadFunc, store, jwt.Timeout+jwt.MaxRefresh, nil)
+	_, engine := gin.CreateTestContext(httptest.NewRecorder())
+	engine.POST("/login", jwt.LoginHandler)
+	engine.GET("/session", jwt.MiddlewareFunc(), RequireSession(store), func(c *gin.Context) {
+		sid, _ := ginjwt.ExtractClaims(c)["sid"].(string)
+		c.String(http.StatusOK, sid)
+	})
+	login := func() string {
+		testRecorder := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "/login", strings.NewReader(`{"username":"testuser","password":"admin"}`))
+		req.Header.Set("Content-Type", "application/json")
+		engine.ServeHTTP(testRecorder, req)
+		var resp struct {
+			Token string `json:"token"`
+		}
+		if err := json.Unmarshal(testRecorder.Body.Bytes(), &resp); err != nil || resp.Token == "" {
+			t.Fatalf("failed to sign in: %s", testRecorder.Body.String())
+		}
+		return resp.Token
+	}
+	validate := func(token string) (int, string) {
+		testRecorder := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/session", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		engine.ServeHTTP(testRecorder, req)
+		return testRecorder.Code, testRecorder.Body.String()
+	}
+	// each sign in creates a session
+	first, second := login(), login()
+	sessions, err := store.ListByUser("testuser")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 2 {
+		t.Fatalf("got %v sessions, want 2", len(sessions))
+	}
+	code, sid := validate(first)
+	if code != http.StatusOK || (sid != sessions[0].ID && sid != sessions[1].ID) {
+		t.Fatalf("got status %v and unknown session %q", code, sid)
+	}
+	// revoking a session only invalidates its own tokens
+	if err := store.Revoke(sid); err != nil {
+		t.Fatal(err)
+	}
+	if code, _ := validate(first); code != http.StatusUnauthorized {
+		t.Fatalf("got status %v for a revoked session, want %v", code, http.StatusUnauthorized)
+	}
+	if code, _ := validate(second); code != http.StatusOK {
+		t.Fatalf("got status %v for an active session, want %v", code, http.StatusOK)
+	}
+	remaining, _ := store.ListByUser("testuser")
+	if len(remaining) != 1 {
+		t.Fatalf("got %v sessions after revoking one, want 1", len(remaining))
+	}
+	// sessions only validate tokens of the user they belong to
+	if err := CheckSession(store, remaining[0].ID, "otheruser"); err == nil {
+		t.Fatal("expected session of another user to be rejected")
+	}
+	// tokens issued without a session are rejected
+	jwt.PayloadFunc = nil
+	if code, _ := validate(login()); code != http.StatusUnauthorized {
+		t.Fatalf("got status %v for a token without a session, want %v", code, http.StatusUnauthorized)
+	}
+}
+
+func TestStatefulSessions_EmailLogin(t *testing.T) {
+	cfg, err := config.LoadConfig("../../testenv/config.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := loadDatabase(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger := zaptest.NewLogger(t).Sugar()
+	store := NewMemorySessionStore()
+	jwt, err := JwtConfigGenerate(cfg.JWT.Key, cfg.JWT.Realm, db.DB, logger, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwt.PayloadFunc = StatefulPayload(jwt.PayloadFunc, store, jwt.Timeout+jwt.MaxRefresh, func(userID string) string {
+		return ResolveUserName(db.DB, userID)
+	})
+	_, engine := gin.CreateTestContext(httptest.NewRecorder())
+	engine.POST("/login", jwt.LoginHandler)
+	engine.GET("/session", jwt.MiddlewareFunc(), RequireSession(store), func(c *gin.Context) {
+		c.String(http.StatusOK, ginjwt.ExtractClaims(c)["id"].(string))
+	})
+	// sign in with the email address of testuser
+	testRecorder := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/login", strings.NewReader(`{"username":"test@email.com","password":"admin"}`))
+	req.Header.Set("Content-Type", "application/json")
+	engine.ServeHTTP(testRecorder, req)
+	var resp struct {
+		Token string `json:"token"`
+	}
+	if err := json.Unmarshal(testRecorder.Body.Bytes(), &resp); err != nil || resp.Token == "" {
+		t.Fatalf("failed to sign in: %s", testRecorder.Body.String())
+	}
+	// the session belongs to the username rather than the email address
+	sessions, err := store.ListByUser("testuser")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 1 {
+		t.Fatalf("got %v sessions for testuser, want 1", len(sessions))
+	}
+	testRecorder = httptest.NewRecorder()
+	req = httptest.NewRequest("GET", "/session", nil)
+	req.Header.Set("Authorization", "Bearer "+resp.Token)
+	engine.ServeHTTP(testRecorder, req)
+	if testRecorder.Code != http.StatusOK || testRecorder.Body.String() != "testuser" {
+		t.Fatalf("got status %v and id %q, want %v and testuser", testRecorder.Code, testRecorder.Body.String(), http.StatusOK)
+	}
+}
+
+func TestValidateFields(t *testing.T) {
+	rules := []FieldRule{
+		{Name: "username", Required: true, MaxLength: 8},
+		{Name: "email_address", Email: true},
+	}
+	var entered bool
+	handler := func(c *gin.Context) {
+		entered = true
+		c.String(http.StatusOK, "hello")
+	}
+	testRecorder := httptest.NewRecorder()
+	_, engine := gin.CreateTestContext(testRecorder)
+	engine.POST("/json", ValidateJSON(rules...), func(c *gin.Context) {
+		// the body must remain readable by the handler
+		var body map[string]string
+		if err := c.ShouldBindJSON(&body); err != nil || body["username"] == "" {
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		handler(c)
+	})
+	engine.POST("/form", ValidateForm(rules...), handler)
+	tests := []struct {
+		name        string
+		path        string
+		body        string
+		wantCode    int
+		wantInvalid string
+	}{
+		{"JSON-Valid", "/json", `{"username":"testuser","password":"admin"}`, http.StatusOK, ""},
+		{"JSON-Empty-Username", "/json", `{"username":"","password":"admin"}`, http.StatusBadRequest, "username"},
+		{"JSON-Missing-Username", "/json", `{"password":"admin"}`, http.StatusBadRequest, "username"},
+		{"JSON-Long-Username", "/json", `{"username":"testuser1"}`, http.StatusBadRequest, "username"},
+		{"JSON-Bad-Email", "/json", `{"username":"testuser","email_address":"Test <test@example.org>"}`, http.StatusBadRequest, "email_address"},
+		{"Form-Valid", "/form", "username=testuser&email_address=test%40example.org", http.StatusOK, ""},
+		{"Form-Empty-Username", "/form", "username=&email_address=test%40example.org", http.StatusBadRequest, "username"},
+		{"Form-Bad-Email", "/form", "username=testuser&email_address=notanemail", http.StatusBadRequest, "email_address"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entered = false
+			testRecorder := httptest.NewRecorder()
+			req := httptest.NewRequest("POST", tt.path, strings.NewReader(tt.body))
+			if tt.path == "/form" {
+				req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			} else {
+				req.Header.Set("Content-Type", "application/json")
+			}
+			engine.ServeHTTP(testRecorder, req)
+			if testRecorder.Code != tt.wantCode {
+				t.Fatalf("got status %v, want %v", testRecorder.Code, tt.wantCode)
+			}
+			if entered != (tt.wantCode == http.StatusOK) {
+				t.Fatalf("handler entered = %v", entered)
+			}
+			if tt.wantInvalid != "" {
+				var resp struct {
+					Fields map[string]string `json:"fields"`
+				}
+				if err := json.Unmarshal(testRecorder.Body.Bytes(), &resp); err != nil {
+					t.Fatal(err)
+				}
+				if resp.Fields[tt.wantInvalid] == "" {
+					t.Fatalf("expected %s to be reported as invalid, got %v", tt.wantInvalid, resp.Fields)
+				}
+			}
+		})
+	}
+}
+
+func TestSignOutEverywhere(t *testing.T) {
+	store := NewMemorySignOutStore()
+	issued := time.Now().Unix()
+	if err := CheckSignOut(store, "testuser", issued); err != nil {
+		t.Fatal("tokens of users who never signed out should be valid")
+	}
+	at, err := SignOutEverywhere(store, "testuser")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckSignOut(store, "testuser", issued); err == nil {
+		t.Fatal("tokens issued before signing out should be invalid")
+	}
+	if err := CheckSignOut(store, "testuser", at.Unix()); err != nil {
+		t.Fatal("tokens issued as of signing out should be valid")
+	}
+	if err := CheckSignOut(store, "otheruser", issued); err != nil {
+		t.Fatal("signing out should only affect the user signing out")
+	}
 }
@@ -0,0 +1,119 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	jwt "github.com/appleboy/gin-jwt"
+	"github.com/gin-gonic/gin"
+	gojwt "gopkg.in/dgrijalva/jwt-go.v3"
+)
+
+// ClaimsStore holds the claims omitted from minimal tokens, keyed by their jti claim
+type ClaimsStore interface {
+	Put(jti string, claims map[string]interface{}, expires time.Time) error
+	Get(jti string) (map[string]interface{}, error)
+}
+
+// MemoryClaimsStore is a ClaimsStore held in memory. Minimal tokens
+// can only be validated by the api instance that issued them
+type MemoryClaimsStore struct {
+	mux     sync.RWMutex
+	entries map[string]storedClaims
+}
+
+type storedClaims struct {
+	claims  map[string]interface{}
+	expires time.Time
+}
+
+// NewMemoryClaimsStore returns an empty MemoryClaimsStore
+func NewMemoryClaimsStore() *MemoryClaimsStore {
+	return &MemoryClaimsStore{entries: make(map[string]storedClaims)}
+}
+
+// Put stores claims under jti until expires, removing any expired entries
+func (ms *MemoryClaimsStore) Put(jti string, claims map[string]interface{}, expires time.Time) error {
+	ms.mux.Lock()
+	defer ms.mux.Unlock()
+	now := time.Now()
+	for id, entry := range ms.entries {
+		if now.After(entry.expires) {
+			delete(ms.entries, id)
+		}
+	}
+	ms.entries[jti] = storedClaims{claims: claims, expires: expires}
+	return nil
+}
+
+// Get returns the claims stored under jti
+func (ms *MemoryClaimsStore) Get(jti string) (map[string]interface{}, error) {
+	ms.mux.RLock()
+	defer ms.mux.RUnlock()
+	entry, ok := ms.entries[jti]
+	if !ok || time.Now().After(entry.expires) {
+		return nil, errors.New("claims not found")
+	}
+	return entry.claims, nil
+}
+
+// MinimalClaimsPayload wraps a payload function so that issued tokens only
+// carry the claims set by the jwt middleware itself, along with a jti claim
+// referencing the rest of the payload held in store. Claims are retained for
+// ttl, which must cover the refresh window as refreshed tokens keep their jti.
+// If the claims can't be stored the full payload is embedded instead
+func MinimalClaimsPayload(payload func(userID string) map[string]interface{}, store ClaimsStore, ttl time.Duration) func(userID string) map[string]interface{} {
+	return func(userID string) map[string]interface{} {
+		if payload == nil {
+			return nil
+		}
+		claims := payload(userID)
+		if len(claims) == 0 {
+			return claims
+		}
+		jti, err := newRandomToken(16)
+		if err != nil {
+			return claims
+		}
+		if err := store.Put(jti, claims, time.Now().Add(ttl)); err != nil {
+			return claims
+		}
+		return map[string]interface{}{"jti": jti}
+	}
+}
+
+// ResolveClaims restores the claims omitted from minimal tokens, so that they
+// are available to handlers as if they had been embedded in the token. It must
+// run after the jwt middleware
+func ResolveClaims(store ClaimsStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims := jwt.ExtractClaims(c)
+		jti, _ := claims["jti"].(string)
+		if jti == "" {
+			c.Next()
+			return
+		}
+		stored, err := store.Get(jti)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+				"code":    http.StatusUnauthorized,
+				"message": "token claims are no longer available, please sign in again",
+			})
+			return
+		}
+		resolved := make(gojwt.MapClaims, len(claims)+len(stored))
+		for name, value := range stored {
+			if !reservedClaims[name] {
+				resolved[name] = value
+			}
+		}
+		// claims within the token take precedence
+		for name, value := range claims {
+			resolved[name] = value
+		}
+		c.Set("JWT_PAYLOAD", resolved)
+		c.Next()
+	}
+}
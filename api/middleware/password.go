@@ -0,0 +1,45 @@
+package middleware
+
+import (
+	"encoding/hex"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// PasswordHasher is used to upgrade stored password hashes
+// to a target bcrypt cost as users sign in
+type PasswordHasher struct {
+	// Cost is the target bcrypt cost, a value of 0 disables re-hashing
+	Cost int
+}
+
+// Rehash returns a new hash of password at the target cost, if the stored hash
+// is below it. The new hash uses the same encoding as the stored one. The
+// returned bool indicates whether or not the hash was changed, and password
+// must already have been validated against the stored hash
+func (ph PasswordHasher) Rehash(stored, password string) (string, bool, error) {
+	if ph.Cost == 0 {
+		return stored, false, nil
+	}
+	// hashes may be stored either hex encoded or raw
+	hash, err := hex.DecodeString(stored)
+	hexEncoded := err == nil
+	if !hexEncoded {
+		hash = []byte(stored)
+	}
+	cost, err := bcrypt.Cost(hash)
+	if err != nil {
+		return stored, false, err
+	}
+	if cost >= ph.Cost {
+		return stored, false, nil
+	}
+	newHash, err := bcrypt.GenerateFromPassword([]byte(password), ph.Cost)
+	if err != nil {
+		return stored, false, err
+	}
+	if hexEncoded {
+		return hex.EncodeToString(newHash), true, nil
+	}
+	return string(newHash), true, nil
+}
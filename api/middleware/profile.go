@@ -0,0 +1,61 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ProfileRequest holds the fields of a login request which select
+// whether the profile of the user signing in is included in the response
+type ProfileRequest struct {
+	Username       string `json:"username"`
+	IncludeProfile bool   `json:"include_profile"`
+	// OmitIPFS leaves the ipfs keys of the account out of the profile,
+	// keeping the response small for accounts with many keys
+	OmitIPFS bool `json:"omit_ipfs"`
+}
+
+// ProfileLogin wraps the jwt login handler, adding the profile returned by
+// profile to successful login responses which set the include_profile field,
+// saving clients from fetching it separately. The token is still returned if
+// the profile can't be found, as it has already been issued
+func ProfileLogin(login gin.HandlerFunc, profile func(ProfileRequest) (interface{}, error)) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var request ProfileRequest
+		// leave reporting malformed requests to the login handler
+		if err := peekLogin(c, &request); err != nil || !request.IncludeProfile {
+			login(c)
+			return
+		}
+		bw := &bufferedWriter{ResponseWriter: c.Writer}
+		c.Writer = bw
+		login(c)
+		c.Writer = bw.ResponseWriter
+		body := bw.buf.Bytes()
+		if c.Writer.Status() == http.StatusOK {
+			body = withProfile(body, request, profile)
+		}
+		c.Writer.Write(body)
+	}
+}
+
+// withProfile returns the login response body with the profile
+// added, or the body unchanged if the profile can't be added
+func withProfile(body []byte, request ProfileRequest, profile func(ProfileRequest) (interface{}, error)) []byte {
+	var resp map[string]interface{}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return body
+	}
+	user, err := profile(request)
+	if err != nil {
+		return body
+	}
+	resp["profile"] = user
+	out, err := json.Marshal(resp)
+	if err != nil {
+		return body
+	}
+	return out
+}
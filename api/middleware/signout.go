@@ -0,0 +1,87 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	jwt "github.com/appleboy/gin-jwt"
+	"github.com/gin-gonic/gin"
+)
+
+// SignOutStore records when users signed out everywhere,
+// invalidating every token issued to them before then
+type SignOutStore interface {
+	SignOut(user string, at time.Time) error
+	// SignedOutAt returns when user last signed out everywhere,
+	// which is the zero time if they never have
+	SignedOutAt(user string) (time.Time, error)
+}
+
+// MemorySignOutStore is a SignOutStore held in memory. Sign outs only
+// invalidate tokens used with the api instance that recorded them
+type MemorySignOutStore struct {
+	mux       sync.RWMutex
+	signedOut map[string]time.Time
+}
+
+// NewMemorySignOutStore returns an empty MemorySignOutStore
+func NewMemorySignOutStore() *MemorySignOutStore {
+	return &MemorySignOutStore{signedOut: make(map[string]time.Time)}
+}
+
+// SignOut records that user signed out everywhere at the given time
+func (ms *MemorySignOutStore) SignOut(user string, at time.Time) error {
+	ms.mux.Lock()
+	defer ms.mux.Unlock()
+	ms.signedOut[user] = at
+	return nil
+}
+
+// SignedOutAt returns when user last signed out everywhere
+func (ms *MemorySignOutStore) SignedOutAt(user string) (time.Time, error) {
+	ms.mux.RLock()
+	defer ms.mux.RUnlock()
+	return ms.signedOut[user], nil
+}
+
+// SignOutEverywhere invalidates every token issued to user so far, returning
+// the time tokens must be issued at to remain valid. Tokens record when they
+// were issued to the second, so those issued during the current second can't
+// be told apart from earlier ones and are invalidated too
+func SignOutEverywhere(store SignOutStore, user string) (time.Time, error) {
+	at := time.Now().Truncate(time.Second).Add(time.Second)
+	return at, store.SignOut(user, at)
+}
+
+// RejectSignedOut rejects tokens issued before their user signed out
+// everywhere. It must run after the jwt middleware
+func RejectSignedOut(store SignOutStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims := jwt.ExtractClaims(c)
+		user, _ := claims["id"].(string)
+		issuedAt, _ := claims["orig_iat"].(float64)
+		if err := CheckSignOut(store, user, int64(issuedAt)); err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+				"code":    http.StatusUnauthorized,
+				"message": "session is no longer valid, please sign in again",
+			})
+			return
+		}
+		c.Next()
+	}
+}
+
+// CheckSignOut ensures user hasn't signed out everywhere since the session
+// of a token issued at the unix time issuedAt was started
+func CheckSignOut(store SignOutStore, user string, issuedAt int64) error {
+	signedOut, err := store.SignedOutAt(user)
+	if err != nil {
+		return err
+	}
+	if issuedAt < signedOut.Unix() {
+		return errors.New("user signed out after the token was issued")
+	}
+	return nil
+}
@@ -0,0 +1,164 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	jwt "github.com/appleboy/gin-jwt"
+	"github.com/gin-gonic/gin"
+)
+
+// Session is a sign in tracked by a SessionStore, referenced
+// by the sid claim of the tokens issued for it
+type Session struct {
+	ID      string    `json:"id"`
+	User    string    `json:"user"`
+	Created time.Time `json:"created"`
+	Expires time.Time `json:"expires"`
+}
+
+// SessionStore tracks the sessions of stateful tokens, allowing
+// them to be listed and revoked before they expire
+type SessionStore interface {
+	Create(session Session) error
+	// Get returns the session with the given id, failing
+	// if it doesn't exist, has expired or was revoked
+	Get(id string) (Session, error)
+	Revoke(id string) error
+	// ListByUser returns the active sessions of user, oldest first
+	ListByUser(user string) ([]Session, error)
+}
+
+// MemorySessionStore is a SessionStore held in memory. Sessions
+// can only be validated by the api instance that created them
+type MemorySessionStore struct {
+	mux      sync.RWMutex
+	sessions map[string]Session
+}
+
+// NewMemorySessionStore returns an empty MemorySessionStore
+func NewMemorySessionStore() *MemorySessionStore {
+	return &MemorySessionStore{sessions: make(map[string]Session)}
+}
+
+// Create stores session, removing any expired sessions
+func (ms *MemorySessionStore) Create(session Session) error {
+	ms.mux.Lock()
+	defer ms.mux.Unlock()
+	now := time.Now()
+	for id, s := range ms.sessions {
+		if now.After(s.Expires) {
+			delete(ms.sessions, id)
+		}
+	}
+	ms.sessions[session.ID] = session
+	return nil
+}
+
+// Get returns the session with the given id
+func (ms *MemorySessionStore) Get(id string) (Session, error) {
+	ms.mux.RLock()
+	defer ms.mux.RUnlock()
+	session, ok := ms.sessions[id]
+	if !ok || time.Now().After(session.Expires) {
+		return Session{}, errors.New("session not found")
+	}
+	return session, nil
+}
+
+// Revoke removes the session with the given id
+func (ms *MemorySessionStore) Revoke(id string) error {
+	ms.mux.Lock()
+	defer ms.mux.Unlock()
+	delete(ms.sessions, id)
+	return nil
+}
+
+// ListByUser returns the active sessions of user
+func (ms *MemorySessionStore) ListByUser(user string) ([]Session, error) {
+	ms.mux.RLock()
+	defer ms.mux.RUnlock()
+	now := time.Now()
+	var sessions []Session
+	for _, s := range ms.sessions {
+		if s.User == user && !now.After(s.Expires) {
+			sessions = append(sessions, s)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].Created.Before(sessions[j].Created) })
+	return sessions, nil
+}
+
+// StartSession creates a session for user lasting ttl in store
+func StartSession(store SessionStore, user string, ttl time.Duration) (Session, error) {
+	id, err := newRandomToken(16)
+	if err != nil {
+		return Session{}, err
+	}
+	now := time.Now()
+	session := Session{ID: id, User: user, Created: now, Expires: now.Add(ttl)}
+	return session, store.Create(session)
+}
+
+// StatefulPayload wraps a payload function so that every issued token starts
+// a session in store, referenced by its sid claim. Sessions last for ttl, which
+// must cover the refresh window as refreshed tokens keep their sid. Sessions
+// belong to the username resolve returns for the submitted login id, which must
+// match the id claim set from the Authenticator. If the session can't be
+// created the token is issued without one, which RequireSession rejects
+func StatefulPayload(payload func(userID string) map[string]interface{}, store SessionStore, ttl time.Duration, resolve func(userID string) string) func(userID string) map[string]interface{} {
+	return func(userID string) map[string]interface{} {
+		claims := make(map[string]interface{})
+		if payload != nil {
+			for name, value := range payload(userID) {
+				claims[name] = value
+			}
+		}
+		username := userID
+		if resolve != nil {
+			username = resolve(userID)
+		}
+		if session, err := StartSession(store, username, ttl); err == nil {
+			claims["sid"] = session.ID
+		}
+		return claims
+	}
+}
+
+// RequireSession rejects tokens whose session doesn't exist within store,
+// such as those which were revoked or issued before sessions were tracked.
+// It must run after the jwt middleware
+func RequireSession(store SessionStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims := jwt.ExtractClaims(c)
+		if err := CheckSession(store, claims["sid"], claims["id"]); err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+				"code":    http.StatusUnauthorized,
+				"message": "session is no longer valid, please sign in again",
+			})
+			return
+		}
+		c.Next()
+	}
+}
+
+// CheckSession ensures the session identified by the sid claim
+// exists within store, and belongs to the user of the id claim
+func CheckSession(store SessionStore, sid, id interface{}) error {
+	sessionID, _ := sid.(string)
+	user, _ := id.(string)
+	if sessionID == "" {
+		return errors.New("token is missing the sid claim")
+	}
+	session, err := store.Get(sessionID)
+	if err != nil {
+		return err
+	}
+	if session.User != user {
+		return errors.New("session belongs to another user")
+	}
+	return nil
+}
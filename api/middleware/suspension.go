@@ -0,0 +1,46 @@
+package middleware
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// AccountSuspension records that an administrator suspended an account. It is
+// kept apart from the user model's AccountEnabled flag, so that restoring a
+// suspended account can't enable an account disabled for any other reason
+type AccountSuspension struct {
+	UserName    string `gorm:"primary_key"`
+	Reason      string
+	SuspendedBy string
+	SuspendedAt time.Time
+}
+
+// SuspendAccount records that admin suspended username for reason,
+// replacing any earlier suspension of the account
+func SuspendAccount(db *gorm.DB, username, admin, reason string) error {
+	return db.Save(&AccountSuspension{
+		UserName:    username,
+		Reason:      reason,
+		SuspendedBy: admin,
+		SuspendedAt: time.Now(),
+	}).Error
+}
+
+// RestoreAccount removes the suspension of username, if it is suspended
+func RestoreAccount(db *gorm.DB, username string) error {
+	return db.Where("user_name = ?", username).Delete(&AccountSuspension{}).Error
+}
+
+// FindAccountSuspension returns the suspension of username,
+// which is nil if the account isn't suspended
+func FindAccountSuspension(db *gorm.DB, username string) (*AccountSuspension, error) {
+	var suspension AccountSuspension
+	if err := db.Where("user_name = ?", username).First(&suspension).Error; err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &suspension, nil
+}
@@ -0,0 +1,71 @@
+package middleware
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	jwt "github.com/appleboy/gin-jwt"
+	"github.com/gin-gonic/gin"
+)
+
+// TTLBounds are the shortest and longest token lifetimes a user may request
+type TTLBounds struct {
+	Min time.Duration
+	Max time.Duration
+}
+
+// TTLLogin returns a handler which serves each login request with the handler
+// built from a copy of mw whose tokens last for the lifetime selected by the
+// optional ttl field of the request, given in seconds. The lifetime must be
+// within the bounds returned for the user signing in, or is adjusted to the
+// nearest bound when clamp is set. Users without bounds can't select a
+// lifetime, while logins which don't select one last for mw.Timeout
+func TTLLogin(
+	mw *jwt.GinJWTMiddleware,
+	bounds func(userID string) (TTLBounds, bool),
+	clamp bool,
+	login func(*jwt.GinJWTMiddleware) gin.HandlerFunc,
+) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var request struct {
+			Username string `json:"username"`
+			TTL      int64  `json:"ttl"`
+		}
+		// leave reporting malformed requests to the login handler
+		if err := peekLogin(c, &request); err != nil || request.TTL == 0 {
+			login(mw)(c)
+			return
+		}
+		ttl, err := boundTTL(time.Duration(request.TTL)*time.Second, request.Username, bounds, clamp)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"code":    http.StatusBadRequest,
+				"message": err.Error(),
+			})
+			return
+		}
+		timed := *mw
+		timed.Timeout = ttl
+		login(&timed)(c)
+	}
+}
+
+// boundTTL returns the lifetime issued when userID requests ttl
+func boundTTL(ttl time.Duration, userID string, bounds func(string) (TTLBounds, bool), clamp bool) (time.Duration, error) {
+	b, ok := bounds(userID)
+	if !ok {
+		return 0, errors.New("token lifetimes can't be selected")
+	}
+	switch {
+	case ttl >= b.Min && ttl <= b.Max:
+		return ttl, nil
+	case clamp && ttl < b.Min:
+		return b.Min, nil
+	case clamp:
+		return b.Max, nil
+	}
+	return 0, fmt.Errorf("invalid ttl, must be between %v and %v seconds",
+		int64(b.Min/time.Second), int64(b.Max/time.Second))
+}
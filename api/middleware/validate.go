@@ -0,0 +1,102 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/mail"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// FieldRule declares the constraints of a request field, which are
+// enforced before the request reaches its handler
+type FieldRule struct {
+	Name string
+	// Required rejects missing or empty values
+	Required bool
+	// MaxLength rejects values longer than it, 0 disables the check
+	MaxLength int
+	// Email rejects values which aren't a bare email address,
+	// ignoring surrounding whitespace
+	Email bool
+}
+
+// check returns why value doesn't satisfy the rule, or an empty string if it does
+func (fr FieldRule) check(value string) string {
+	switch {
+	case strings.TrimSpace(value) == "":
+		if fr.Required {
+			return "must not be empty"
+		}
+	case fr.MaxLength > 0 && len(value) > fr.MaxLength:
+		return fmt.Sprintf("must not be longer than %v characters", fr.MaxLength)
+	case fr.Email && !isEmailAddress(strings.TrimSpace(value)):
+		return "must be a valid email address"
+	}
+	return ""
+}
+
+// ValidateForm rejects requests whose post form fields don't satisfy rules
+func ValidateForm(rules ...FieldRule) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		validateFields(c, rules, c.PostForm)
+	}
+}
+
+// ValidateJSON rejects requests whose json body fields don't satisfy rules.
+// The body is left intact for the handler, which reports malformed bodies
+func ValidateJSON(rules ...FieldRule) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		fields := make(map[string]interface{})
+		if c.Request.Body != nil {
+			body, err := ioutil.ReadAll(c.Request.Body)
+			if err != nil {
+				c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+					"code":     http.StatusBadRequest,
+					"response": "failed to read request body",
+				})
+				return
+			}
+			c.Request.Body = ioutil.NopCloser(bytes.NewReader(body))
+			if err := json.Unmarshal(body, &fields); err != nil {
+				c.Next()
+				return
+			}
+		}
+		validateFields(c, rules, func(name string) string {
+			value, _ := fields[name].(string)
+			return value
+		})
+	}
+}
+
+// validateFields checks each rule against the value returned by field,
+// aborting with the reason every invalid field was rejected
+func validateFields(c *gin.Context, rules []FieldRule, field func(name string) string) {
+	invalid := make(map[string]string)
+	for _, rule := range rules {
+		if reason := rule.check(field(rule.Name)); reason != "" {
+			invalid[rule.Name] = reason
+		}
+	}
+	if len(invalid) > 0 {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"code":     http.StatusBadRequest,
+			"response": "invalid request fields",
+			"fields":   invalid,
+		})
+		return
+	}
+	c.Next()
+}
+
+// isEmailAddress reports whether value is a bare email address,
+// rather than one with a display name or angle brackets
+func isEmailAddress(value string) bool {
+	addr, err := mail.ParseAddress(value)
+	return err == nil && addr.Address == value
+}
@@ -0,0 +1,95 @@
+package v2
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// alertWindow is the period over which suspicious events are counted
+const alertWindow = time.Hour
+
+// alertCounter counts occurrences of a suspicious event within fixed windows
+type alertCounter struct {
+	mux       sync.Mutex
+	threshold int64
+	start     time.Time
+	count     int64
+}
+
+// record counts an event occurring at now, returning true only for the event
+// which crosses the threshold, so that at most one alert is raised per window
+func (ac *alertCounter) record(now time.Time) bool {
+	if ac == nil {
+		return false
+	}
+	ac.mux.Lock()
+	defer ac.mux.Unlock()
+	if now.Sub(ac.start) >= alertWindow {
+		ac.start = now
+		ac.count = 0
+	}
+	ac.count++
+	return ac.count == ac.threshold
+}
+
+// adminAlerts notifies administrators when suspicious events,
+// such as failed logins, spike past their configured thresholds
+type adminAlerts struct {
+	failedLogins  *alertCounter
+	registrations *alertCounter
+	notify        func(event string, count int64)
+}
+
+// newAdminAlerts returns alerts raised through notify, a threshold
+// of 0 disables alerts for that event
+func newAdminAlerts(failedLogins, registrations int64, notify func(event string, count int64)) *adminAlerts {
+	aa := &adminAlerts{notify: notify}
+	if failedLogins > 0 {
+		aa.failedLogins = &alertCounter{threshold: failedLogins}
+	}
+	if registrations > 0 {
+		aa.registrations = &alertCounter{threshold: registrations}
+	}
+	return aa
+}
+
+// trackLogins wraps the login handler, counting failed logins
+func (aa *adminAlerts) trackLogins(handler gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		handler(c)
+		if c.Writer.Status() == http.StatusUnauthorized {
+			aa.record(aa.failedLogins, "failed logins")
+		}
+	}
+}
+
+func (aa *adminAlerts) accountRegistered() {
+	if aa != nil {
+		aa.record(aa.registrations, "account registrations")
+	}
+}
+
+func (aa *adminAlerts) record(ac *alertCounter, event string) {
+	if ac.record(time.Now()) {
+		aa.notify(event, ac.threshold)
+	}
+}
+
+// notifyAdmin emails the configured administrator address about a spike in
+// suspicious events. It is called asynchronously so that publish retries
+// don't delay the request which crossed the threshold
+func (api *API) notifyAdmin(event string, count int64) {
+	api.l.Warnw("suspicious activity detected", "event", event, "count", count)
+	if api.opts.AdminAlertEmail == "" {
+		return
+	}
+	es := adminAlertEmail(event, count, alertWindow)
+	es.UserNames = []string{"administrator"}
+	es.Emails = []string{api.opts.AdminAlertEmail}
+	if err := api.publishEmail(es, emailAdminAlert); err != nil {
+		api.l.Errorw("failed to send admin alert", "event", event, "error", err)
+	}
+}
@@ -0,0 +1,64 @@
+package v2
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func Test_adminAlerts_FailedLogins(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	var alerts []string
+	aa := newAdminAlerts(3, 0, func(event string, count int64) {
+		alerts = append(alerts, event)
+	})
+	r := gin.New()
+	r.POST("/login", aa.trackLogins(func(c *gin.Context) {
+		if c.Query("password") != "admin" {
+			c.String(http.StatusUnauthorized, "bad login")
+			return
+		}
+		c.String(http.StatusOK, "token")
+	}))
+	login := func(password string) {
+		req := httptest.NewRequest("POST", "/login?password="+password, nil)
+		r.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	// successful logins are not counted
+	for i := 0; i < 5; i++ {
+		login("admin")
+	}
+	if len(alerts) != 0 {
+		t.Fatal("successful logins should not raise alerts")
+	}
+	// crossing the threshold raises a single alert for the window
+	for i := 0; i < 10; i++ {
+		login("wrong")
+	}
+	if len(alerts) != 1 || alerts[0] != "failed logins" {
+		t.Fatalf("expected a single failed login alert, got %v", alerts)
+	}
+	// disabled events never raise alerts
+	for i := 0; i < 10; i++ {
+		aa.accountRegistered()
+	}
+	if len(alerts) != 1 {
+		t.Fatal("registration alerts should be disabled")
+	}
+}
+
+func Test_alertCounter_Window(t *testing.T) {
+	ac := &alertCounter{threshold: 2}
+	now := time.Now()
+	if ac.record(now) || !ac.record(now) || ac.record(now) {
+		t.Fatal("only the event crossing the threshold should alert")
+	}
+	// counts are reset once the window has passed
+	later := now.Add(alertWindow)
+	if ac.record(later) || !ac.record(later) {
+		t.Fatal("threshold should be crossed again in a new window")
+	}
+}
@@ -3,10 +3,12 @@ package v2
 import (
 	"context"
 	"crypto/tls"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/RTradeLtd/ChainRider-Go/dash"
@@ -33,6 +35,7 @@ import (
 	"github.com/RTradeLtd/database/v2"
 	"github.com/RTradeLtd/database/v2/models"
 
+	jwt "github.com/appleboy/gin-jwt"
 	"github.com/gin-gonic/gin"
 )
 
@@ -64,8 +67,21 @@ type API struct {
 	service        string
 	version        string
 	swarmEndpoints []*swampi.Swampi
-	captcha        recaptcha.ReCAPTCHA
+	captcha        captchaVerifier
 	captchaEnabled bool
+	opts           Options
+	limits         limits
+	tiers          tierRegistry
+	tokens         *tokenStats
+	funnel         registrationFunnel
+	emails         emailStats
+	alerts         *adminAlerts
+	// sessions tracks issued tokens, it is nil unless Options.StatefulSessions is set
+	sessions middleware.SessionStore
+	// signOuts records when users signed out everywhere
+	signOuts middleware.SignOutStore
+	// jwtKey is the key tokens are signed with, which is replaced by ReloadJWTKey
+	jwtKey *middleware.SigningKey
 }
 
 // Initialize is used ot initialize our API service. debug = true is useful
@@ -112,14 +128,25 @@ func Initialize(
 		return nil, err
 	}
 	api.version = version
+	api.opts = opts
+	if opts.RateLimitStore != nil {
+		// share limiter state with other api instances
+		api.limits = newLimits(opts.RateLimitStore)
+	}
 	if api.getCaptchaKey() != "" {
 		captcha, err := recaptcha.NewReCAPTCHA(api.getCaptchaKey(), recaptcha.V3, time.Second*20)
 		if err != nil {
 			return nil, err
 		}
-		api.captcha = captcha
+		api.captcha = &captcha
 		api.captchaEnabled = true
 	}
+	if opts.RegistrationCaptcha && !api.captchaEnabled && !dev {
+		return nil, errors.New("registration captcha requires a recaptcha key to be configured")
+	}
+	if err := api.tiers.validateVerifiedTier(api.verifiedTier()); err != nil {
+		return nil, err
+	}
 	// init routes
 	if err = api.setupRoutes(opts.DebugLogging); err != nil {
 		return nil, err
@@ -150,6 +177,42 @@ func new(cfg *config.TemporalConfig, router *gin.Engine, l *zap.SugaredLogger, c
 	} else {
 		l.Info("secure database connection established")
 	}
+	// the user model doesn't record when email addresses are verified
+	if err := dbm.DB.AutoMigrate(&verificationRecord{}).Error; err != nil {
+		return nil, fmt.Errorf("failed to migrate email verification records: %s", err.Error())
+	}
+	// or who suspended an account, and why
+	if err := dbm.DB.AutoMigrate(&middleware.AccountSuspension{}).Error; err != nil {
+		return nil, fmt.Errorf("failed to migrate account suspensions: %s", err.Error())
+	}
+	// bulk credit grants are recorded so that they're only applied once
+	if err := dbm.DB.AutoMigrate(&creditGrant{}).Error; err != nil {
+		return nil, fmt.Errorf("failed to migrate credit grants: %s", err.Error())
+	}
+	// as are verification reminders
+	if err := dbm.DB.AutoMigrate(&verificationReminder{}).Error; err != nil {
+		return nil, fmt.Errorf("failed to migrate verification reminders: %s", err.Error())
+	}
+	// accounts provisioned with temporary passwords must change them
+	if err := dbm.DB.AutoMigrate(&passwordChangeRequirement{}).Error; err != nil {
+		return nil, fmt.Errorf("failed to migrate password change requirements: %s", err.Error())
+	}
+	// and can't change them to ones they recently used
+	if err := dbm.DB.AutoMigrate(&passwordHistory{}).Error; err != nil {
+		return nil, fmt.Errorf("failed to migrate password history: %s", err.Error())
+	}
+	// users can opt out of optional emails
+	if err := dbm.DB.AutoMigrate(&emailOptOut{}).Error; err != nil {
+		return nil, fmt.Errorf("failed to migrate email opt outs: %s", err.Error())
+	}
+	// and restrict the ip addresses their account can be used from
+	if err := dbm.DB.AutoMigrate(&accountIPRange{}).Error; err != nil {
+		return nil, fmt.Errorf("failed to migrate account ip ranges: %s", err.Error())
+	}
+	tiers, err := defaultTiers().withLimits(dbm.DB)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read usage tier limits: %s", err.Error())
+	}
 	var networkVersion string
 	if dev {
 		networkVersion = "testnet"
@@ -253,11 +316,16 @@ func new(cfg *config.TemporalConfig, router *gin.Engine, l *zap.SugaredLogger, c
 		zm:             models.NewZoneManager(dbm.DB),
 		rm:             models.NewRecordManager(dbm.DB),
 		nm:             models.NewHostedNetworkManager(dbm.DB),
+		tiers:          tiers,
+		jwtKey:         middleware.NewSigningKey(cfg.JWT.Key),
+		limits:         newLimits(memory.NewStore()),
 	}, nil
 }
 
 // Close releases API resources
 func (api *API) Close() {
+	// finish publishing emails before closing their queue
+	api.emails.wait()
 	// close queue resources
 	if err := api.queues.cluster.Close(); err != nil {
 		api.l.Error(err, "failed to properly close cluster queue connection")
@@ -276,6 +344,17 @@ func (api *API) Close() {
 	}
 }
 
+// ReloadJWTKey replaces the key tokens are signed and validated with, such as
+// when it is rotated. Tokens signed with the previous key are rejected from then
+// on, while verification links remain valid until the key is replaced again
+func (api *API) ReloadJWTKey(key string) error {
+	if err := api.jwtKey.Replace(key); err != nil {
+		return err
+	}
+	api.l.Info("jwt signing key reloaded")
+	return nil
+}
+
 // TLSConfig is used to enable TLS on the API service
 type TLSConfig struct {
 	CertFile string
@@ -285,8 +364,9 @@ type TLSConfig struct {
 // ListenAndServe spins up the API server
 func (api *API) ListenAndServe(ctx context.Context, addr string, tlsConfig *TLSConfig) error {
 	server := &http.Server{
-		Addr:    addr,
-		Handler: api.r,
+		Addr:           addr,
+		Handler:        api.r,
+		MaxHeaderBytes: middleware.DefaultMaxHeaderBytes,
 	}
 	errChan := make(chan error, 1)
 	go func() {
@@ -401,21 +481,36 @@ func (api *API) setupRoutes(debug bool) error {
 	if err != nil {
 		return err
 	}
+	// verification status polling has its own, stricter limit
+	var statusRateLimit = "30-M"
+	if dev {
+		statusRateLimit = "100000-H"
+	}
+	statusRate, err := limiter.NewRateFromFormatted(statusRateLimit)
+	if err != nil {
+		return err
+	}
 
 	// ensure we have valid cors configuration, otherwise default to allow all
 	var allowedOrigins []string
 	if len(api.cfg.API.Connection.CORS.AllowedOrigins) > 0 {
 		allowedOrigins = api.cfg.API.Connection.CORS.AllowedOrigins
 	}
+	// limiter state is shared by every limiter, so keys are namespaced
+	store := api.rateStore()
 	// set up defaults
 	api.r.Use(
+		// reject excessive headers before doing any other work
+		middleware.HeaderLimits(middleware.DefaultMaxHeaders),
 		// cors middleware
 		middleware.CORSMiddleware(dev, debug, allowedOrigins),
 		// allows for automatic xss removal
 		// greater than what can be configured with HTTP Headers
 		xssMdlwr.RemoveXss(),
 		// rate limiting
-		mgin.NewMiddleware(limiter.New(memory.NewStore(), rate)),
+		mgin.NewMiddleware(limiter.New(store, rate), mgin.WithKeyGetter(func(c *gin.Context) string {
+			return "global:" + c.ClientIP()
+		})),
 		// security middleware
 		middleware.NewSecWare(dev),
 		// request id middleware
@@ -424,8 +519,111 @@ func (api *API) setupRoutes(debug bool) error {
 		stats.RequestStats())
 
 	// set up middleware
-	ginjwt := middleware.JwtConfigGenerate(api.cfg.JWT.Key, api.cfg.JWT.Realm, api.dbm.DB, api.l)
-	authware := []gin.HandlerFunc{ginjwt.MiddlewareFunc()}
+	ginjwt, err := middleware.JwtConfigGenerate(
+		api.jwtKey.Current(), api.cfg.JWT.Realm, api.dbm.DB, api.l, api.opts.PasswordHashCost,
+	)
+	if err != nil {
+		return err
+	}
+	// tokens which expired within the grace period must still be within the
+	// refresh window, which is measured from when the session was started
+	ginjwt.MaxRefresh = ginjwt.Timeout + api.opts.RefreshGracePeriod
+	if api.opts.FoldUsernames {
+		// sign in using the registered case of the username
+		authenticate := ginjwt.Authenticator
+		ginjwt.Authenticator = func(userID, password string, c *gin.Context) (string, bool) {
+			if folded := api.foldUserName(userID); folded != "" {
+				userID = folded
+			}
+			return authenticate(userID, password, c)
+		}
+	}
+	if api.opts.NormalizeEmails {
+		// users may sign in with their email address, which is stored normalized
+		authenticate := ginjwt.Authenticator
+		ginjwt.Authenticator = func(userID, password string, c *gin.Context) (string, bool) {
+			if strings.ContainsRune(userID, '@') {
+				userID = api.normalizeEmail(userID)
+			}
+			return authenticate(userID, password, c)
+		}
+	}
+	// tell users with valid credentials why they can't sign in
+	unauthorized := ginjwt.Unauthorized
+	ginjwt.Unauthorized = func(c *gin.Context, code int, message string) {
+		if api.opts.ReportUnverifiedLogins && middleware.EmailUnverified(c) {
+			code, message = http.StatusPreconditionFailed, "email not verified"
+		}
+		unauthorized(c, code, message)
+	}
+	if api.opts.ClaimsAugmenter != nil {
+		ginjwt.PayloadFunc = middleware.ClaimsPayload(api.dbm.DB, api.opts.ClaimsAugmenter)
+	}
+	// the key is read by each request, as it can be reloaded while serving them
+	authware := []gin.HandlerFunc{api.jwtKey.Bind(ginjwt, (*jwt.GinJWTMiddleware).MiddlewareFunc), api.validateIssuedAt}
+	if api.opts.MinimalTokens {
+		store := api.opts.ClaimsStore
+		if store == nil {
+			store = middleware.NewMemoryClaimsStore()
+		}
+		// refreshed tokens keep their jti, so claims must outlive the refresh window
+		ginjwt.PayloadFunc = middleware.MinimalClaimsPayload(ginjwt.PayloadFunc, store, ginjwt.Timeout+ginjwt.MaxRefresh)
+		authware = append(authware, middleware.ResolveClaims(store))
+	}
+	api.signOuts = api.opts.SignOutStore
+	if api.signOuts == nil {
+		api.signOuts = middleware.NewMemorySignOutStore()
+	}
+	authware = append(authware, middleware.RejectSignedOut(api.signOuts))
+	if api.opts.StatefulSessions {
+		api.sessions = api.opts.SessionStore
+		if api.sessions == nil {
+			api.sessions = middleware.NewMemorySessionStore()
+		}
+		// the sid claim is added outside of minimal tokens, so that it can be
+		// checked on refresh, and sessions must outlive the refresh window
+		ginjwt.PayloadFunc = middleware.StatefulPayload(
+			ginjwt.PayloadFunc, api.sessions, ginjwt.Timeout+ginjwt.MaxRefresh, api.loginUserName,
+		)
+		authware = append(authware, middleware.RequireSession(api.sessions))
+	}
+	loginHandler := func(mw *jwt.GinJWTMiddleware) gin.HandlerFunc {
+		return mw.LoginHandler
+	}
+	if len(api.opts.TokenAudiences) > 0 {
+		loginHandler = func(mw *jwt.GinJWTMiddleware) gin.HandlerFunc {
+			return middleware.AudienceLogin(mw, api.opts.TokenAudiences)
+		}
+	}
+	if len(api.opts.TokenTTLs) > 0 {
+		audienceLogin := loginHandler
+		loginHandler = func(mw *jwt.GinJWTMiddleware) gin.HandlerFunc {
+			return middleware.TTLLogin(mw, api.tokenTTLBounds, api.opts.ClampTokenTTLs, audienceLogin)
+		}
+	}
+	login := api.jwtKey.Bind(ginjwt, loginHandler)
+	// clients may request their profile when signing in, saving a round trip
+	login = middleware.ProfileLogin(login, api.loginProfile)
+	if len(api.opts.RouteAudiences) > 0 {
+		authware = append(authware, api.routeAudiences())
+	}
+	// accounts which must change their password can't do anything else until they do
+	authware = append(authware, api.enforcePasswordChange)
+	// accounts can restrict the ip addresses they're used from
+	authware = append(authware, api.enforceIPRanges)
+	if api.opts.CookieSessions {
+		// browser clients are authenticated through cookies instead of bearer tokens
+		authware = append([]gin.HandlerFunc{middleware.SessionAuth()}, authware...)
+		login = middleware.SessionLogin(login, !dev)
+	}
+	if api.opts.LimitConcurrentRequests {
+		authware = append(authware, newConcurrencyLimiter(api.concurrentRequestLimit).middleware())
+	}
+	api.tokens = newTokenStats(ginjwt.Timeout)
+	api.alerts = newAdminAlerts(
+		api.opts.FailedLoginAlertThreshold, api.opts.RegistrationAlertThreshold, api.notifyAdmin,
+	)
+	api.limits.registrationIP = api.registrationIPLimiter()
 
 	// V2 API
 	v2 := api.r.Group("/v2")
@@ -436,6 +634,14 @@ func (api *API) setupRoutes(debug bool) error {
 		systemChecks.GET("/check", api.SystemsCheck)
 	}
 
+	// email template previews for operators, never exposed in production
+	if dev {
+		v2.GET("/dev/email/preview/:template", api.previewEmail)
+	}
+
+	// usage tier policies, used by clients to display pricing information
+	v2.GET("/tiers", api.getTiers)
+
 	// authless account recovery routes
 	forgot := v2.Group("/forgot")
 	{
@@ -446,13 +652,47 @@ func (api *API) setupRoutes(debug bool) error {
 	// authentication
 	auth := v2.Group("/auth")
 	{
-		auth.POST("/register", api.registerUserAccount)
-		auth.POST("/login", ginjwt.LoginHandler)
-		auth.GET("/refresh", ginjwt.RefreshHandler)
+		auth.POST("/register", api.validateRegistrationForm(), api.registerUserAccount)
+		auth.POST("/login", api.validateLoginForm(), api.alerts.trackLogins(api.tokens.track(login)))
+		auth.GET("/refresh", api.rejectImpersonationRefresh, api.refreshGrace, api.tokens.track(api.jwtKey.Bind(ginjwt, func(mw *jwt.GinJWTMiddleware) gin.HandlerFunc {
+			return mw.RefreshHandler
+		})))
+		if api.opts.MagicLinkURL != "" {
+			magicLogin := api.jwtKey.Bind(ginjwt, api.loginWithMagicLink)
+			if api.opts.CookieSessions {
+				magicLogin = middleware.SessionLogin(magicLogin, !dev)
+			}
+			auth.POST("/magic/request", api.requestMagicLink)
+			auth.POST("/magic/login", api.tokens.track(magicLogin))
+		}
+		if api.opts.ServiceKey != "" {
+			auth.POST("/introspect", append(api.policy(policyService, authware), api.introspectToken)...)
+			auth.POST("/introspect/batch", append(api.policy(policyService, authware), api.introspectTokens)...)
+		}
+		auth.POST("/logout/everywhere", append(api.policy(policySensitive, authware),
+			api.jwtKey.Bind(ginjwt, api.signOutEverywhere))...)
+		if api.sessions != nil {
+			auth.POST("/logout", append(api.policy(policyAuthenticated, authware), api.logout)...)
+			auth.GET("/sessions", append(api.policy(policyAuthenticated, authware), api.listSessions)...)
+			auth.DELETE("/sessions/:id", append(api.policy(policyAuthenticated, authware), api.revokeSession)...)
+		}
+	}
+
+	// administrative routes
+	admin := v2.Group("/admin", api.policy(policyAdmin, authware)...)
+	{
+		admin.POST("/impersonate", api.rejectImpersonation, api.impersonateUser)
+		admin.POST("/usage/reset", api.resetMonthlyUsage)
+		admin.POST("/broadcast", api.broadcastEmail)
+		admin.POST("/credits/grant", api.grantCredits)
+		admin.POST("/account/provision", api.provisionAccount)
+		admin.POST("/account/suspend", api.suspendAccount)
+		admin.POST("/account/unsuspend", api.unsuspendAccount)
+		admin.POST("/accounts/unverified/purge", api.purgeUnverifiedAccounts)
 	}
 
 	// statistics
-	statistics := v2.Group("/statistics").Use(authware...)
+	statistics := v2.Group("/statistics", api.policy(policyAdmin, authware)...)
 	{
 		statistics.GET("/stats", api.getStats)
 	}
@@ -490,25 +730,40 @@ func (api *API) setupRoutes(debug bool) error {
 	}
 
 	// accounts
-	account := v2.Group("/account")
+	var compress []gin.HandlerFunc
+	if api.opts.CompressResponses {
+		compress = append(compress, middleware.Compress(middleware.DefaultCompressMinSize))
+	}
+	account := v2.Group("/account", compress...)
 	{
 		token := account.Group("/token", authware...)
 		{
 			token.GET("/username", api.getUserFromToken)
 		}
-		password := account.Group("/password", authware...)
+		password := account.Group("/password", api.policy(policySensitive, authware)...)
 		{
 			password.POST("/change", api.changeAccountPassword)
 		}
-		key := account.Group("/key", authware...)
+		key := account.Group("/key", api.policy(policySensitive, authware)...)
 		{
 			key.GET("/export/:name", api.exportKey)
 			ipfs := key.Group("/ipfs")
 			{
 				ipfs.GET("/get", api.getIPFSKeyNamesForAuthUser)
+				ipfs.GET("/list", api.listIPFSKeys)
 				ipfs.POST("/new", api.createIPFSKey)
 			}
 		}
+		ip := account.Group("/ip", api.policy(policySensitive, authware)...)
+		{
+			ip.GET("", api.getIPRanges)
+			ip.POST("/update", api.updateIPRanges)
+		}
+		notifications := account.Group("/notifications", authware...)
+		{
+			notifications.GET("", api.getNotificationPreferences)
+			notifications.POST("/update", api.updateNotificationPreferences)
+		}
 		credits := account.Group("/credits", authware...)
 		{
 			credits.GET("/available", api.getCredits)
@@ -519,9 +774,17 @@ func (api *API) setupRoutes(debug bool) error {
 			token := email.Group("/verify")
 			{
 				token.GET("/:user/:token", api.verifyEmailAddress)
+				token.POST("/code", api.verifyEmailCode)
+			}
+			status := email.Group("/status", mgin.NewMiddleware(limiter.New(store, statusRate), mgin.WithKeyGetter(func(c *gin.Context) string {
+				return "email-status:" + c.ClientIP()
+			})))
+			{
+				status.GET("/:user", api.getEmailVerificationStatus)
 			}
+			email.POST("/resend", api.resendAccountEmail)
 			// authenticatoin email routes
-			auth := email.Use(authware...)
+			auth := email.Use(api.policy(policySensitive, authware)...)
 			{
 				auth.POST("/forgot", api.forgotEmail)
 			}
@@ -663,7 +926,8 @@ func (api *API) setupRoutes(debug bool) error {
 			get.GET("/billing/report", api.getOrgBillingReport)
 		}
 		org.POST("/new", api.newOrganization)
-		org.POST("/register/user", api.registerOrgUser)
+		// org users are subject to the same limits and reserved names as other users
+		org.POST("/register/user", api.validateRegistrationForm(), api.registerOrgUser)
 		org.POST("/user/uploads", api.getOrgUserUploads)
 	}
 
@@ -3,6 +3,7 @@ package v2
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -15,14 +16,17 @@ import (
 
 	"go.uber.org/zap/zaptest"
 
+	"github.com/RTradeLtd/Temporal/api/middleware"
 	"github.com/RTradeLtd/Temporal/mocks"
 	"github.com/RTradeLtd/Temporal/queue"
 	"github.com/RTradeLtd/Temporal/rtfscluster"
+	"github.com/RTradeLtd/Temporal/utils"
 	"github.com/RTradeLtd/config/v2"
 	"github.com/RTradeLtd/database/v2"
 	"github.com/RTradeLtd/database/v2/models"
 	"github.com/RTradeLtd/rtfs/v2"
 	"github.com/c2h5oh/datasize"
+	recaptcha "github.com/ezzarghili/recaptcha-go"
 	"github.com/gin-gonic/gin"
 	"github.com/jinzhu/gorm"
 	"github.com/streadway/amqp"
@@ -187,6 +191,90 @@ func setupAPI(t *testing.T, fakeLens *mocks.FakeLensV2Client, fakeOrch *mocks.Fa
 	return api, nil
 }
 
+// newTestAPI loads the test configuration and database, and returns an api
+// setup with fake mock clients, failing the test on any error
+func newTestAPI(t *testing.T) *API {
+	cfg, err := config.LoadConfig("../../testenv/config.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := loadDatabase(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	api, err := setupAPI(t,
+		&mocks.FakeLensV2Client{},
+		&mocks.FakeServiceClient{},
+		&mocks.FakeSignerClient{},
+		&mocks.FakeWalletServiceClient{},
+		cfg, db,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return api
+}
+
+// testUserPassword is the password of accounts created by registerTestUser
+const testUserPassword = "password123"
+
+// registerTestUser creates an unverified account named name, as registering
+// does, with testUserPassword as its password and an address at example.org
+func registerTestUser(t *testing.T, api *API, name string) {
+	if _, err := api.um.NewUserAccount(name, testUserPassword, name+"@example.org"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// verifyTestUser verifies the email address of the account named name
+func verifyTestUser(t *testing.T, api *API, name string) {
+	user, err := api.um.GenerateEmailVerificationToken(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := api.activateAccount(name, user.EmailVerificationToken); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// loginTestUser signs in as name, returning the token issued
+func loginTestUser(t *testing.T, api *API, name, password string) string {
+	testRecorder := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/v2/auth/login",
+		strings.NewReader(fmt.Sprintf(`{"username": %q, "password": %q}`, name, password)))
+	api.r.ServeHTTP(testRecorder, req)
+	if testRecorder.Code != http.StatusOK {
+		t.Fatalf("bad login status code, got %v, want %v", testRecorder.Code, http.StatusOK)
+	}
+	var loginResp loginResponse
+	if err := json.Unmarshal(testRecorder.Body.Bytes(), &loginResp); err != nil {
+		t.Fatal(err)
+	}
+	return loginResp.Token
+}
+
+func Test_API_ReloadJWTKey(t *testing.T) {
+	api := newTestAPI(t)
+	oldKey := api.jwtKey.Current()
+	if err := sendRequest(api, "GET", "/v2/account/token/username", 200, nil, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := api.ReloadJWTKey("tooshort"); err == nil {
+		t.Fatal("expected short key to be rejected")
+	}
+	if err := api.ReloadJWTKey("anothersecretkeythatisjustaslongandsecure"); err != nil {
+		t.Fatal(err)
+	}
+	// tokens signed with the previous key are rejected once it is reloaded
+	if err := sendRequest(api, "GET", "/v2/account/token/username", 401, nil, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	// while verification links signed with it remain valid
+	if key, err := api.verificationKey(verificationKeyID(oldKey)); err != nil || key != oldKey {
+		t.Fatalf("verificationKey() = %v, %v, want the previous key", key, err)
+	}
+}
+
 // this does a quick initial test of the API, and setups a second user account to use for testing
 func Test_API_Setup(t *testing.T) {
 	// load configuration
@@ -766,3 +854,538 @@ func loadDatabase(cfg *config.TemporalConfig) (*gorm.DB, error) {
 	}
 	return dbm.DB, nil
 }
+
+type fakeCaptcha struct {
+	validResponse string
+}
+
+func (fc fakeCaptcha) VerifyWithOptions(challengeResponse string, _ recaptcha.VerifyOption) error {
+	if challengeResponse != fc.validResponse {
+		return errors.New("invalid captcha response")
+	}
+	return nil
+}
+
+func Test_API_Register_Captcha(t *testing.T) {
+	api := newTestAPI(t)
+	api.captcha = fakeCaptcha{validResponse: "suchvalidresponse"}
+	api.captchaEnabled = true
+	api.opts.RegistrationCaptcha = true
+	// captcha enforcement is disabled in dev mode
+	dev = false
+	defer func() { dev = true }()
+	randUtils := utils.GenerateRandomUtils()
+	tests := []struct {
+		name            string
+		captchaResponse string
+		wantCode        int
+	}{
+		{"Missing-Captcha", "", 403},
+		{"Invalid-Captcha", "notavalidresponse", 403},
+		{"Valid-Captcha", "suchvalidresponse", 200},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			randUser := randUtils.GenerateString(32, utils.LetterBytes)
+			urlValues := url.Values{}
+			urlValues.Add("username", randUser)
+			urlValues.Add("password", "password123")
+			urlValues.Add("email_address", randUser+"@example.org")
+			if tt.captchaResponse != "" {
+				urlValues.Add("g-recaptcha-response", tt.captchaResponse)
+			}
+			if err := sendRequest(
+				api, "POST", "/v2/auth/register", tt.wantCode, nil, urlValues, nil,
+			); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func Test_API_Register_SkipVerification(t *testing.T) {
+	api := newTestAPI(t)
+	randUtils := utils.GenerateRandomUtils()
+	tests := []struct {
+		name         string
+		skip         bool
+		wantVerified bool
+		wantTier     models.DataUsageTier
+		// expected increase of each registration funnel stage
+		wantFunnel [3]int64
+	}{
+		{"Verification-Required", false, false, models.Unverified, [3]int64{1, 1, 0}},
+		{"Verification-Skipped", true, true, models.Free, [3]int64{1, 0, 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := api.funnel.report()
+			api.opts.SkipEmailVerification = tt.skip
+			randUser := randUtils.GenerateString(32, utils.LetterBytes)
+			urlValues := url.Values{}
+			urlValues.Add("username", randUser)
+			urlValues.Add("password", "password123")
+			urlValues.Add("email_address", randUser+"@example.org")
+			if err := sendRequest(
+				api, "POST", "/v2/auth/register", 200, nil, urlValues, nil,
+			); err != nil {
+				t.Fatal(err)
+			}
+			user, err := api.um.FindByUserName(randUser)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if user.EmailEnabled != tt.wantVerified {
+				t.Fatalf("email enabled = %v, want %v", user.EmailEnabled, tt.wantVerified)
+			}
+			usage, err := api.usage.FindByUserName(randUser)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if usage.Tier != tt.wantTier {
+				t.Fatalf("tier = %v, want %v", usage.Tier, tt.wantTier)
+			}
+			after := api.funnel.report()
+			for i, stage := range []string{"registered", "verification_emails", "verified"} {
+				if got := after[stage].(int64) - before[stage].(int64); got != tt.wantFunnel[i] {
+					t.Fatalf("%s increased by %v, want %v", stage, got, tt.wantFunnel[i])
+				}
+			}
+		})
+	}
+}
+
+func Test_API_Register_DomainLimit(t *testing.T) {
+	api := newTestAPI(t)
+	randUtils := utils.GenerateRandomUtils()
+	api.opts.MaxAccountsPerDomain = 1
+	api.opts.DomainAllowlist = []string{"temporal.example.org"}
+	defer func() {
+		api.opts.MaxAccountsPerDomain = 0
+		api.opts.DomainAllowlist = nil
+	}()
+	tests := []struct {
+		name           string
+		domain         string
+		wantSecondCode int
+	}{
+		{"Limited-Domain", strings.ToLower(randUtils.GenerateString(16, utils.LetterBytes)) + ".example.org", 429},
+		{"Allowlisted-Domain", "temporal.example.org", 200},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, wantCode := range []int{200, tt.wantSecondCode} {
+				randUser := randUtils.GenerateString(32, utils.LetterBytes)
+				urlValues := url.Values{}
+				urlValues.Add("username", randUser)
+				urlValues.Add("password", "password123")
+				urlValues.Add("email_address", randUser+"@"+tt.domain)
+				if err := sendRequest(
+					api, "POST", "/v2/auth/register", wantCode, nil, urlValues, nil,
+				); err != nil {
+					t.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func Test_API_Register_IPLimit(t *testing.T) {
+	api := newTestAPI(t)
+	api.captcha = fakeCaptcha{validResponse: "suchvalidresponse"}
+	api.captchaEnabled = true
+	// captcha enforcement is disabled in dev mode
+	dev = false
+	defer func() {
+		dev = true
+		api.opts.MaxRegistrationsPerIP = 0
+		api.opts.IPRegistrationAllowlist = nil
+		api.opts.CaptchaRepeatRegistrations = false
+		api.limits.registrationIP = nil
+	}()
+	randUtils := utils.GenerateRandomUtils()
+	tests := []struct {
+		name          string
+		allowlist     []string
+		captchaRepeat bool
+		wantCodes     []int
+	}{
+		// requests made by httptest originate from 192.0.2.1
+		{"Limited-IP", nil, false, []int{200, 429}},
+		{"Allowlisted-IP", []string{"10.0.0.1", "192.0.2.0/24"}, false, []int{200, 200}},
+		{"Captcha-After-First", nil, true, []int{200, 403}},
+		// registrations which fail don't count towards the limit
+		{"Failed-Not-Counted", nil, false, []int{400, 200, 429}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api.opts.MaxRegistrationsPerIP = 1
+			api.opts.IPRegistrationAllowlist = tt.allowlist
+			api.opts.CaptchaRepeatRegistrations = tt.captchaRepeat
+			api.limits.registrationIP = api.registrationIPLimiter()
+			for _, wantCode := range tt.wantCodes {
+				randUser := randUtils.GenerateString(32, utils.LetterBytes)
+				if wantCode == http.StatusBadRequest {
+					// testuser is already registered
+					randUser = "testuser"
+				}
+				urlValues := url.Values{}
+				urlValues.Add("username", randUser)
+				urlValues.Add("password", "password123")
+				urlValues.Add("email_address", randUser+"@example.org")
+				if err := sendRequest(
+					api, "POST", "/v2/auth/register", wantCode, nil, urlValues, nil,
+				); err != nil {
+					t.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func Test_API_Register_NormalizeEmails(t *testing.T) {
+	api := newTestAPI(t)
+	api.opts.NormalizeEmails = true
+	api.opts.LowercaseEmailLocalPart = true
+	defer func() {
+		api.opts.NormalizeEmails = false
+		api.opts.LowercaseEmailLocalPart = false
+	}()
+	randUtils := utils.GenerateRandomUtils()
+	local := "Alice" + randUtils.GenerateString(16, utils.LetterBytes)
+	for _, tt := range []struct {
+		email    string
+		wantCode int
+	}{
+		{" " + local + "@Example.COM ", 200},
+		// the same address, so it is a duplicate
+		{strings.ToLower(local) + "@example.com", 400},
+	} {
+		urlValues := url.Values{}
+		urlValues.Add("username", randUtils.GenerateString(32, utils.LetterBytes))
+		urlValues.Add("password", "password123")
+		urlValues.Add("email_address", tt.email)
+		if err := sendRequest(
+			api, "POST", "/v2/auth/register", tt.wantCode, nil, urlValues, nil,
+		); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// lookups find the account regardless of how the address is entered
+	user, err := api.um.FindByEmail(api.normalizeEmail(" " + strings.ToUpper(local) + "@EXAMPLE.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.EmailAddress != strings.ToLower(local)+"@example.com" {
+		t.Fatalf("stored email address %q was not normalized", user.EmailAddress)
+	}
+}
+
+func Test_API_Register_FoldUsernames(t *testing.T) {
+	api := newTestAPI(t)
+	randUtils := utils.GenerateRandomUtils()
+	tests := []struct {
+		name     string
+		fold     bool
+		wantCode int
+	}{
+		{"Folding-Disabled", false, 200},
+		{"Folding-Enabled", true, 400},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api.opts.FoldUsernames = tt.fold
+			randUser := strings.ToLower(randUtils.GenerateString(32, utils.LetterBytes))
+			register := func(username string, wantCode int) {
+				urlValues := url.Values{}
+				urlValues.Add("username", username)
+				urlValues.Add("password", "password123")
+				// email addresses are unique so that only the username can collide
+				urlValues.Add("email_address", randUtils.GenerateString(32, utils.LetterBytes)+"@example.org")
+				if err := sendRequest(
+					api, "POST", "/v2/auth/register", wantCode, nil, urlValues, nil,
+				); err != nil {
+					t.Fatal(err)
+				}
+			}
+			register("Alice"+randUser, 200)
+			register("alice"+randUser, tt.wantCode)
+		})
+	}
+	api.opts.FoldUsernames = false
+}
+
+func Test_API_SuspendAccount(t *testing.T) {
+	api := newTestAPI(t)
+	randUser := utils.GenerateRandomUtils().GenerateString(32, utils.LetterBytes)
+	registerTestUser(t, api, randUser)
+	verifyTestUser(t, api, randUser)
+	login := func(wantCode int) string {
+		testRecorder := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "/v2/auth/login",
+			strings.NewReader(fmt.Sprintf(`{"username": %q, "password": %q}`, randUser, testUserPassword)))
+		api.r.ServeHTTP(testRecorder, req)
+		if testRecorder.Code != wantCode {
+			t.Fatalf("bad login status code, got %v, want %v", testRecorder.Code, wantCode)
+		}
+		var loginResp loginResponse
+		json.Unmarshal(testRecorder.Body.Bytes(), &loginResp)
+		return loginResp.Token
+	}
+	validate := func(token string, wantCode int) {
+		testRecorder := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/v2/account/token/username", nil)
+		req.Header.Add("Authorization", "Bearer "+token)
+		api.r.ServeHTTP(testRecorder, req)
+		if testRecorder.Code != wantCode {
+			t.Fatalf("bad status code using token, got %v, want %v", testRecorder.Code, wantCode)
+		}
+		if wantCode == http.StatusForbidden && !strings.Contains(testRecorder.Body.String(), "account suspended") {
+			t.Fatalf("expected suspension to be reported, got %s", testRecorder.Body.String())
+		}
+	}
+	token := login(200)
+	validate(token, 200)
+	// suspended accounts can neither sign in nor use existing tokens
+	urlValues := url.Values{}
+	urlValues.Add("username", randUser)
+	urlValues.Add("reason", "testing suspensions")
+	if err := sendRequest(api, "POST", "/v2/admin/account/suspend", 200, nil, urlValues, nil); err != nil {
+		t.Fatal(err)
+	}
+	login(http.StatusForbidden)
+	validate(token, http.StatusForbidden)
+	// the suspension is recorded apart from the account itself
+	suspension, err := middleware.FindAccountSuspension(api.dbm.DB, randUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if suspension == nil {
+		t.Fatal("expected suspension to be recorded")
+	}
+	if suspension.Reason != "testing suspensions" || suspension.SuspendedBy != testUser || suspension.SuspendedAt.IsZero() {
+		t.Fatalf("bad suspension record %+v", suspension)
+	}
+	user, err := api.um.FindByUserName(randUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !user.AccountEnabled {
+		t.Fatal("suspension should not disable the account")
+	}
+	// restored accounts regain access
+	if err := sendRequest(api, "POST", "/v2/admin/account/unsuspend", 200, nil, urlValues, nil); err != nil {
+		t.Fatal(err)
+	}
+	validate(login(200), 200)
+	validate(token, 200)
+	if suspension, err := middleware.FindAccountSuspension(api.dbm.DB, randUser); err != nil || suspension != nil {
+		t.Fatalf("expected suspension to be removed, got %+v, %v", suspension, err)
+	}
+	// restoring an account doesn't enable accounts disabled for other reasons
+	if err := api.dbm.DB.Model(user).Update("account_enabled", false).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := sendRequest(api, "POST", "/v2/admin/account/suspend", 200, nil, urlValues, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := sendRequest(api, "POST", "/v2/admin/account/unsuspend", 200, nil, urlValues, nil); err != nil {
+		t.Fatal(err)
+	}
+	if user, err = api.um.FindByUserName(randUser); err != nil {
+		t.Fatal(err)
+	}
+	if user.AccountEnabled {
+		t.Fatal("restoring a suspension should not enable a disabled account")
+	}
+	login(http.StatusUnauthorized)
+	if err := api.dbm.DB.Model(user).Update("account_enabled", true).Error; err != nil {
+		t.Fatal(err)
+	}
+	// only administrators can suspend accounts
+	testRecorder := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/v2/admin/account/suspend", nil)
+	req.Header.Add("Authorization", "Bearer "+token)
+	req.PostForm = url.Values{"username": {testUser}}
+	api.r.ServeHTTP(testRecorder, req)
+	if testRecorder.Code != http.StatusForbidden {
+		t.Fatalf("non-admin suspension returned status %v, want %v", testRecorder.Code, http.StatusForbidden)
+	}
+}
+
+func Test_API_ProvisionAccount(t *testing.T) {
+	api := newTestAPI(t)
+	randUser := utils.GenerateRandomUtils().GenerateString(32, utils.LetterBytes)
+	urlValues := url.Values{}
+	urlValues.Add("username", randUser)
+	urlValues.Add("password", "temporary123")
+	urlValues.Add("email_address", randUser+"@example.org")
+	if err := sendRequest(api, "POST", "/v2/admin/account/provision", 200, nil, urlValues, nil); err != nil {
+		t.Fatal(err)
+	}
+	// provisioned accounts can sign in with their temporary password
+	token := loginTestUser(t, api, randUser, "temporary123")
+	request := func(method, path string, forms url.Values, wantCode int) {
+		testRecorder := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Add("Authorization", "Bearer "+token)
+		req.PostForm = forms
+		api.r.ServeHTTP(testRecorder, req)
+		if testRecorder.Code != wantCode {
+			t.Fatalf("bad status code from %s, got %v, want %v", path, testRecorder.Code, wantCode)
+		}
+	}
+	// but can't do anything else until they change it
+	request("GET", "/v2/account/token/username", nil, http.StatusForbidden)
+	request("POST", "/v2/account/password/change", url.Values{
+		"old_password": {"temporary123"},
+		"new_password": {"password123"},
+	}, http.StatusOK)
+	request("GET", "/v2/account/token/username", nil, http.StatusOK)
+	if required, err := api.mustChangePassword(randUser); err != nil {
+		t.Fatal(err)
+	} else if required {
+		t.Fatal("expected password change requirement to be cleared")
+	}
+}
+
+func Test_API_AccountIPRanges(t *testing.T) {
+	api := newTestAPI(t)
+	randUser := utils.GenerateRandomUtils().GenerateString(32, utils.LetterBytes)
+	registerTestUser(t, api, randUser)
+	verifyTestUser(t, api, randUser)
+	token := loginTestUser(t, api, randUser, testUserPassword)
+	request := func(ip, method, path string, forms url.Values, wantCode int) {
+		testRecorder := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, nil)
+		req.RemoteAddr = ip + ":1234"
+		req.Header.Add("Authorization", "Bearer "+token)
+		req.PostForm = forms
+		api.r.ServeHTTP(testRecorder, req)
+		if testRecorder.Code != wantCode {
+			t.Fatalf("bad status code from %s to %s, got %v, want %v", ip, path, testRecorder.Code, wantCode)
+		}
+	}
+	// empty lists allow access from anywhere
+	request("192.0.2.1", "GET", "/v2/account/token/username", nil, http.StatusOK)
+	// invalid ranges, and those locking out the updating ip, are rejected
+	request("10.1.2.3", "POST", "/v2/account/ip/update", url.Values{"allow": {"10.0.0.0/33"}}, http.StatusBadRequest)
+	request("192.0.2.1", "POST", "/v2/account/ip/update", url.Values{"allow": {"10.0.0.0/8"}}, http.StatusBadRequest)
+	request("10.9.9.9", "POST", "/v2/account/ip/update", url.Values{
+		"allow": {"10.0.0.0/8"},
+		"deny":  {"10.1.2.0/24"},
+	}, http.StatusOK)
+	// an allowed ip
+	request("10.9.9.9", "GET", "/v2/account/token/username", nil, http.StatusOK)
+	// a denied ip, and one outside of the allowed ranges
+	request("10.1.2.3", "GET", "/v2/account/token/username", nil, http.StatusForbidden)
+	request("192.0.2.1", "GET", "/v2/account/token/username", nil, http.StatusForbidden)
+	// clearing the lists allows access from anywhere again
+	request("10.9.9.9", "POST", "/v2/account/ip/update", url.Values{}, http.StatusOK)
+	request("192.0.2.1", "GET", "/v2/account/token/username", nil, http.StatusOK)
+}
+
+func Test_API_Login_Profile(t *testing.T) {
+	api := newTestAPI(t)
+	login := func(body string) map[string]interface{} {
+		testRecorder := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "/v2/auth/login", strings.NewReader(body))
+		api.r.ServeHTTP(testRecorder, req)
+		if testRecorder.Code != http.StatusOK {
+			t.Fatalf("bad login status code, got %v, want %v", testRecorder.Code, http.StatusOK)
+		}
+		var resp map[string]interface{}
+		if err := json.Unmarshal(testRecorder.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		if resp["token"] == nil {
+			t.Fatal("expected a token to be issued")
+		}
+		return resp
+	}
+	// the profile is only included when requested
+	if resp := login(`{"username": "testuser", "password": "admin"}`); resp["profile"] != nil {
+		t.Fatal("expected profile to be left out")
+	}
+	resp := login(`{"username": "testuser", "password": "admin", "include_profile": true}`)
+	profile, ok := resp["profile"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("expected profile to be included, got %v", resp)
+	}
+	if profile["username"] != testUser || profile["email_address"] != "test@email.com" {
+		t.Fatalf("bad profile %v", profile)
+	}
+	if profile["usage"] == nil || profile["ipfs_keys"] == nil {
+		t.Fatalf("expected usage and ipfs keys in profile %v", profile)
+	}
+	// large ipfs key lists can be left out
+	resp = login(`{"username": "testuser", "password": "admin", "include_profile": true, "omit_ipfs": true}`)
+	if profile, ok = resp["profile"].(map[string]interface{}); !ok {
+		t.Fatalf("expected profile to be included, got %v", resp)
+	}
+	if profile["ipfs_keys"] != nil {
+		t.Fatal("expected ipfs keys to be left out")
+	}
+}
+
+func Test_API_Login_Unverified(t *testing.T) {
+	api := newTestAPI(t)
+	randUser := utils.GenerateRandomUtils().GenerateString(32, utils.LetterBytes)
+	registerTestUser(t, api, randUser)
+	defer func() { api.opts.ReportUnverifiedLogins = false }()
+	tests := []struct {
+		name     string
+		report   bool
+		password string
+		wantCode int
+	}{
+		{"Relaxed", false, testUserPassword, http.StatusUnauthorized},
+		{"Enforced", true, testUserPassword, http.StatusPreconditionFailed},
+		// the reason is only given once the password is checked
+		{"Enforced-Wrong-Password", true, "notthepassword", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api.opts.ReportUnverifiedLogins = tt.report
+			testRecorder := httptest.NewRecorder()
+			req := httptest.NewRequest("POST", "/v2/auth/login",
+				strings.NewReader(fmt.Sprintf(`{"username": %q, "password": %q}`, randUser, tt.password)))
+			api.r.ServeHTTP(testRecorder, req)
+			if testRecorder.Code != tt.wantCode {
+				t.Fatalf("got status %v, want %v", testRecorder.Code, tt.wantCode)
+			}
+			if strings.Contains(testRecorder.Body.String(), "token") {
+				t.Fatal("a token was issued to an unverified account")
+			}
+		})
+	}
+}
+
+func Test_API_EmailPublishFailures(t *testing.T) {
+	api := newTestAPI(t)
+	api.opts.EmailPublishAttempts = 1
+	defer func() { api.opts.EmailPublishAttempts = 0 }()
+	es := passwordResetEmail("password123")
+	es.UserNames = []string{"testuser"}
+	es.Emails = []string{"testuser@example.org"}
+	if err := api.publishEmail(es, emailPasswordReset); err != nil {
+		t.Fatal(err)
+	}
+	api.emails.wait()
+	// publishing fails once the queue connection is closed, which
+	// is counted rather than returned as it happens in the background
+	if err := api.queues.email.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := api.publishEmail(es, emailPasswordReset); err != nil {
+		t.Fatal(err)
+	}
+	api.emails.wait()
+	report := api.emails.report()
+	if sent := report["sent"].(map[string]int64)[emailPasswordReset]; sent != 1 {
+		t.Fatalf("sent = %v, want 1", sent)
+	}
+	if failed := report["failed"].(map[string]int64)[emailPasswordReset]; failed != 1 {
+		t.Fatalf("failed = %v, want 1", failed)
+	}
+}
@@ -0,0 +1,123 @@
+package v2
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// issuedAtLeeway tolerates clock differences between api instances
+// when checking that tokens weren't issued in the future
+const issuedAtLeeway = time.Minute
+
+// TemporalClaims are the claims of the tokens issued by the api. Claims are
+// converted to this type in a single place, rather than type asserting
+// values of a jwt.MapClaims wherever a claim is needed
+type TemporalClaims struct {
+	// ID is the username the token was issued to
+	ID string
+	// ExpiresAt is the unix time at which the token expires
+	ExpiresAt int64
+	// OrigIssuedAt is the unix time at which the session was started,
+	// which is retained when tokens are refreshed
+	OrigIssuedAt int64
+	// Impersonator is the administrator impersonating the user, if any
+	Impersonator string
+	// Custom holds any other claims, such as those added by Options.ClaimsAugmenter
+	Custom map[string]interface{}
+}
+
+// Valid implements jwt.Claims, ensuring the token identifies a user and
+// was issued in the past with an expiry that has not yet passed
+func (tc TemporalClaims) Valid() error {
+	now := time.Now()
+	switch {
+	case tc.ID == "":
+		return errors.New("token is missing the id claim")
+	case tc.ExpiresAt == 0:
+		return errors.New("token is missing the exp claim")
+	case now.Unix() > tc.ExpiresAt:
+		return errors.New("token is expired")
+	}
+	return tc.validIssuedAt(now)
+}
+
+// validIssuedAt ensures that the session the token belongs to wasn't started
+// in the future, allowing for issuedAtLeeway, or after the token expires
+func (tc TemporalClaims) validIssuedAt(now time.Time) error {
+	switch {
+	case tc.OrigIssuedAt > now.Add(issuedAtLeeway).Unix():
+		return errors.New("token was issued in the future")
+	case tc.ExpiresAt != 0 && tc.OrigIssuedAt > tc.ExpiresAt:
+		return errors.New("token was issued after it expired")
+	}
+	return nil
+}
+
+// MarshalJSON encodes the claims as a flat object. Custom claims
+// can't override the claims represented by the other fields
+func (tc TemporalClaims) MarshalJSON() ([]byte, error) {
+	claims := make(map[string]interface{}, len(tc.Custom)+4)
+	for name, value := range tc.Custom {
+		claims[name] = value
+	}
+	claims["id"] = tc.ID
+	claims["exp"] = tc.ExpiresAt
+	claims["orig_iat"] = tc.OrigIssuedAt
+	if tc.Impersonator != "" {
+		claims["impersonator"] = tc.Impersonator
+	} else {
+		delete(claims, "impersonator")
+	}
+	return json.Marshal(claims)
+}
+
+// UnmarshalJSON decodes a flat object of claims
+func (tc *TemporalClaims) UnmarshalJSON(data []byte) error {
+	var claims map[string]interface{}
+	if err := json.Unmarshal(data, &claims); err != nil {
+		return err
+	}
+	*tc = claimsFromMap(claims)
+	return nil
+}
+
+// claimsFromMap converts claims, such as those parsed by the jwt middleware.
+// Claims of the wrong type are treated as missing
+func claimsFromMap(claims map[string]interface{}) TemporalClaims {
+	tc := TemporalClaims{
+		ExpiresAt:    claimUnix(claims["exp"]),
+		OrigIssuedAt: claimUnix(claims["orig_iat"]),
+	}
+	tc.ID, _ = claims["id"].(string)
+	tc.Impersonator, _ = claims["impersonator"].(string)
+	for name, value := range claims {
+		switch name {
+		case "id", "exp", "orig_iat", "impersonator":
+		default:
+			if tc.Custom == nil {
+				tc.Custom = make(map[string]interface{})
+			}
+			tc.Custom[name] = value
+		}
+	}
+	return tc
+}
+
+// claimUnix parses a unix timestamp claim, which is a float64 when parsed
+// from a token, and an integer when set by us
+func claimUnix(claim interface{}) int64 {
+	switch v := claim.(type) {
+	case float64:
+		return int64(v)
+	case int64:
+		return v
+	case int:
+		return int64(v)
+	case json.Number:
+		i, _ := v.Int64()
+		return i
+	default:
+		return 0
+	}
+}
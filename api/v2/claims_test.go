@@ -0,0 +1,79 @@
+package v2
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	jwt "gopkg.in/dgrijalva/jwt-go.v3"
+)
+
+func TestTemporalClaims_Valid(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name    string
+		claims  TemporalClaims
+		wantErr bool
+	}{
+		{"Valid", TemporalClaims{ID: "testuser", ExpiresAt: now.Add(time.Hour).Unix(), OrigIssuedAt: now.Unix()}, false},
+		{"Missing-ID", TemporalClaims{ExpiresAt: now.Add(time.Hour).Unix(), OrigIssuedAt: now.Unix()}, true},
+		{"Missing-Expiry", TemporalClaims{ID: "testuser", OrigIssuedAt: now.Unix()}, true},
+		{"Expired", TemporalClaims{ID: "testuser", ExpiresAt: now.Add(-time.Hour).Unix(), OrigIssuedAt: now.Unix()}, true},
+		{"Issued-In-Future", TemporalClaims{ID: "testuser", ExpiresAt: now.Add(time.Hour).Unix(), OrigIssuedAt: now.Add(time.Hour).Unix()}, true},
+		{"Issued-Within-Leeway", TemporalClaims{ID: "testuser", ExpiresAt: now.Add(time.Hour).Unix(), OrigIssuedAt: now.Add(time.Second * 30).Unix()}, false},
+		{"Issued-After-Expiry", TemporalClaims{ID: "testuser", ExpiresAt: now.Add(time.Second * 10).Unix(), OrigIssuedAt: now.Add(time.Second * 30).Unix()}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.claims.Valid(); (err != nil) != tt.wantErr {
+				t.Fatalf("Valid() err = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestTemporalClaims_Token(t *testing.T) {
+	key := []byte("suchsecretmuchkeyverysecurewowsuchsecret")
+	now := time.Now()
+	claims := TemporalClaims{
+		ID:           "testuser",
+		ExpiresAt:    now.Add(time.Hour).Unix(),
+		OrigIssuedAt: now.Unix(),
+		Impersonator: "admin",
+		Custom: map[string]interface{}{
+			"org_id": "someorg",
+			// custom claims can't override reserved claims
+			"id": "someoneelse",
+		},
+	}
+	signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// tokens must remain readable as untyped claims, as used by the jwt middleware
+	mapToken, err := jwt.Parse(signed, func(*jwt.Token) (interface{}, error) { return key, nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := mapToken.Claims.(jwt.MapClaims)["id"]; got != "testuser" {
+		t.Fatalf("id claim = %v, want testuser", got)
+	}
+	parsed := &TemporalClaims{}
+	if _, err := jwt.ParseWithClaims(signed, parsed, func(*jwt.Token) (interface{}, error) { return key, nil }); err != nil {
+		t.Fatal(err)
+	}
+	want := claims
+	want.Custom = map[string]interface{}{"org_id": "someorg"}
+	if !reflect.DeepEqual(*parsed, want) {
+		t.Fatalf("parsed claims = %+v, want %+v", *parsed, want)
+	}
+	// expired tokens fail validation when parsed
+	claims.ExpiresAt = now.Add(-time.Hour).Unix()
+	signed, err = jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := jwt.ParseWithClaims(signed, &TemporalClaims{}, func(*jwt.Token) (interface{}, error) { return key, nil }); err == nil {
+		t.Fatal("expected expired token to fail validation")
+	}
+}
@@ -0,0 +1,112 @@
+package v2
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// concurrencyLimitTTL is how long a user's limit is reused before it is
+// looked up again, so that tier changes apply without a lookup per request
+const concurrencyLimitTTL = time.Minute
+
+// concurrencyLimiter limits the number of requests
+// each user can have in flight at the same time
+type concurrencyLimiter struct {
+	mux      sync.Mutex
+	inFlight map[string]int
+	limits   map[string]userLimit
+	// limitFor returns the limit for a user, 0 is unlimited
+	limitFor func(username string) int
+}
+
+// userLimit is a user's limit, as of when it was looked up
+type userLimit struct {
+	limit int
+	at    time.Time
+}
+
+func newConcurrencyLimiter(limitFor func(username string) int) *concurrencyLimiter {
+	return &concurrencyLimiter{
+		inFlight: make(map[string]int),
+		limits:   make(map[string]userLimit),
+		limitFor: limitFor,
+	}
+}
+
+// limit returns the limit of username, looking
+// it up if it isn't known or is out of date
+func (cl *concurrencyLimiter) limit(username string, now time.Time) int {
+	cl.mux.Lock()
+	cached, ok := cl.limits[username]
+	cl.mux.Unlock()
+	if ok && now.Sub(cached.at) < concurrencyLimitTTL {
+		return cached.limit
+	}
+	limit := cl.limitFor(username)
+	cl.mux.Lock()
+	// drop out of date limits so that the cache doesn't grow without bound
+	for name, cached := range cl.limits {
+		if now.Sub(cached.at) >= concurrencyLimitTTL {
+			delete(cl.limits, name)
+		}
+	}
+	cl.limits[username] = userLimit{limit: limit, at: now}
+	cl.mux.Unlock()
+	return limit
+}
+
+// acquire reserves a slot for username, returning false if none are available
+func (cl *concurrencyLimiter) acquire(username string) bool {
+	limit := cl.limit(username, time.Now())
+	cl.mux.Lock()
+	defer cl.mux.Unlock()
+	if limit > 0 && cl.inFlight[username] >= limit {
+		return false
+	}
+	cl.inFlight[username]++
+	return true
+}
+
+func (cl *concurrencyLimiter) release(username string) {
+	cl.mux.Lock()
+	defer cl.mux.Unlock()
+	if cl.inFlight[username]--; cl.inFlight[username] <= 0 {
+		delete(cl.inFlight, username)
+	}
+}
+
+// middleware must run after authentication, and holds
+// the user's slot until the rest of the chain returns
+func (cl *concurrencyLimiter) middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		username := ClaimUser(c)
+		if username == "" {
+			c.Next()
+			return
+		}
+		if !cl.acquire(username) {
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{
+				"code":     http.StatusTooManyRequests,
+				"response": "too many concurrent requests",
+			})
+			return
+		}
+		defer cl.release(username)
+		c.Next()
+	}
+}
+
+// concurrentRequestLimit returns the concurrent request limit of a user's tier
+func (api *API) concurrentRequestLimit(username string) int {
+	usage, err := api.usage.FindByUserName(username)
+	if err != nil {
+		// fall back to the most restrictive tier
+		info, _ := api.tiers.get("")
+		return info.MaxConcurrentRequests
+	}
+	info, _ := api.tiers.get(usage.Tier)
+	return info.MaxConcurrentRequests
+}
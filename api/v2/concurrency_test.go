@@ -0,0 +1,83 @@
+package v2
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	jwt "gopkg.in/dgrijalva/jwt-go.v3"
+)
+
+func Test_concurrencyLimiter(t *testing.T) {
+	cl := newConcurrencyLimiter(func(string) int { return 2 })
+	var (
+		started = make(chan struct{})
+		finish  = make(chan struct{})
+	)
+	_, engine := gin.CreateTestContext(httptest.NewRecorder())
+	engine.GET("/slow", func(c *gin.Context) {
+		c.Set("JWT_PAYLOAD", jwt.MapClaims{"id": "testuser"})
+	}, cl.middleware(), func(c *gin.Context) {
+		if c.Query("block") != "" {
+			started <- struct{}{}
+			<-finish
+		}
+		c.String(http.StatusOK, "done")
+	})
+	request := func(target string) int {
+		testRecorder := httptest.NewRecorder()
+		engine.ServeHTTP(testRecorder, httptest.NewRequest("GET", target, nil))
+		return testRecorder.Code
+	}
+	// occupy both slots
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if code := request("/slow?block=true"); code != http.StatusOK {
+				t.Errorf("blocking request got status %v", code)
+			}
+		}()
+		<-started
+	}
+	if code := request("/slow"); code != http.StatusTooManyRequests {
+		t.Fatalf("request over the limit got status %v", code)
+	}
+	// once the in flight requests complete their slots are freed
+	close(finish)
+	wg.Wait()
+	if code := request("/slow"); code != http.StatusOK {
+		t.Fatalf("request after slots freed got status %v", code)
+	}
+}
+
+func Test_concurrencyLimiter_limit(t *testing.T) {
+	var lookups int
+	cl := newConcurrencyLimiter(func(string) int {
+		lookups++
+		return lookups
+	})
+	now := time.Now()
+	// limits are reused until they are out of date
+	if limit := cl.limit("testuser", now); limit != 1 {
+		t.Fatalf("limit = %v, want 1", limit)
+	}
+	if limit := cl.limit("testuser", now.Add(concurrencyLimitTTL/2)); limit != 1 {
+		t.Fatalf("limit = %v, want 1", limit)
+	}
+	if limit := cl.limit("testuser", now.Add(concurrencyLimitTTL)); limit != 2 {
+		t.Fatalf("limit = %v, want 2", limit)
+	}
+	// out of date limits of other users are dropped
+	cl.limit("otheruser", now.Add(concurrencyLimitTTL*3))
+	if _, ok := cl.limits["testuser"]; ok {
+		t.Fatal("expected out of date limit to be dropped")
+	}
+	if lookups != 3 {
+		t.Fatalf("got %v lookups, want 3", lookups)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"net/http"
 	"strings"
+	"time"
 
 	jwt "github.com/appleboy/gin-jwt"
 	"github.com/gin-gonic/gin"
@@ -64,6 +65,50 @@ func GetAuthenticatedUserFromContext(c *gin.Context) (string, error) {
 	return strID, nil
 }
 
+// ClaimUser returns the username the request was authenticated as,
+// or an empty string if the request is not authenticated
+func ClaimUser(c *gin.Context) string {
+	return claimsFromContext(c).ID
+}
+
+// ClaimImpersonator returns the administrator impersonating the user the
+// request was authenticated as, or an empty string if it isn't impersonated
+func ClaimImpersonator(c *gin.Context) string {
+	return claimsFromContext(c).Impersonator
+}
+
+// Claim returns a claim of the token used to authenticate the request, such as
+// one added by Options.ClaimsAugmenter, or nil if it is not present
+func Claim(c *gin.Context, name string) interface{} {
+	return jwt.ExtractClaims(c)[name]
+}
+
+// ClaimExpiry returns the time at which the token used to authenticate
+// the request expires, or the zero time if it is not present
+func ClaimExpiry(c *gin.Context) time.Time {
+	return unixOrZero(claimsFromContext(c).ExpiresAt)
+}
+
+// ClaimIssuedAt returns the time at which the token used to authenticate
+// the request was originally issued, or the zero time if it is not present.
+// Refreshed tokens retain the issue time of the token they were refreshed from
+func ClaimIssuedAt(c *gin.Context) time.Time {
+	return unixOrZero(claimsFromContext(c).OrigIssuedAt)
+}
+
+// claimsFromContext returns the claims of the token used to authenticate the request
+func claimsFromContext(c *gin.Context) TemporalClaims {
+	return claimsFromMap(jwt.ExtractClaims(c))
+}
+
+// unixOrZero converts a unix timestamp claim, treating 0 as missing
+func unixOrZero(unix int64) time.Time {
+	if unix == 0 {
+		return time.Time{}
+	}
+	return time.Unix(unix, 0)
+}
+
 // GetAuthToken is used to retrieve the jwt token
 // from an authenticated request
 func GetAuthToken(c *gin.Context) string {
@@ -2,7 +2,12 @@ package v2
 
 import (
 	"net/http"
+	"net/http/httptest"
 	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	jwt "gopkg.in/dgrijalva/jwt-go.v3"
 )
 
 func Test_status(t *testing.T) {
@@ -26,3 +31,40 @@ func Test_status(t *testing.T) {
 		})
 	}
 }
+
+func Test_Claims(t *testing.T) {
+	exp := time.Now().Add(time.Hour).Unix()
+	iat := time.Now().Unix()
+	tests := []struct {
+		name       string
+		claims     jwt.MapClaims
+		wantUser   string
+		wantExpiry time.Time
+		wantIssued time.Time
+	}{
+		{"No-Claims", nil, "", time.Time{}, time.Time{}},
+		{"Parsed-Claims", jwt.MapClaims{"id": "testuser", "exp": float64(exp), "orig_iat": float64(iat)},
+			"testuser", time.Unix(exp, 0), time.Unix(iat, 0)},
+		{"Issued-Claims", jwt.MapClaims{"id": "testuser", "exp": exp, "orig_iat": iat},
+			"testuser", time.Unix(exp, 0), time.Unix(iat, 0)},
+		{"Bad-Claim-Types", jwt.MapClaims{"id": 1, "exp": "tomorrow", "orig_iat": "today"},
+			"", time.Time{}, time.Time{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			if tt.claims != nil {
+				c.Set("JWT_PAYLOAD", tt.claims)
+			}
+			if got := ClaimUser(c); got != tt.wantUser {
+				t.Errorf("ClaimUser() = %v, want %v", got, tt.wantUser)
+			}
+			if got := ClaimExpiry(c); !got.Equal(tt.wantExpiry) {
+				t.Errorf("ClaimExpiry() = %v, want %v", got, tt.wantExpiry)
+			}
+			if got := ClaimIssuedAt(c); !got.Equal(tt.wantIssued) {
+				t.Errorf("ClaimIssuedAt() = %v, want %v", got, tt.wantIssued)
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package v2
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	// creditGranted is reported for users who were granted credits
+	creditGranted = "granted"
+	// creditAlreadyGranted is reported for users who were granted
+	// credits by an earlier run of the same grant
+	creditAlreadyGranted = "already granted"
+)
+
+// creditGrant records that a user received a bulk credit grant, so that a
+// grant which partially failed can be re-run without granting credits twice
+type creditGrant struct {
+	GrantID   string `gorm:"primary_key"`
+	UserName  string `gorm:"primary_key"`
+	GrantedAt time.Time
+}
+
+// runCreditGrant calls add for each user who hasn't yet received grantID,
+// returning the outcome for every user. Users whose grant failed can be
+// retried by running the grant again
+func runCreditGrant(db *gorm.DB, grantID string, usernames []string, add func(tx *gorm.DB, username string) error) map[string]string {
+	results := make(map[string]string, len(usernames))
+	for _, username := range usernames {
+		granted, err := grantCredit(db, grantID, username, add)
+		switch {
+		case err != nil:
+			results[username] = err.Error()
+		case !granted:
+			results[username] = creditAlreadyGranted
+		default:
+			results[username] = creditGranted
+		}
+	}
+	return results
+}
+
+// grantCredit records grantID for username and calls add in the same
+// transaction, reporting false if username already received grantID. The
+// record's key serializes concurrent runs of the same grant, as inserting
+// it waits for any other run's transaction to complete
+func grantCredit(db *gorm.DB, grantID, username string, add func(tx *gorm.DB, username string) error) (bool, error) {
+	tx := db.Begin()
+	if tx.Error != nil {
+		return false, tx.Error
+	}
+	defer tx.Rollback()
+	if err := tx.Create(&creditGrant{
+		GrantID:   grantID,
+		UserName:  username,
+		GrantedAt: time.Now(),
+	}).Error; err != nil {
+		// the failed insert aborts the transaction, so the earlier grant
+		// is looked for outside of it
+		if db.Where("grant_id = ? AND user_name = ?", grantID, username).
+			First(&creditGrant{}).Error == nil {
+			return false, nil
+		}
+		return false, err
+	}
+	if err := add(tx, username); err != nil {
+		return false, err
+	}
+	return true, tx.Commit().Error
+}
@@ -0,0 +1,98 @@
+package v2
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/RTradeLtd/Temporal/utils"
+	"github.com/jinzhu/gorm"
+)
+
+func Test_runCreditGrant(t *testing.T) {
+	api := newTestAPI(t)
+	grantID := utils.GenerateRandomUtils().GenerateString(32, utils.LetterBytes)
+	granted := make(map[string]int)
+	failing := map[string]bool{"baduser": true}
+	add := func(tx *gorm.DB, username string) error {
+		if failing[username] {
+			return errors.New("user not found")
+		}
+		granted[username]++
+		return nil
+	}
+	users := []string{"testuser", "baduser", "otheruser"}
+	// a batch with a failure reports the outcome of every user
+	got := runCreditGrant(api.dbm.DB, grantID, users, add)
+	want := map[string]string{
+		"testuser":  creditGranted,
+		"baduser":   "user not found",
+		"otheruser": creditGranted,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("runCreditGrant() = %v, want %v", got, want)
+	}
+	// failed grants aren't recorded
+	var count int
+	if err := api.dbm.DB.Model(&creditGrant{}).
+		Where("grant_id = ? AND user_name = ?", grantID, "baduser").Count(&count).Error; err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Fatal("expected failed grant not to be recorded")
+	}
+	// re-running the grant only retries the failed users
+	failing["baduser"] = false
+	got = runCreditGrant(api.dbm.DB, grantID, users, add)
+	want = map[string]string{
+		"testuser":  creditAlreadyGranted,
+		"baduser":   creditGranted,
+		"otheruser": creditAlreadyGranted,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("runCreditGrant() = %v, want %v", got, want)
+	}
+	for _, user := range users {
+		if granted[user] != 1 {
+			t.Fatalf("%s was granted credits %v times, want 1", user, granted[user])
+		}
+	}
+	// separate grants are tracked independently
+	if got := runCreditGrant(api.dbm.DB, grantID+"-another", []string{"testuser"}, add); got["testuser"] != creditGranted {
+		t.Fatalf("expected a new grant to be applied, got %v", got["testuser"])
+	}
+	// credits aren't added again for a grant that was already applied
+	if got := runCreditGrant(api.dbm.DB, grantID, []string{"testuser"}, func(tx *gorm.DB, username string) error {
+		t.Fatal("credits added for a grant that was already applied")
+		return nil
+	}); got["testuser"] != creditAlreadyGranted {
+		t.Fatalf("expected grant to be already applied, got %v", got["testuser"])
+	}
+}
+
+func Test_runCreditGrant_Concurrent(t *testing.T) {
+	api := newTestAPI(t)
+	grantID := utils.GenerateRandomUtils().GenerateString(32, utils.LetterBytes)
+	var (
+		granted int64
+		wg      sync.WaitGroup
+	)
+	add := func(tx *gorm.DB, username string) error {
+		atomic.AddInt64(&granted, 1)
+		return nil
+	}
+	// concurrent runs of the same grant only grant credits once
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			runCreditGrant(api.dbm.DB, grantID, []string{"testuser"}, add)
+		}()
+	}
+	wg.Wait()
+	if granted != 1 {
+		t.Fatalf("testuser was granted credits %v times, want 1", granted)
+	}
+}
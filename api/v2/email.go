@@ -0,0 +1,198 @@
+package v2
+
+import (
+	"sync"
+	"time"
+
+	"github.com/RTradeLtd/Temporal/queue"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// defaultEmailPublishAttempts is the number of publish attempts
+	// made when Options.EmailPublishAttempts is unset
+	defaultEmailPublishAttempts = 3
+	// defaultEmailPublishDelay is the initial retry delay used
+	// when Options.EmailPublishDelay is unset
+	defaultEmailPublishDelay = time.Millisecond * 250
+	// broadcastBatchSize is the maximum number of
+	// recipients within a single broadcast message
+	broadcastBatchSize = 100
+)
+
+// email types, under which publish outcomes are counted
+const (
+	emailVerification         = "verification"
+	emailVerificationReminder = "verification-reminder"
+	emailUsernameReminder     = "username-reminder"
+	emailPasswordReset        = "password-reset"
+	emailPasswordChanged      = "password-changed"
+	emailUpgrade              = "upgrade"
+	emailBroadcast            = "broadcast"
+	emailAdminAlert           = "admin-alert"
+	emailSuspension           = "suspension"
+	emailMagicLink            = "magic-link"
+)
+
+// emailStats counts the emails published to the queue and those which
+// failed to publish after retrying, by email type, so that alerts can be
+// raised when deliverability degrades
+type emailStats struct {
+	mux    sync.Mutex
+	sent   map[string]int64
+	failed map[string]int64
+	// pending tracks the emails still being published
+	pending sync.WaitGroup
+}
+
+// wait blocks until every email being published was either sent or failed
+func (es *emailStats) wait() {
+	es.pending.Wait()
+}
+
+// published records the outcome of publishing an email of the given type
+func (es *emailStats) published(kind string, err error) {
+	es.mux.Lock()
+	defer es.mux.Unlock()
+	if es.sent == nil {
+		es.sent = make(map[string]int64)
+		es.failed = make(map[string]int64)
+	}
+	if err != nil {
+		es.failed[kind]++
+	} else {
+		es.sent[kind]++
+	}
+}
+
+func (es *emailStats) report() gin.H {
+	es.mux.Lock()
+	defer es.mux.Unlock()
+	sent := make(map[string]int64, len(es.sent))
+	for kind, count := range es.sent {
+		sent[kind] = count
+	}
+	failed := make(map[string]int64, len(es.failed))
+	for kind, count := range es.failed {
+		failed[kind] = count
+	}
+	return gin.H{"sent": sent, "failed": failed}
+}
+
+// publishEmail is used to send an email message to the queue for processing.
+// Publishing happens in the background, retrying with an exponential backoff
+// so that a briefly unavailable queue neither fails nor delays user facing
+// calls, and the outcome is logged and counted under kind. Recipients who
+// opted out of kind are left out, and nothing is published if none remain
+func (api *API) publishEmail(es queue.EmailSend, kind string) error {
+	return api.publishEmailThen(es, kind, nil)
+}
+
+// publishEmailThen is publishEmail, additionally calling done
+// with the outcome once publishing finishes, if it is set
+func (api *API) publishEmailThen(es queue.EmailSend, kind string, done func(error)) error {
+	es, err := api.withoutOptedOut(es, kind)
+	if err != nil {
+		return err
+	}
+	if optionalEmails[kind] && len(es.Emails) == 0 {
+		return nil
+	}
+	api.setEmailSender(&es)
+	attempts := api.opts.EmailPublishAttempts
+	if attempts <= 0 {
+		attempts = defaultEmailPublishAttempts
+	}
+	delay := api.opts.EmailPublishDelay
+	if delay <= 0 {
+		delay = defaultEmailPublishDelay
+	}
+	api.emails.pending.Add(1)
+	go func() {
+		defer api.emails.pending.Done()
+		err := retryWithBackoff(attempts, delay, func() error {
+			err := api.queues.email.PublishMessage(es)
+			if err != nil {
+				api.l.Warnw("failed to publish email message",
+					"type", kind, "subject", es.Subject, "error", err.Error())
+			}
+			return err
+		})
+		if err != nil {
+			api.l.Errorw("failed to publish email message after retrying",
+				"type", kind, "subject", es.Subject, "error", err.Error())
+		}
+		api.emails.published(kind, err)
+		if done != nil {
+			done(err)
+		}
+	}()
+	return nil
+}
+
+// notifyPasswordChanged emails a user that their password was changed, so that
+// unauthorized changes are noticed. Notifications are rate limited per user so
+// that repeated password changes can't be used to flood their inbox
+func (api *API) notifyPasswordChanged(username string) error {
+	if !api.limits.passwordChanged.allow(username) {
+		api.l.Infow("password changed notification rate limited", "user", username)
+		return nil
+	}
+	user, err := api.um.FindByUserName(username)
+	if err != nil {
+		return err
+	}
+	es := passwordChangedEmail(time.Now())
+	es.UserNames = []string{user.UserName}
+	es.Emails = []string{user.EmailAddress}
+	return api.publishEmail(es, emailPasswordChanged)
+}
+
+// batchEmail splits the recipients of es across as few messages as possible,
+// each with at most batchSize recipients, to reduce load on the queue
+func batchEmail(es queue.EmailSend, batchSize int) []queue.EmailSend {
+	var batches []queue.EmailSend
+	for start := 0; start < len(es.Emails); start += batchSize {
+		end := start + batchSize
+		if end > len(es.Emails) {
+			end = len(es.Emails)
+		}
+		batch := es
+		batch.UserNames = es.UserNames[start:end]
+		batch.Emails = es.Emails[start:end]
+		batches = append(batches, batch)
+	}
+	return batches
+}
+
+// setEmailSender is used to populate any unset sender details of an email
+// with the ones configured for the API
+func (api *API) setEmailSender(es *queue.EmailSend) {
+	if es.FromName == "" {
+		es.FromName = api.opts.EmailFromName
+	}
+	if es.FromAddress == "" {
+		es.FromAddress = api.opts.EmailFromAddress
+	}
+	if es.ReplyTo == "" {
+		es.ReplyTo = api.opts.EmailReplyTo
+	}
+}
+
+// retryWithBackoff calls fn until it succeeds or the number of attempts
+// is exhausted, doubling delay after every failure. The last error
+// returned by fn is returned if all attempts fail
+func retryWithBackoff(attempts int, delay time.Duration, fn func() error) error {
+	var err error
+	for i := 0; i < attempts; i++ {
+		if err = fn(); err == nil {
+			return nil
+		}
+		// don't sleep after the final attempt
+		if i < attempts-1 {
+			time.Sleep(delay)
+			delay *= 2
+		}
+	}
+	return err
+}
@@ -0,0 +1,194 @@
+package v2
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/RTradeLtd/Temporal/mail"
+	"github.com/RTradeLtd/Temporal/queue"
+	"github.com/RTradeLtd/database/v2/models"
+	"github.com/gin-gonic/gin"
+)
+
+// htmlEmail returns an html email, along with a plaintext alternative
+// for mail clients and spam filters that prefer it
+func htmlEmail(subject, content string) queue.EmailSend {
+	return queue.EmailSend{
+		Subject:      subject,
+		Content:      content,
+		ContentType:  "text/html",
+		PlainContent: mail.HTMLToText(content),
+	}
+}
+
+// welcomeEmail builds the email sent after registration, which
+// contains the link used to verify the account's email address
+func welcomeEmail(organizationName, verifyURL string) queue.EmailSend {
+	// format a link tag
+	link := fmt.Sprintf("<a href=\"%s\">link</a>", verifyURL)
+	return welcomeContent(organizationName, "To validate your email, just click the following "+link+"\n")
+}
+
+// welcomeCodeEmail builds the email sent after registration when accounts
+// are verified by entering a numeric code, such as from mobile clients
+func welcomeCodeEmail(organizationName, code string) queue.EmailSend {
+	return welcomeContent(
+		organizationName,
+		fmt.Sprintf("To validate your email, just enter the following code: <b>%s</b>\n", code),
+	)
+}
+
+// welcomeContent builds the welcome email around the given instructions for
+// verifying the account's email address
+func welcomeContent(organizationName, verifyInstructions string) queue.EmailSend {
+	return htmlEmail(
+		fmt.Sprintf(
+			"%s Welcome To Temporal 🌌 Read This For Crucial Getting Started Tips", organizationName,
+		),
+		fmt.Sprintf(
+			"%s%s%s%s%s%s%s%s%s%s%s%s%s%s%s%s%s%s%s%s%s",
+			"Thanks for signing up with Temporal, before you get started it's important we discuss our pinning system.\n",
+			"When uploading to Temporal you must specify a \"hold time\" which tells our system how long your data should be around for.\n",
+			"When you're using Temporal via the playground, or the API directly you can configure this for up to 24 months with paid accounts, and up to 12 months for free accounts.\n",
+			"Temporal’s free tier offers 3GB of storage on the house, paid tier rates are just $0.07/GB and partner tier rates are $0.05/GB.\n",
+			"<br>",
+			"<br>",
+			"When using Temporal through third-party implementations like our IPFS HTTP API reverse proxy, or the ENS management app, we use a default hold time of 12 months for free users and 1 month for paid users.\n",
+			"We use 1 month for paid users because being in the paid tier means you have to pay for the data consumption and we don't want to overcharge paid users.\n",
+			"For example if you used the ENS management app to upload your website, and want it to stick around for longer than the default duration you need to extend the pin.\n",
+			"Pin extension can be done via the <a href=\"https://play2.temporal.cloud\">Temporal Playground</a> or via the API.\n",
+			"<br>",
+			"<br>",
+			"Lastly let's talk about emails! We try our best to not spam your inbox, so we limit emails to a few things: payment notifications, pin expiration warnings, password/username retrieval and processing failures.\n",
+			"But before we do this, you must validate your email. Additionally before validating your email, you are in the 'unverified' tier which is limited to 100MB of data consumption. Email verification is now mandatory\n",
+			verifyInstructions,
+			"<br>",
+			"<br>",
+			"Questions, comments, concerns, or just feeling talkative? Join us on Telegram where you can receive live support and updates: <a href=\"https://t.me/RTradeTEMPORAL\">click here</a>\n",
+			"<br>",
+			"<br>",
+			"Thanks for signing up!",
+		),
+	)
+}
+
+// usernameReminderEmail builds the email sent when a username reminder is requested
+func usernameReminderEmail(username string) queue.EmailSend {
+	return htmlEmail("TEMPORAL User Name Reminder", fmt.Sprintf("your username is %s", username))
+}
+
+// passwordResetEmail builds the email containing a newly generated password
+func passwordResetEmail(password string) queue.EmailSend {
+	return htmlEmail("TEMPORAL Password Reset", fmt.Sprintf("your password is %s", password))
+}
+
+// passwordChangedEmail builds the email notifying a user that their password was changed
+func passwordChangedEmail(changedAt time.Time) queue.EmailSend {
+	return htmlEmail(
+		"TEMPORAL Password Changed",
+		fmt.Sprintf(
+			"the password for your account was changed at %s, if you did not make this change please reset your password and contact support@rtradetechnologies.com",
+			changedAt.UTC().Format(time.RFC1123),
+		),
+	)
+}
+
+// accountUpgradedEmail builds the email sent once an account is upgraded to tier
+func accountUpgradedEmail(tier models.DataUsageTier) queue.EmailSend {
+	return htmlEmail("TEMPORAL Account Upgraded", fmt.Sprintf("your account has been upgraded to the %s tier!", tier))
+}
+
+// upgradeEmailForTier rebuilds the upgrade email of an account in tier,
+// failing if tier isn't one that accounts can upgrade to
+func upgradeEmailForTier(tiers tierRegistry, tier models.DataUsageTier) (queue.EmailSend, error) {
+	for _, info := range tiers {
+		if info.UpgradeTo == tier {
+			return accountUpgradedEmail(tier), nil
+		}
+	}
+	return queue.EmailSend{}, errors.New("account has not been upgraded")
+}
+
+// accountSuspendedEmail builds the email notifying a user that their account
+// was suspended by an administrator, along with the reason if one was given
+func accountSuspendedEmail(reason string) queue.EmailSend {
+	content := "your account has been suspended, please contact support@rtradetechnologies.com if you believe this is an error"
+	if reason != "" {
+		content = fmt.Sprintf("your account has been suspended for the following reason: %s<br>please contact support@rtradetechnologies.com if you believe this is an error", html.EscapeString(reason))
+	}
+	return htmlEmail("TEMPORAL Account Suspended", content)
+}
+
+// accountRestoredEmail builds the email sent once a suspended account is restored
+func accountRestoredEmail() queue.EmailSend {
+	return htmlEmail("TEMPORAL Account Restored", "your account has been restored and you may sign in again")
+}
+
+// magicLinkEmail builds the email containing a single use sign in link
+func magicLinkEmail(link string, lifetime time.Duration) queue.EmailSend {
+	return htmlEmail(
+		"TEMPORAL Sign In Link",
+		fmt.Sprintf(
+			"To sign in to your account, just click the following <a href=\"%s\">link</a>. It can only be used once, and expires in %s. If you did not request this link you can safely ignore this email",
+			html.EscapeString(link), lifetime,
+		),
+	)
+}
+
+// adminAlertEmail builds the email notifying administrators of a spike in suspicious events
+func adminAlertEmail(event string, count int64, window time.Duration) queue.EmailSend {
+	return htmlEmail(
+		"TEMPORAL Admin Alert: "+event,
+		fmt.Sprintf("%v %s have occurred within the last %s, which may indicate abuse of the api", count, event, window),
+	)
+}
+
+// emailPreviews renders each of our emails with sample data
+var emailPreviews = map[string]func() queue.EmailSend{
+	"welcome": func() queue.EmailSend {
+		return welcomeEmail("", "https://dev.api.temporal.cloud/v2/account/email/verify/testuser/sampletoken")
+	},
+	"welcome-code": func() queue.EmailSend { return welcomeCodeEmail("", "123456") },
+	"username":     func() queue.EmailSend { return usernameReminderEmail("testuser") },
+	"password":     func() queue.EmailSend { return passwordResetEmail("samplepassword") },
+	"password-changed": func() queue.EmailSend {
+		return passwordChangedEmail(time.Now())
+	},
+	"upgrade": func() queue.EmailSend { return accountUpgradedEmail(models.Paid) },
+	"suspended": func() queue.EmailSend {
+		return accountSuspendedEmail("uploading content in violation of the terms of service")
+	},
+	"restored": accountRestoredEmail,
+	"magic-link": func() queue.EmailSend {
+		return magicLinkEmail("https://play2.temporal.cloud/magic?token=sampletoken&username=testuser", magicLinkLifetime)
+	},
+	"admin-alert": func() queue.EmailSend {
+		return adminAlertEmail("failed logins", 100, alertWindow)
+	},
+}
+
+// previewEmail is used to render an email with sample data so that changes to
+// the templates can be visually checked. It is only available in dev mode
+func (api *API) previewEmail(c *gin.Context) {
+	if !dev {
+		FailNotAuthorized(c, "email previews are only available in dev mode")
+		return
+	}
+	preview, ok := emailPreviews[c.Param("template")]
+	if !ok {
+		var names []string
+		for name := range emailPreviews {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		FailWithBadRequest(c, fmt.Sprintf("unknown template, must be one of %s", strings.Join(names, ", ")))
+		return
+	}
+	es := preview()
+	c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(es.Content))
+}
@@ -0,0 +1,210 @@
+package v2
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/RTradeLtd/Temporal/queue"
+	"github.com/RTradeLtd/database/v2/models"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap/zaptest"
+)
+
+func Test_retryWithBackoff(t *testing.T) {
+	tests := []struct {
+		name      string
+		attempts  int
+		failures  int
+		wantCalls int
+		wantErr   bool
+	}{
+		{"Succeeds-First-Attempt", 3, 0, 1, false},
+		{"Fails-Twice-Then-Succeeds", 3, 2, 3, false},
+		{"Exhausts-Retries", 3, 5, 3, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls int
+			err := retryWithBackoff(tt.attempts, time.Millisecond, func() error {
+				calls++
+				if calls <= tt.failures {
+					return errors.New("queue unavailable")
+				}
+				return nil
+			})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("retryWithBackoff() err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if calls != tt.wantCalls {
+				t.Fatalf("retryWithBackoff() calls = %v, want %v", calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestAPI_setEmailSender(t *testing.T) {
+	api := &API{opts: Options{
+		EmailFromName:    "Temporal",
+		EmailFromAddress: "temporal@example.org",
+		EmailReplyTo:     "support@example.org",
+	}}
+	es := queue.EmailSend{Subject: "test"}
+	api.setEmailSender(&es)
+	if es.FromName != "Temporal" ||
+		es.FromAddress != "temporal@example.org" ||
+		es.ReplyTo != "support@example.org" {
+		t.Fatalf("sender details not set: %+v", es)
+	}
+	// explicitly provided details must not be overwritten
+	es = queue.EmailSend{Subject: "test", ReplyTo: "billing@example.org"}
+	api.setEmailSender(&es)
+	if es.ReplyTo != "billing@example.org" {
+		t.Fatal("reply-to was overwritten")
+	}
+}
+
+func TestAPI_previewEmail(t *testing.T) {
+	defer func(d bool) { dev = d }(dev)
+	tests := []struct {
+		name     string
+		dev      bool
+		template string
+		wantCode int
+	}{
+		{"Welcome", true, "welcome", http.StatusOK},
+		{"Password", true, "password", http.StatusOK},
+		{"Unknown-Template", true, "notatemplate", http.StatusBadRequest},
+		{"Production-Mode", false, "welcome", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dev = tt.dev
+			recorder := httptest.NewRecorder()
+			_, engine := gin.CreateTestContext(recorder)
+			engine.GET("/:template", (&API{}).previewEmail)
+			engine.ServeHTTP(recorder, httptest.NewRequest("GET", "/"+tt.template, nil))
+			if recorder.Code != tt.wantCode {
+				t.Fatalf("previewEmail() code = %v, want %v", recorder.Code, tt.wantCode)
+			}
+			if tt.wantCode == http.StatusOK &&
+				recorder.Body.String() != emailPreviews[tt.template]().Content {
+				t.Fatal("previewEmail() did not render the template")
+			}
+		})
+	}
+}
+
+func TestAPI_notifyPasswordChanged_RateLimited(t *testing.T) {
+	api := &API{
+		l:      zaptest.NewLogger(t).Sugar(),
+		limits: limits{passwordChanged: newKeyedLimiter(1, time.Hour)},
+	}
+	// exhaust the limit, after which no lookup or publish should be attempted
+	api.limits.passwordChanged.allow("testuser")
+	if err := api.notifyPasswordChanged("testuser"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_welcomeEmail_PlainContent(t *testing.T) {
+	es := welcomeEmail("", "https://api.temporal.cloud/v2/account/email/verify/testuser/sometoken")
+	if es.ContentType != "text/html" || es.Content == "" {
+		t.Fatal("html content not populated")
+	}
+	if es.PlainContent == "" {
+		t.Fatal("plaintext content not populated")
+	}
+	if strings.Contains(es.PlainContent, "<a") || strings.Contains(es.PlainContent, "<br>") {
+		t.Fatal("plaintext content contains html")
+	}
+	// links must survive conversion so the account can still be verified
+	if !strings.Contains(es.PlainContent, "https://api.temporal.cloud/v2/account/email/verify/testuser/sometoken") {
+		t.Fatal("plaintext content is missing verification link")
+	}
+}
+
+func Test_batchEmail(t *testing.T) {
+	tests := []struct {
+		name        string
+		recipients  int
+		batchSize   int
+		wantBatches int
+		wantLast    int
+	}{
+		{"No-Recipients", 0, 100, 0, 0},
+		{"Single-Batch", 50, 100, 1, 50},
+		{"Exact-Batches", 1000, 100, 10, 100},
+		{"Partial-Last-Batch", 1001, 100, 11, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			es := queue.EmailSend{Subject: "broadcast", Content: "hello"}
+			for i := 0; i < tt.recipients; i++ {
+				es.UserNames = append(es.UserNames, fmt.Sprintf("user%v", i))
+				es.Emails = append(es.Emails, fmt.Sprintf("user%v@example.org", i))
+			}
+			batches := batchEmail(es, tt.batchSize)
+			if len(batches) != tt.wantBatches {
+				t.Fatalf("got %v batches, want %v", len(batches), tt.wantBatches)
+			}
+			var total int
+			for _, batch := range batches {
+				if batch.Subject != es.Subject || len(batch.UserNames) != len(batch.Emails) {
+					t.Fatal("bad batch")
+				}
+				total += len(batch.Emails)
+			}
+			if total != tt.recipients {
+				t.Fatalf("got %v recipients, want %v", total, tt.recipients)
+			}
+			if tt.wantBatches > 0 && len(batches[len(batches)-1].Emails) != tt.wantLast {
+				t.Fatalf("got %v recipients in last batch, want %v", len(batches[len(batches)-1].Emails), tt.wantLast)
+			}
+		})
+	}
+}
+
+func Test_emailStats(t *testing.T) {
+	var es emailStats
+	es.published(emailVerification, nil)
+	es.published(emailVerification, errors.New("publish failed"))
+	es.published(emailPasswordReset, errors.New("publish failed"))
+	report := es.report()
+	sent := report["sent"].(map[string]int64)
+	failed := report["failed"].(map[string]int64)
+	if sent[emailVerification] != 1 || sent[emailPasswordReset] != 0 {
+		t.Fatalf("unexpected sent counts %v", sent)
+	}
+	if failed[emailVerification] != 1 || failed[emailPasswordReset] != 1 {
+		t.Fatalf("unexpected failed counts %v", failed)
+	}
+}
+
+func Test_upgradeEmailForTier(t *testing.T) {
+	tests := []struct {
+		name    string
+		tier    models.DataUsageTier
+		wantErr bool
+	}{
+		{"Paid", models.Paid, false},
+		{"Free", models.Free, true},
+		{"Unverified", models.Unverified, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			es, err := upgradeEmailForTier(defaultTiers(), tt.tier)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("upgradeEmailForTier() err = %v, wantErr %v", err, tt.wantErr)
+			}
+			// the email is rebuilt from the tier the account is in
+			if want := "your account has been upgraded to the " + string(tt.tier) + " tier!"; !tt.wantErr && es.Content != want {
+				t.Fatalf("content = %q, want %q", es.Content, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,30 @@
+package v2
+
+import (
+	"sync/atomic"
+
+	"github.com/gin-gonic/gin"
+)
+
+// registrationFunnel counts how far registrations progress towards
+// a verified account. Each stage is only counted once it succeeds,
+// so a failed verification email doesn't skew the later stages
+type registrationFunnel struct {
+	registered         int64
+	verificationEmails int64
+	verified           int64
+}
+
+func (rf *registrationFunnel) accountRegistered() { atomic.AddInt64(&rf.registered, 1) }
+
+func (rf *registrationFunnel) verificationEmailSent() { atomic.AddInt64(&rf.verificationEmails, 1) }
+
+func (rf *registrationFunnel) accountVerified() { atomic.AddInt64(&rf.verified, 1) }
+
+func (rf *registrationFunnel) report() gin.H {
+	return gin.H{
+		"registered":          atomic.LoadInt64(&rf.registered),
+		"verification_emails": atomic.LoadInt64(&rf.verificationEmails),
+		"verified":            atomic.LoadInt64(&rf.verified),
+	}
+}
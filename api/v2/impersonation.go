@@ -0,0 +1,99 @@
+package v2
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/RTradeLtd/Temporal/api/middleware"
+	"github.com/RTradeLtd/Temporal/eh"
+	"github.com/gin-gonic/gin"
+	jwt "gopkg.in/dgrijalva/jwt-go.v3"
+)
+
+// impersonationLifetime is how long impersonation tokens are valid for,
+// intentionally short as they grant full access to another user's account
+const impersonationLifetime = time.Minute * 15
+
+// impersonateUser allows an administrator to obtain a token for another user,
+// which is used by support staff to reproduce issues. The token identifies
+// the administrator through the impersonator claim, and can't be refreshed
+func (api *API) impersonateUser(c *gin.Context) {
+	username, err := GetAuthenticatedUserFromContext(c)
+	if err != nil {
+		api.LogError(c, err, eh.NoAPITokenError)(http.StatusBadRequest)
+		return
+	}
+	forms, missingField := api.extractPostForms(c, "username")
+	if missingField != "" {
+		FailWithMissingField(c, missingField)
+		return
+	}
+	if _, err := api.um.FindByUserName(forms["username"]); err != nil {
+		api.LogError(c, err, eh.UserSearchError)(http.StatusBadRequest)
+		return
+	}
+	token, expire, err := api.signImpersonationToken(forms["username"], username, time.Now())
+	if err != nil {
+		api.LogError(c, err, "failed to generate impersonation token")(http.StatusInternalServerError)
+		return
+	}
+	api.l.Warnw("impersonation token issued",
+		"admin", username, "user", forms["username"], "expire", expire)
+	Respond(c, http.StatusOK, gin.H{"response": gin.H{
+		"token":  token,
+		"expire": expire.Format(time.RFC3339),
+	}})
+}
+
+// signImpersonationToken returns a token for username, compatible with the jwt
+// middleware, that identifies the impersonating administrator
+func (api *API) signImpersonationToken(username, impersonator string, now time.Time) (string, time.Time, error) {
+	expire := now.Add(impersonationLifetime)
+	claims := TemporalClaims{
+		ID:           username,
+		ExpiresAt:    expire.Unix(),
+		OrigIssuedAt: now.Unix(),
+		Impersonator: impersonator,
+		Custom:       make(map[string]interface{}),
+	}
+	// impersonation tokens carry the same custom claims as the user's own tokens
+	middleware.AugmentClaims(claims.Custom, api.opts.ClaimsAugmenter, username)
+	if api.sessions != nil {
+		session, err := middleware.StartSession(api.sessions, username, impersonationLifetime)
+		if err != nil {
+			return "", time.Time{}, err
+		}
+		claims.Custom["sid"] = session.ID
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	signed, err := token.SignedString([]byte(api.jwtKey.Current()))
+	return signed, expire, err
+}
+
+// rejectImpersonation aborts requests made with impersonation tokens, so that
+// support staff can't take over or lock users out of the accounts they act as
+func (api *API) rejectImpersonation(c *gin.Context) {
+	if impersonator := ClaimImpersonator(c); impersonator != "" {
+		api.LogError(c, errors.New("impersonation token used on a sensitive route"),
+			"not allowed while impersonating", "impersonator", impersonator, "path", c.FullPath())(http.StatusForbidden)
+		c.Abort()
+		return
+	}
+	c.Next()
+}
+
+// rejectImpersonationRefresh prevents impersonation tokens from being
+// refreshed into tokens with the regular lifetime
+func (api *API) rejectImpersonationRefresh(c *gin.Context) {
+	// invalid tokens are left for the refresh handler to reject
+	if claims, err := api.parseRefreshClaims(c); err == nil {
+		if claims.Impersonator != "" {
+			api.LogError(c, errors.New("impersonation token refresh attempted"),
+				"impersonation tokens can't be refreshed", "impersonator", claims.Impersonator)(http.StatusForbidden)
+			c.Abort()
+			return
+		}
+	}
+	c.Next()
+}
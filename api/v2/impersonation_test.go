@@ -0,0 +1,105 @@
+package v2
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/RTradeLtd/Temporal/api/middleware"
+	"github.com/RTradeLtd/config/v2"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap/zaptest"
+	jwt "gopkg.in/dgrijalva/jwt-go.v3"
+)
+
+func TestAPI_Impersonation(t *testing.T) {
+	cfg := &config.TemporalConfig{}
+	cfg.JWT.Key = "suchsecretmuchkeyverysecurewowsuchsecret"
+	api := &API{cfg: cfg, jwtKey: middleware.NewSigningKey(cfg.JWT.Key), l: zaptest.NewLogger(t).Sugar(), service: "test"}
+	now := time.Now()
+	token, expire, err := api.signImpersonationToken("testuser", "adminuser", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !expire.Equal(now.Add(impersonationLifetime)) {
+		t.Fatal("impersonation token has the wrong expiry")
+	}
+	// the token must carry the impersonator claim
+	parsed, err := jwt.Parse(token, func(*jwt.Token) (interface{}, error) {
+		return []byte(cfg.JWT.Key), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims := parsed.Claims.(jwt.MapClaims)
+	if claims["id"] != "testuser" || claims["impersonator"] != "adminuser" {
+		t.Fatalf("unexpected claims %v", claims)
+	}
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Set("JWT_PAYLOAD", claims)
+	if ClaimImpersonator(c) != "adminuser" {
+		t.Fatal("failed to surface impersonator from context")
+	}
+	// impersonation tokens can't be refreshed, while regular ones can
+	regular := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"id": "testuser", "exp": now.Add(time.Hour).Unix(), "orig_iat": now.Unix(),
+	})
+	regularToken, err := regular.SignedString([]byte(cfg.JWT.Key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		token    string
+		wantCode int
+	}{
+		{"Impersonation-Token", token, http.StatusForbidden},
+		{"Regular-Token", regularToken, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testRecorder := httptest.NewRecorder()
+			_, engine := gin.CreateTestContext(testRecorder)
+			engine.GET("/refresh", api.rejectImpersonationRefresh, func(c *gin.Context) {
+				c.String(http.StatusOK, "refreshed")
+			})
+			req := httptest.NewRequest("GET", "/refresh", nil)
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+			engine.ServeHTTP(testRecorder, req)
+			if testRecorder.Code != tt.wantCode {
+				t.Fatalf("got status %v, want %v", testRecorder.Code, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestAPI_Impersonation_SensitiveRoutes(t *testing.T) {
+	api := newTestAPI(t)
+	token, _, err := api.signImpersonationToken("testuser", "adminuser", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		method string
+		url    string
+	}{
+		{"Key-Export", "GET", "/v2/account/key/export/mykey"},
+		{"Key-Create", "POST", "/v2/account/key/ipfs/new"},
+		{"Password-Change", "POST", "/v2/account/password/change"},
+		{"Email-Forgot", "POST", "/v2/account/email/forgot"},
+		{"Impersonate", "POST", "/v2/admin/impersonate"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testRecorder := httptest.NewRecorder()
+			req := httptest.NewRequest(tt.method, tt.url, nil)
+			req.Header.Set("Authorization", "Bearer "+token)
+			api.r.ServeHTTP(testRecorder, req)
+			if testRecorder.Code != http.StatusForbidden {
+				t.Fatalf("got status %v, want %v", testRecorder.Code, http.StatusForbidden)
+			}
+		})
+	}
+}
@@ -0,0 +1,78 @@
+package v2
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/RTradeLtd/Temporal/api/middleware"
+	"github.com/gin-gonic/gin"
+)
+
+// maxIntrospectionBatch caps the number of tokens introspected in a single request
+const maxIntrospectionBatch = 100
+
+// introspectToken allows trusted services, such as api gateways, to validate a
+// token and retrieve the account it belongs to without parsing it themselves.
+// Tokens are subject to the same checks as authenticated routes, with invalid
+// tokens reported as inactive rather than failing the request
+func (api *API) introspectToken(c *gin.Context) {
+	forms, missingField := api.extractPostForms(c, "token")
+	if missingField != "" {
+		FailWithMissingField(c, missingField)
+		return
+	}
+	Respond(c, http.StatusOK, gin.H{"response": api.introspect(forms["token"])})
+}
+
+// introspectTokens introspects every token submitted through the token field
+// in a single request, so that gateways can validate tokens in batches. The
+// results are returned in the order the tokens were submitted
+func (api *API) introspectTokens(c *gin.Context) {
+	tokens := c.PostFormArray("token")
+	if len(tokens) == 0 {
+		FailWithMissingField(c, "token")
+		return
+	}
+	if len(tokens) > maxIntrospectionBatch {
+		FailWithMessage(c, fmt.Sprintf("at most %v tokens can be introspected at once", maxIntrospectionBatch))
+		return
+	}
+	results := make([]gin.H, 0, len(tokens))
+	for _, token := range tokens {
+		results = append(results, api.introspect(token))
+	}
+	Respond(c, http.StatusOK, gin.H{"response": results})
+}
+
+// introspect returns whether token is active, along with
+// the account it belongs to and when it expires if it is
+func (api *API) introspect(token string) gin.H {
+	inactive := gin.H{"active": false}
+	claims, err := api.parseClaims(token, false)
+	if err == nil {
+		err = api.checkSession(claims)
+	}
+	if err == nil {
+		err = api.checkSignedOut(claims)
+	}
+	if err != nil {
+		return inactive
+	}
+	user, err := api.um.FindByUserName(claims.ID)
+	if err != nil || !user.EmailEnabled || !user.AccountEnabled {
+		return inactive
+	}
+	if suspension, err := middleware.FindAccountSuspension(api.um.DB, user.UserName); err != nil || suspension != nil {
+		return inactive
+	}
+	// remove sensitive fields from output
+	user.HashedPassword = "scrubbed"
+	user.EmailVerificationToken = "scrubbed"
+	return gin.H{
+		"active":       true,
+		"user":         user,
+		"impersonator": claims.Impersonator,
+		"expires_at":   time.Unix(claims.ExpiresAt, 0).UTC(),
+	}
+}
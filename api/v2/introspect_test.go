@@ -0,0 +1,174 @@
+package v2
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/RTradeLtd/Temporal/api/middleware"
+	"github.com/RTradeLtd/config/v2"
+	"github.com/RTradeLtd/database/v2/models"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap/zaptest"
+	jwt "gopkg.in/dgrijalva/jwt-go.v3"
+)
+
+func TestAPI_introspectToken(t *testing.T) {
+	cfg, err := config.LoadConfig("../../testenv/config.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := loadDatabase(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	api := &API{
+		cfg:    cfg,
+		jwtKey: middleware.NewSigningKey(cfg.JWT.Key),
+		l:      zaptest.NewLogger(t).Sugar(),
+		um:     models.NewUserManager(db),
+		opts:   Options{ServiceKey: "suchservicemuchtrusted"},
+	}
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/introspect", append(api.policy(policyService, nil), api.introspectToken)...)
+	now := time.Now()
+	sign := func(exp time.Time) string {
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+			"id":       testUser,
+			"exp":      exp.Unix(),
+			"orig_iat": now.Add(-time.Hour).Unix(),
+		}).SignedString([]byte(cfg.JWT.Key))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	tests := []struct {
+		name       string
+		serviceKey string
+		token      string
+		wantCode   int
+		wantActive bool
+	}{
+		{"Valid-Token", "suchservicemuchtrusted", sign(now.Add(time.Hour)), http.StatusOK, true},
+		{"Expired-Token", "suchservicemuchtrusted", sign(now.Add(-time.Minute)), http.StatusOK, false},
+		{"Malformed-Token", "suchservicemuchtrusted", "notatoken", http.StatusOK, false},
+		{"Untrusted-Caller", "notthekey", sign(now.Add(time.Hour)), http.StatusForbidden, false},
+		{"Missing-Service-Key", "", sign(now.Add(time.Hour)), http.StatusForbidden, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest("POST", "/introspect", strings.NewReader(url.Values{"token": {tt.token}}.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			if tt.serviceKey != "" {
+				req.Header.Set(serviceKeyHeader, tt.serviceKey)
+			}
+			r.ServeHTTP(rec, req)
+			if rec.Code != tt.wantCode {
+				t.Fatalf("got status %v, want %v", rec.Code, tt.wantCode)
+			}
+			if tt.wantCode != http.StatusOK {
+				return
+			}
+			var resp struct {
+				Response struct {
+					Active bool         `json:"active"`
+					User   *models.User `json:"user"`
+				} `json:"response"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp.Response.Active != tt.wantActive {
+				t.Fatalf("active = %v, want %v", resp.Response.Active, tt.wantActive)
+			}
+			if tt.wantActive {
+				if resp.Response.User == nil || resp.Response.User.UserName != testUser {
+					t.Fatalf("expected the token's account to be returned, got %+v", resp.Response.User)
+				}
+				if resp.Response.User.HashedPassword != "scrubbed" {
+					t.Fatal("expected the password hash to be scrubbed")
+				}
+			}
+		})
+	}
+}
+
+func TestAPI_introspectTokens(t *testing.T) {
+	cfg, err := config.LoadConfig("../../testenv/config.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := loadDatabase(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	api := &API{
+		cfg:    cfg,
+		jwtKey: middleware.NewSigningKey(cfg.JWT.Key),
+		l:      zaptest.NewLogger(t).Sugar(),
+		um:     models.NewUserManager(db),
+		opts:   Options{ServiceKey: "suchservicemuchtrusted"},
+	}
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/introspect/batch", append(api.policy(policyService, nil), api.introspectTokens)...)
+	now := time.Now()
+	sign := func(exp time.Time) string {
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+			"id":       testUser,
+			"exp":      exp.Unix(),
+			"orig_iat": now.Add(-time.Hour).Unix(),
+		}).SignedString([]byte(cfg.JWT.Key))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	send := func(tokens []string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "/introspect/batch", strings.NewReader(url.Values{"token": tokens}.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set(serviceKeyHeader, "suchservicemuchtrusted")
+		r.ServeHTTP(rec, req)
+		return rec
+	}
+	// results are returned in the order tokens were submitted
+	rec := send([]string{sign(now.Add(time.Hour)), "notatoken", sign(now.Add(-time.Minute))})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %v, want %v", rec.Code, http.StatusOK)
+	}
+	var resp struct {
+		Response []struct {
+			Active bool         `json:"active"`
+			User   *models.User `json:"user"`
+		} `json:"response"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Response) != 3 {
+		t.Fatalf("got %v results, want 3", len(resp.Response))
+	}
+	for i, wantActive := range []bool{true, false, false} {
+		if resp.Response[i].Active != wantActive {
+			t.Fatalf("token %v active = %v, want %v", i, resp.Response[i].Active, wantActive)
+		}
+	}
+	if resp.Response[0].User == nil || resp.Response[0].User.UserName != testUser {
+		t.Fatalf("expected the token's account to be returned, got %+v", resp.Response[0].User)
+	}
+	// batches are capped
+	if rec := send(make([]string, maxIntrospectionBatch+1)); rec.Code != http.StatusBadRequest {
+		t.Fatalf("got status %v for an oversized batch, want %v", rec.Code, http.StatusBadRequest)
+	}
+	if rec := send(nil); rec.Code != http.StatusBadRequest {
+		t.Fatalf("got status %v for an empty batch, want %v", rec.Code, http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,138 @@
+package v2
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/RTradeLtd/Temporal/eh"
+	"github.com/gin-gonic/gin"
+)
+
+// accountIPRange is an ip range an account allows or denies access from,
+// given as an ip or cidr range
+type accountIPRange struct {
+	UserName string `gorm:"primary_key"`
+	Range    string `gorm:"primary_key"`
+	Allow    bool
+}
+
+// accountIPRanges returns the ranges username allows and denies access from
+func (api *API) accountIPRanges(username string) (allow, deny []string, err error) {
+	var ranges []accountIPRange
+	if err := api.dbm.DB.Where("user_name = ?", username).Find(&ranges).Error; err != nil {
+		return nil, nil, err
+	}
+	allow, deny = []string{}, []string{}
+	for _, r := range ranges {
+		if r.Allow {
+			allow = append(allow, r.Range)
+		} else {
+			deny = append(deny, r.Range)
+		}
+	}
+	return allow, deny, nil
+}
+
+// ipAllowed reports whether access from ip is allowed by the given ranges.
+// Denied ranges take precedence, and an empty allow list allows every ip
+func ipAllowed(ip string, allow, deny []string) bool {
+	if ipWithin(ip, deny) {
+		return false
+	}
+	return len(allow) == 0 || ipWithin(ip, allow)
+}
+
+// enforceIPRanges aborts requests from ips the authenticated account
+// doesn't allow access from
+func (api *API) enforceIPRanges(c *gin.Context) {
+	username, err := GetAuthenticatedUserFromContext(c)
+	if err != nil {
+		api.LogError(c, err, eh.NoAPITokenError)(http.StatusBadRequest)
+		c.Abort()
+		return
+	}
+	allow, deny, err := api.accountIPRanges(username)
+	if err != nil {
+		api.LogError(c, err, "failed to find account ip ranges")(http.StatusInternalServerError)
+		c.Abort()
+		return
+	}
+	if !ipAllowed(c.ClientIP(), allow, deny) {
+		api.l.Infow("request from disallowed ip", "user", username, "ip", c.ClientIP())
+		FailNotAuthorized(c, "access from this ip address is not allowed")
+		c.Abort()
+		return
+	}
+	c.Next()
+}
+
+// getIPRanges returns the ranges the authenticated account allows and denies access from
+func (api *API) getIPRanges(c *gin.Context) {
+	username, err := GetAuthenticatedUserFromContext(c)
+	if err != nil {
+		api.LogError(c, err, eh.NoAPITokenError)(http.StatusBadRequest)
+		return
+	}
+	allow, deny, err := api.accountIPRanges(username)
+	if err != nil {
+		api.LogError(c, err, "failed to find account ip ranges")(http.StatusInternalServerError)
+		return
+	}
+	Respond(c, http.StatusOK, gin.H{"response": gin.H{"allow": allow, "deny": deny}})
+}
+
+// updateIPRanges replaces the ranges the authenticated account allows and
+// denies access from, given as repeated allow and deny fields holding ips or
+// cidr ranges. Empty lists allow access from anywhere. Updates which would
+// deny access from the ip making them are rejected, so that accounts can't
+// lock themselves out
+func (api *API) updateIPRanges(c *gin.Context) {
+	username, err := GetAuthenticatedUserFromContext(c)
+	if err != nil {
+		api.LogError(c, err, eh.NoAPITokenError)(http.StatusBadRequest)
+		return
+	}
+	allow, deny := c.PostFormArray("allow"), c.PostFormArray("deny")
+	ranges := make([]accountIPRange, 0, len(allow)+len(deny))
+	for _, r := range allow {
+		ranges = append(ranges, accountIPRange{UserName: username, Range: r, Allow: true})
+	}
+	for _, r := range deny {
+		ranges = append(ranges, accountIPRange{UserName: username, Range: r})
+	}
+	for _, r := range ranges {
+		if _, _, err := net.ParseCIDR(r.Range); err != nil && net.ParseIP(r.Range) == nil {
+			FailWithBadRequest(c, fmt.Sprintf("%s is not an ip address or cidr range", r.Range))
+			return
+		}
+	}
+	if !ipAllowed(c.ClientIP(), allow, deny) {
+		FailWithBadRequest(c, "ip ranges must allow access from the ip address updating them")
+		return
+	}
+	if err := api.replaceIPRanges(username, ranges); err != nil {
+		api.LogError(c, err, "failed to update account ip ranges")(http.StatusInternalServerError)
+		return
+	}
+	api.l.Infow("account ip ranges updated", "user", username, "allow", allow, "deny", deny)
+	Respond(c, http.StatusOK, gin.H{"response": gin.H{"allow": allow, "deny": deny}})
+}
+
+// replaceIPRanges replaces the ranges username allows and denies access from
+func (api *API) replaceIPRanges(username string, ranges []accountIPRange) error {
+	tx := api.dbm.DB.Begin()
+	if tx.Error != nil {
+		return tx.Error
+	}
+	defer tx.Rollback()
+	if err := tx.Where("user_name = ?", username).Delete(&accountIPRange{}).Error; err != nil {
+		return err
+	}
+	for i := range ranges {
+		if err := tx.Save(&ranges[i]).Error; err != nil {
+			return err
+		}
+	}
+	return tx.Commit().Error
+}
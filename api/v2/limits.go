@@ -0,0 +1,107 @@
+package v2
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ulule/limiter/v3"
+	"github.com/ulule/limiter/v3/drivers/store/memory"
+)
+
+// keyedLimiter is used to rate limit actions by an arbitrary
+// key, such as an email address or an ip address
+type keyedLimiter struct {
+	l      *limiter.Limiter
+	prefix string
+}
+
+// newKeyedLimiter returns a limiter allowing limit actions per key within period,
+// with its state held in memory
+func newKeyedLimiter(limit int64, period time.Duration) *keyedLimiter {
+	return newStoreLimiter(memory.NewStore(), "", limit, period)
+}
+
+// newStoreLimiter returns a limiter allowing limit actions per key within period,
+// with its state held in store. Keys are prefixed with name so that limiters
+// can share a store without their counts colliding
+func newStoreLimiter(store limiter.Store, name string, limit int64, period time.Duration) *keyedLimiter {
+	kl := &keyedLimiter{
+		l: limiter.New(store, limiter.Rate{Limit: limit, Period: period}),
+	}
+	if name != "" {
+		kl.prefix = name + ":"
+	}
+	return kl
+}
+
+// newLimits returns our rate limiters, with their state held in store
+func newLimits(store limiter.Store) limits {
+	return limits{
+		// one recovery email per address per hour
+		recoveryEmail: newStoreLimiter(store, "recovery-email", 1, time.Hour),
+		recoveryIP:    newStoreLimiter(store, "recovery-ip", 10, time.Hour),
+		// password change notifications per user per hour
+		passwordChanged: newStoreLimiter(store, "password-changed", 3, time.Hour),
+		// numeric verification code attempts per user per hour
+		verificationAttempts: newStoreLimiter(store, "verification-attempts", 5, time.Hour),
+		// each magic link may be used once within its lifetime
+		magicLinks: newStoreLimiter(store, "magic-link", 1, magicLinkLifetime),
+	}
+}
+
+// rateStore returns the store holding the state of our rate limiters. Unless
+// Options.RateLimitStore is set, state is held in memory and limits are only
+// enforced per api instance
+func (api *API) rateStore() limiter.Store {
+	if api.opts.RateLimitStore != nil {
+		return api.opts.RateLimitStore
+	}
+	return memory.NewStore()
+}
+
+// allow records an action for key, returning false if it exceeds the limit.
+// If the limit can't be checked, the action is not allowed
+func (kl *keyedLimiter) allow(key string) bool {
+	lctx, err := kl.l.Get(context.Background(), kl.prefix+key)
+	if err != nil {
+		return false
+	}
+	return !lctx.Reached
+}
+
+// available reports whether another action could be recorded for key
+// without exceeding the limit, without recording it. If the limit can't be
+// checked, the action is not allowed
+func (kl *keyedLimiter) available(key string) bool {
+	lctx, err := kl.l.Peek(context.Background(), kl.prefix+key)
+	if err != nil {
+		return false
+	}
+	return lctx.Remaining > 0
+}
+
+// count returns the number of actions recorded for key within the current
+// period, without recording another
+func (kl *keyedLimiter) count(key string) int64 {
+	lctx, err := kl.l.Peek(context.Background(), kl.prefix+key)
+	if err != nil {
+		return 0
+	}
+	return lctx.Limit - lctx.Remaining
+}
+
+// recoveryAllowed is used to limit how often account recovery emails of
+// the given type are sent, both to a single address and from a single ip
+func (api *API) recoveryAllowed(c *gin.Context, recoveryType, email string) bool {
+	if !api.limits.recoveryEmail.allow(recoveryType + ":" + strings.ToLower(email)) {
+		api.l.Infow("account recovery email rate limited", "type", recoveryType)
+		return false
+	}
+	if !api.limits.recoveryIP.allow(recoveryType + ":" + c.ClientIP()) {
+		api.l.Infow("account recovery ip rate limited", "type", recoveryType, "ip", c.ClientIP())
+		return false
+	}
+	return true
+}
@@ -0,0 +1,86 @@
+package v2
+
+import (
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ulule/limiter/v3/drivers/store/memory"
+	"go.uber.org/zap/zaptest"
+)
+
+func TestAPI_recoveryAllowed(t *testing.T) {
+	api := &API{
+		l: zaptest.NewLogger(t).Sugar(),
+		limits: limits{
+			recoveryEmail: newKeyedLimiter(1, time.Hour),
+			recoveryIP:    newKeyedLimiter(2, time.Hour),
+		},
+	}
+	testCtx, _ := gin.CreateTestContext(httptest.NewRecorder())
+	testCtx.Request = httptest.NewRequest("POST", "/v2/forgot/password", nil)
+	// only the first request for an address within the window is allowed
+	if !api.recoveryAllowed(testCtx, "password", "test@example.org") {
+		t.Fatal("first recovery request should be allowed")
+	}
+	if api.recoveryAllowed(testCtx, "password", "TEST@example.org") {
+		t.Fatal("repeated recovery request should be limited")
+	}
+	// different recovery types are limited separately
+	if !api.recoveryAllowed(testCtx, "username", "test@example.org") {
+		t.Fatal("username recovery should be allowed")
+	}
+	// the ip limit applies across addresses
+	if !api.recoveryAllowed(testCtx, "password", "test2@example.org") {
+		t.Fatal("recovery request for new address should be allowed")
+	}
+	if api.recoveryAllowed(testCtx, "password", "test3@example.org") {
+		t.Fatal("recovery request should be ip limited")
+	}
+}
+
+func Test_keyedLimiter_Store(t *testing.T) {
+	store := memory.NewStore()
+	t.Run("Concurrent", func(t *testing.T) {
+		kl := newStoreLimiter(store, "concurrent", 10, time.Hour)
+		var allowed int64
+		var wg sync.WaitGroup
+		for i := 0; i < 50; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if kl.allow("testuser") {
+					atomic.AddInt64(&allowed, 1)
+				}
+			}()
+		}
+		wg.Wait()
+		if allowed != 10 {
+			t.Fatalf("expected 10 allowed actions, got %v", allowed)
+		}
+	})
+	t.Run("Expiry", func(t *testing.T) {
+		kl := newStoreLimiter(store, "expiry", 1, time.Second)
+		if !kl.allow("testuser") {
+			t.Fatal("first action should be allowed")
+		}
+		if kl.allow("testuser") {
+			t.Fatal("second action should be limited")
+		}
+		time.Sleep(time.Second + time.Millisecond*100)
+		if !kl.allow("testuser") {
+			t.Fatal("action should be allowed once the period expires")
+		}
+	})
+	t.Run("Shared-Store", func(t *testing.T) {
+		// limiters sharing a store must not share counts for the same key
+		first := newStoreLimiter(store, "first", 1, time.Hour)
+		second := newStoreLimiter(store, "second", 1, time.Hour)
+		if !first.allow("testuser") || !second.allow("testuser") {
+			t.Fatal("limiters sharing a store should be independent")
+		}
+	})
+}
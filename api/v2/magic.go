@@ -0,0 +1,163 @@
+package v2
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/RTradeLtd/Temporal/api/middleware"
+	"github.com/RTradeLtd/Temporal/eh"
+	ginjwt "github.com/appleboy/gin-jwt"
+	"github.com/gin-gonic/gin"
+	jwt "gopkg.in/dgrijalva/jwt-go.v3"
+)
+
+// magicLinkLifetime is how long magic links are valid for. They can only
+// be used once, but are kept short lived as they grant a full session
+const magicLinkLifetime = time.Minute * 15
+
+// requestMagicLink emails a single use link allowing the user to sign in without
+// their password. The same response is returned regardless of outcome so that
+// this can't be used to enumerate emails
+func (api *API) requestMagicLink(c *gin.Context) {
+	forms, missingField := api.extractPostForms(c, "email_address")
+	if missingField != "" {
+		FailWithMissingField(c, missingField)
+		return
+	}
+	forms["email_address"] = api.normalizeEmail(forms["email_address"])
+	defer Respond(c, http.StatusOK, gin.H{"response": "if an account with this email exists, a sign in link has been sent"})
+	// limit how often links can be sent to an address
+	if !api.recoveryAllowed(c, "magic-link", forms["email_address"]) {
+		return
+	}
+	user, err := api.um.FindByEmail(forms["email_address"])
+	if err != nil {
+		api.LogError(c, err, eh.UserSearchError)
+		return
+	}
+	// only verified accounts can sign in
+	if !user.EmailEnabled {
+		api.l.Infow("magic link requested for account without email enabled", "user", user.UserName)
+		return
+	}
+	token, err := api.generateMagicToken(user.UserName, time.Now())
+	if err != nil {
+		api.LogError(c, err, "failed to generate magic link")
+		return
+	}
+	link := api.opts.MagicLinkURL + "?" + url.Values{"username": {user.UserName}, "token": {token}}.Encode()
+	es := magicLinkEmail(link, magicLinkLifetime)
+	es.UserNames = []string{user.UserName}
+	es.Emails = []string{user.EmailAddress}
+	if err = api.publishEmail(es, emailMagicLink); err != nil {
+		api.LogError(c, err, eh.QueuePublishError, "user", user.UserName)
+	}
+}
+
+// loginWithMagicLink returns a handler which exchanges a magic link token for an
+// api token, equivalent to one issued by signing in through mw. Links are
+// consumed on use, so they are redeemed with a POST rather than by following
+// them, as mail scanners which prefetch links would otherwise use them up
+func (api *API) loginWithMagicLink(mw *ginjwt.GinJWTMiddleware) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		forms, missingField := api.extractPostForms(c, "username", "token")
+		if missingField != "" {
+			FailWithMissingField(c, missingField)
+			return
+		}
+		jti, err := api.checkMagicToken(forms["token"], forms["username"], time.Now())
+		if err != nil {
+			FailWithMessage(c, "invalid or expired sign in link", http.StatusUnauthorized)
+			return
+		}
+		user, err := api.um.FindByUserName(forms["username"])
+		if err != nil || !user.EmailEnabled || !user.AccountEnabled {
+			FailWithMessage(c, "invalid or expired sign in link", http.StatusUnauthorized)
+			return
+		}
+		if suspension, err := middleware.FindAccountSuspension(api.um.DB, user.UserName); err != nil {
+			api.LogError(c, err, "failed to find account suspension")(http.StatusInternalServerError)
+			return
+		} else if suspension != nil {
+			FailNotAuthorized(c, "account suspended")
+			return
+		}
+		// links are single use, which is enforced across api instances
+		// when a shared rate limit store is configured
+		if !api.limits.magicLinks.allow(jti) {
+			FailWithMessage(c, "sign in link has already been used", http.StatusUnauthorized)
+			return
+		}
+		token, expire, err := signSessionToken(mw, user.UserName, time.Now())
+		if err != nil {
+			api.LogError(c, err, "failed to generate token")(http.StatusInternalServerError)
+			return
+		}
+		api.l.Infow("successful magic link login", "username", user.UserName)
+		c.JSON(http.StatusOK, gin.H{
+			"code":   http.StatusOK,
+			"token":  token,
+			"expire": expire.Format(time.RFC3339),
+		})
+	}
+}
+
+// generateMagicToken returns a single use token allowing username to sign in,
+// consisting of a random id used to enforce single use, its expiry, and a hmac
+func (api *API) generateMagicToken(username string, now time.Time) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	jti := hex.EncodeToString(b)
+	exp := strconv.FormatInt(now.Add(magicLinkLifetime).Unix(), 36)
+	return jti + "." + exp + "." + api.verificationMAC(username, "magic-link:"+jti, exp), nil
+}
+
+// checkMagicToken ensures token was generated for username and hasn't
+// expired, returning its id. It does not check whether it was already used
+func (api *API) checkMagicToken(token, username string, now time.Time) (string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", errors.New("malformed magic link token")
+	}
+	jti, exp := parts[0], parts[1]
+	mac := api.verificationMAC(username, "magic-link:"+jti, exp)
+	if !hmac.Equal([]byte(mac), []byte(parts[2])) {
+		return "", errors.New("failed to validate magic link token")
+	}
+	expire, err := strconv.ParseInt(exp, 36, 64)
+	if err != nil {
+		return "", err
+	}
+	if now.Unix() > expire {
+		return "", errors.New("magic link token is expired")
+	}
+	return jti, nil
+}
+
+// signSessionToken returns a token for username equivalent to one issued by
+// signing in through mw, including the claims of its payload function
+func signSessionToken(mw *ginjwt.GinJWTMiddleware, username string, now time.Time) (string, time.Time, error) {
+	expire := now.Add(mw.Timeout)
+	claims := TemporalClaims{
+		ID:           username,
+		ExpiresAt:    expire.Unix(),
+		OrigIssuedAt: now.Unix(),
+		Custom:       make(map[string]interface{}),
+	}
+	if mw.PayloadFunc != nil {
+		for name, value := range mw.PayloadFunc(username) {
+			claims.Custom[name] = value
+		}
+	}
+	signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(mw.Key)
+	return signed, expire, err
+}
@@ -0,0 +1,126 @@
+package v2
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/RTradeLtd/Temporal/api/middleware"
+	"github.com/RTradeLtd/config/v2"
+	"github.com/RTradeLtd/database/v2/models"
+	"github.com/gin-gonic/gin"
+	"github.com/ulule/limiter/v3/drivers/store/memory"
+	"go.uber.org/zap/zaptest"
+)
+
+func TestAPI_checkMagicToken(t *testing.T) {
+	cfg := &config.TemporalConfig{}
+	cfg.JWT.Key = "suchsecretmuchkeyverysecurewowsuchsecret"
+	api := &API{cfg: cfg, jwtKey: middleware.NewSigningKey(cfg.JWT.Key)}
+	now := time.Now()
+	token, err := api.generateMagicToken("testuser", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := api.generateMagicToken("testuser", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Split(token, ".")
+	tests := []struct {
+		name     string
+		token    string
+		username string
+		now      time.Time
+		wantErr  bool
+	}{
+		{"Valid", token, "testuser", now, false},
+		{"Expired", token, "testuser", now.Add(magicLinkLifetime + time.Minute), true},
+		{"Wrong-User", token, "otheruser", now, true},
+		{"Tampered-ID", strings.Split(other, ".")[0] + "." + parts[1] + "." + parts[2], "testuser", now, true},
+		{"Tampered-Expiry", parts[0] + ".zzzzzzzz." + parts[2], "testuser", now, true},
+		{"Malformed", "notatoken", "testuser", now, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			jti, err := api.checkMagicToken(tt.token, tt.username, tt.now)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("checkMagicToken() err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && jti != parts[0] {
+				t.Fatalf("checkMagicToken() jti = %v, want %v", jti, parts[0])
+			}
+		})
+	}
+}
+
+func TestAPI_loginWithMagicLink(t *testing.T) {
+	cfg, err := config.LoadConfig("../../testenv/config.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := loadDatabase(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger := zaptest.NewLogger(t).Sugar()
+	api := &API{
+		cfg:    cfg,
+		jwtKey: middleware.NewSigningKey(cfg.JWT.Key),
+		l:      logger,
+		um:     models.NewUserManager(db),
+		limits: newLimits(memory.NewStore()),
+	}
+	mw, err := middleware.JwtConfigGenerate(cfg.JWT.Key, cfg.JWT.Realm, db, logger, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/magic/login", api.loginWithMagicLink(mw))
+	r.GET("/account", mw.MiddlewareFunc(), func(c *gin.Context) {
+		c.String(http.StatusOK, ClaimUser(c))
+	})
+	login := func(token string) (int, string) {
+		rec := httptest.NewRecorder()
+		form := url.Values{"username": {testUser}, "token": {token}}
+		req := httptest.NewRequest("POST", "/magic/login", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.ServeHTTP(rec, req)
+		var resp loginResponse
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		return rec.Code, resp.Token
+	}
+	token, err := api.generateMagicToken(testUser, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the link is exchanged for a regular api token
+	code, apiToken := login(token)
+	if code != http.StatusOK {
+		t.Fatalf("got status %v, want %v", code, http.StatusOK)
+	}
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/account", nil)
+	req.Header.Set("Authorization", "Bearer "+apiToken)
+	r.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || rec.Body.String() != testUser {
+		t.Fatalf("issued token was rejected with status %v", rec.Code)
+	}
+	// links can only be used once
+	if code, _ := login(token); code != http.StatusUnauthorized {
+		t.Fatalf("got status %v reusing a link, want %v", code, http.StatusUnauthorized)
+	}
+	// links expire
+	expired, err := api.generateMagicToken(testUser, time.Now().Add(-magicLinkLifetime-time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code, _ := login(expired); code != http.StatusUnauthorized {
+		t.Fatalf("got status %v using an expired link, want %v", code, http.StatusUnauthorized)
+	}
+}
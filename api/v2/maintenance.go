@@ -0,0 +1,105 @@
+package v2
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/RTradeLtd/database/v2/models"
+	"github.com/jinzhu/gorm"
+)
+
+// AccountMaintainer performs housekeeping of user accounts
+type AccountMaintainer struct {
+	db *gorm.DB
+}
+
+// NewAccountMaintainer returns an AccountMaintainer backed by the given database
+func NewAccountMaintainer(db *gorm.DB) *AccountMaintainer {
+	return &AccountMaintainer{db: db}
+}
+
+// PurgeUnverifiedAccounts permanently deletes accounts which were registered
+// more than olderThan ago and never verified their email address, freeing their
+// username and email address. Every record kept under their username, being
+// their usage, ipns records, payments, zones and dns records, is deleted with
+// them, and they are removed from the hosted networks and organizations they
+// belong to, all within a single transaction. Only accounts still on the
+// unverified tier, without credits, uploads or organizations of their own, are
+// purged so that nothing of value is lost. olderThan must cover
+// the lifetime of verification links, so that accounts can't be purged while
+// they can still be verified. When dryRun is set nothing is deleted. The
+// usernames of the accounts which were, or would be, purged are returned
+func (am *AccountMaintainer) PurgeUnverifiedAccounts(olderThan time.Duration, dryRun bool) ([]string, error) {
+	if olderThan < verificationLifetime {
+		return nil, fmt.Errorf("accounts can only be purged once they are older than %s", verificationLifetime)
+	}
+	cutoff := time.Now().Add(-olderThan)
+	if dryRun {
+		return staleUnverifiedAccounts(am.db, cutoff)
+	}
+	tx := am.db.Begin()
+	// lock the selected accounts so that they can't be verified while being purged
+	names, err := staleUnverifiedAccounts(tx.Set("gorm:query_option", "FOR UPDATE"), cutoff)
+	if err != nil || len(names) == 0 {
+		tx.Rollback()
+		return names, err
+	}
+	if err := purgeAccounts(tx, names); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	return names, tx.Commit().Error
+}
+
+// usernameRecords are the models keyed by username which are deleted along with
+// an account. Accounts with uploads are never purged, so they aren't included
+var usernameRecords = []interface{}{
+	&models.IPNS{},
+	&models.Payments{},
+	&models.Record{},
+	&models.Zone{},
+	&models.Usage{},
+	&models.User{},
+}
+
+// purgeAccounts deletes the accounts of names and every record kept under
+// their username, and removes them from the hosted networks and organizations
+// they were added to, which store their members as arrays of usernames
+func purgeAccounts(tx *gorm.DB, names []string) error {
+	for _, record := range usernameRecords {
+		if err := tx.Unscoped().Where("user_name IN (?)", names).Delete(record).Error; err != nil {
+			return err
+		}
+	}
+	for _, name := range names {
+		if err := tx.Model(&models.HostedNetwork{}).
+			Where("? = ANY(users) OR ? = ANY(owners)", name, name).
+			UpdateColumns(map[string]interface{}{
+				"users":  gorm.Expr("array_remove(users, ?)", name),
+				"owners": gorm.Expr("array_remove(owners, ?)", name),
+			}).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&models.Organization{}).
+			Where("? = ANY(registered_users)", name).
+			UpdateColumn("registered_users", gorm.Expr("array_remove(registered_users, ?)", name)).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// staleUnverifiedAccounts returns the usernames of unverified accounts registered
+// before cutoff which are still on the unverified tier and have no credits,
+// uploads or organizations of their own
+func staleUnverifiedAccounts(db *gorm.DB, cutoff time.Time) ([]string, error) {
+	names := []string{}
+	err := db.Model(&models.User{}).
+		Where("email_enabled = ? AND credits = 0 AND created_at < ?", false, cutoff).
+		Where("user_name IN (?)", db.Model(&models.Usage{}).Select("user_name").Where("tier = ?", models.Unverified).QueryExpr()).
+		Where("user_name NOT IN (?)", db.Unscoped().Model(&models.Upload{}).Select("user_name").QueryExpr()).
+		Where("user_name NOT IN (?)", db.Unscoped().Model(&models.EncryptedUpload{}).Select("user_name").QueryExpr()).
+		Where("user_name NOT IN (?)", db.Unscoped().Model(&models.Organization{}).Select("account_owner").QueryExpr()).
+		Pluck("user_name", &names).Error
+	return names, err
+}
@@ -0,0 +1,165 @@
+package v2
+
+import (
+	"testing"
+	"time"
+
+	"github.com/RTradeLtd/Temporal/utils"
+	"github.com/RTradeLtd/config/v2"
+	"github.com/RTradeLtd/database/v2/models"
+)
+
+func TestAccountMaintainer_PurgeUnverifiedAccounts(t *testing.T) {
+	cfg, err := config.LoadConfig("../../testenv/config.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := loadDatabase(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	um := models.NewUserManager(db)
+	usage := models.NewUsageManager(db)
+	randUtils := utils.GenerateRandomUtils()
+	// place an account on the unverified tier, optionally verified, created age ago
+	backdate := func(username string, verified bool, age time.Duration) {
+		if err := db.Model(&models.User{}).Where("user_name = ?", username).
+			UpdateColumns(map[string]interface{}{
+				"email_enabled": verified,
+				"created_at":    time.Now().Add(-age),
+			}).Error; err != nil {
+			t.Fatal(err)
+		}
+		if err := usage.UpdateTier(username, models.Unverified); err != nil {
+			t.Fatal(err)
+		}
+	}
+	register := func(verified bool, age time.Duration) string {
+		username := randUtils.GenerateString(32, utils.LetterBytes)
+		if _, err := um.NewUserAccount(username, "password123", username+"@example.org"); err != nil {
+			t.Fatal(err)
+		}
+		backdate(username, verified, age)
+		return username
+	}
+	stale := register(false, time.Hour*24*60)
+	recent := register(false, time.Hour)
+	verified := register(true, time.Hour*24*60)
+	// stale accounts holding anything of value are kept
+	credited := register(false, time.Hour*24*60)
+	if _, err := um.AddCredits(credited, 10); err != nil {
+		t.Fatal(err)
+	}
+	upgraded := register(false, time.Hour*24*60)
+	if err := usage.UpdateTier(upgraded, models.Free); err != nil {
+		t.Fatal(err)
+	}
+	uploader := register(false, time.Hour*24*60)
+	if _, err := models.NewUploadManager(db).NewUpload(
+		randUtils.GenerateString(46, utils.LetterBytes), "file", models.UploadOptions{
+			Username:         uploader,
+			NetworkName:      "public",
+			HoldTimeInMonths: 1,
+		},
+	); err != nil {
+		t.Fatal(err)
+	}
+	owner := register(false, time.Hour*24*60)
+	orgs := models.NewOrgManager(db)
+	orgName := randUtils.GenerateString(32, utils.LetterBytes)
+	if _, err := orgs.NewOrganization(orgName, owner); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Unscoped().Where("name = ?", orgName).Delete(&models.Organization{})
+	// stale accounts are removed from organizations and hosted networks
+	member := randUtils.GenerateString(32, utils.LetterBytes)
+	if _, err := orgs.RegisterOrgUser(orgName, member, "password123", member+"@example.org"); err != nil {
+		t.Fatal(err)
+	}
+	backdate(member, false, time.Hour*24*60)
+	nm := models.NewHostedNetworkManager(db)
+	networkName := randUtils.GenerateString(32, utils.LetterBytes)
+	if _, err := nm.CreateHostedPrivateNetwork(
+		networkName, "swarmkey", nil,
+		models.NetworkAccessOptions{Owner: "testuser", Users: []string{"testuser", stale}},
+	); err != nil {
+		t.Fatal(err)
+	}
+	defer nm.Delete(networkName)
+	// and every record kept under their username is deleted with them
+	if _, err := models.NewPaymentManager(db).NewPayment(
+		1, stale+"-1", stale+"-1", 10, 10, "ethereum", "eth", stale,
+	); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Create(&models.Zone{UserName: stale, Name: stale + ".example.org"}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Create(&models.Record{UserName: stale, Name: "www." + stale + ".example.org"}).Error; err != nil {
+		t.Fatal(err)
+	}
+	contains := func(names []string, name string) bool {
+		for _, n := range names {
+			if n == name {
+				return true
+			}
+		}
+		return false
+	}
+	am := NewAccountMaintainer(db)
+	if _, err := am.PurgeUnverifiedAccounts(time.Hour, true); err == nil {
+		t.Fatal("expected accounts which can still be verified to be protected")
+	}
+	for _, dryRun := range []bool{true, false} {
+		purged, err := am.PurgeUnverifiedAccounts(time.Hour*24*30, dryRun)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, username := range []string{stale, member} {
+			if !contains(purged, username) {
+				t.Fatalf("dry run %v didn't select the stale account %s", dryRun, username)
+			}
+		}
+		for _, username := range []string{recent, verified, credited, upgraded, uploader, owner} {
+			if contains(purged, username) {
+				t.Fatalf("dry run %v selected %s", dryRun, username)
+			}
+		}
+		_, err = um.FindByUserName(stale)
+		if exists := err == nil; exists != dryRun {
+			t.Fatalf("dry run %v left stale account existing: %v", dryRun, exists)
+		}
+	}
+	for _, record := range usernameRecords {
+		var count int
+		if err := db.Unscoped().Model(record).Where("user_name = ?", stale).Count(&count).Error; err != nil {
+			t.Fatal(err)
+		}
+		if count != 0 {
+			t.Fatalf("%T records of purged account were kept", record)
+		}
+	}
+	network, err := nm.GetNetworkByName(networkName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if contains(network.Users, stale) || !contains(network.Users, "testuser") {
+		t.Fatalf("purged account wasn't removed from network users %v", network.Users)
+	}
+	org, err := orgs.FindByName(orgName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if contains(org.RegisteredUsers, member) {
+		t.Fatalf("purged account wasn't removed from organization users %v", org.RegisteredUsers)
+	}
+	for _, username := range []string{recent, verified, credited, upgraded, uploader, owner} {
+		if _, err := um.FindByUserName(username); err != nil {
+			t.Fatalf("account %s was purged", username)
+		}
+	}
+	// purged usernames and email addresses can be registered again
+	if _, err := um.NewUserAccount(stale, "password123", stale+"@example.org"); err != nil {
+		t.Fatal(err)
+	}
+}
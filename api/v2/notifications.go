@@ -0,0 +1,126 @@
+package v2
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/RTradeLtd/Temporal/eh"
+	"github.com/RTradeLtd/Temporal/queue"
+	"github.com/gin-gonic/gin"
+)
+
+// optionalEmails are the email types users can opt out of. Every other
+// type, such as password resets, is critical and always sent
+var optionalEmails = map[string]bool{
+	emailUpgrade: true,
+}
+
+// emailOptOut records that a user opted out of an optional email type
+type emailOptOut struct {
+	UserName   string `gorm:"primary_key"`
+	EmailType  string `gorm:"primary_key"`
+	OptedOutAt time.Time
+}
+
+// withoutOptedOut returns es without the recipients who opted out of kind
+func (api *API) withoutOptedOut(es queue.EmailSend, kind string) (queue.EmailSend, error) {
+	if !optionalEmails[kind] {
+		return es, nil
+	}
+	var optedOut []string
+	if err := api.dbm.DB.Model(&emailOptOut{}).
+		Where("email_type = ? AND user_name IN (?)", kind, es.UserNames).
+		Pluck("user_name", &optedOut).Error; err != nil {
+		return es, err
+	}
+	if len(optedOut) == 0 {
+		return es, nil
+	}
+	skip := make(map[string]bool, len(optedOut))
+	for _, username := range optedOut {
+		skip[username] = true
+	}
+	usernames, emails := es.UserNames, es.Emails
+	es.UserNames, es.Emails = nil, nil
+	for i, username := range usernames {
+		if !skip[username] && i < len(emails) {
+			es.UserNames = append(es.UserNames, username)
+			es.Emails = append(es.Emails, emails[i])
+		}
+	}
+	return es, nil
+}
+
+// notificationPreferences returns whether username receives each optional email type
+func (api *API) notificationPreferences(username string) (map[string]bool, error) {
+	var optedOut []string
+	if err := api.dbm.DB.Model(&emailOptOut{}).Where("user_name = ?", username).
+		Pluck("email_type", &optedOut).Error; err != nil {
+		return nil, err
+	}
+	preferences := make(map[string]bool, len(optionalEmails))
+	for kind := range optionalEmails {
+		preferences[kind] = true
+	}
+	for _, kind := range optedOut {
+		preferences[kind] = false
+	}
+	return preferences, nil
+}
+
+// getNotificationPreferences returns whether the authenticated user
+// receives each optional email type
+func (api *API) getNotificationPreferences(c *gin.Context) {
+	username, err := GetAuthenticatedUserFromContext(c)
+	if err != nil {
+		api.LogError(c, err, eh.NoAPITokenError)(http.StatusBadRequest)
+		return
+	}
+	preferences, err := api.notificationPreferences(username)
+	if err != nil {
+		api.LogError(c, err, "failed to find notification preferences")(http.StatusInternalServerError)
+		return
+	}
+	Respond(c, http.StatusOK, gin.H{"response": preferences})
+}
+
+// updateNotificationPreferences allows the authenticated user to opt in
+// to, or out of, an optional email type
+func (api *API) updateNotificationPreferences(c *gin.Context) {
+	username, err := GetAuthenticatedUserFromContext(c)
+	if err != nil {
+		api.LogError(c, err, eh.NoAPITokenError)(http.StatusBadRequest)
+		return
+	}
+	forms, missingField := api.extractPostForms(c, "type", "enabled")
+	if missingField != "" {
+		FailWithMissingField(c, missingField)
+		return
+	}
+	if !optionalEmails[forms["type"]] {
+		FailWithBadRequest(c, fmt.Sprintf("%s emails can't be opted out of", forms["type"]))
+		return
+	}
+	enabled, err := strconv.ParseBool(forms["enabled"])
+	if err != nil {
+		FailWithBadRequest(c, "enabled must be true or false")
+		return
+	}
+	if enabled {
+		err = api.dbm.DB.Where("user_name = ? AND email_type = ?", username, forms["type"]).
+			Delete(&emailOptOut{}).Error
+	} else {
+		err = api.dbm.DB.Save(&emailOptOut{
+			UserName:   username,
+			EmailType:  forms["type"],
+			OptedOutAt: time.Now(),
+		}).Error
+	}
+	if err != nil {
+		api.LogError(c, err, "failed to update notification preferences")(http.StatusInternalServerError)
+		return
+	}
+	api.getNotificationPreferences(c)
+}
@@ -0,0 +1,131 @@
+package v2
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/RTradeLtd/Temporal/eh"
+	"github.com/RTradeLtd/database/v2/models"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// passwordChangeRoutes are the routes accounts which must change their
+// password may use, keyed by their full path
+var passwordChangeRoutes = map[string]bool{
+	"/v2/account/password/change": true,
+	"/v2/auth/logout":             true,
+}
+
+// passwordChangeRequirement records that an account must change its password
+// before doing anything else, such as accounts provisioned by an administrator
+// with a temporary password
+type passwordChangeRequirement struct {
+	UserName   string `gorm:"primary_key"`
+	RequiredAt time.Time
+}
+
+// requirePasswordChange requires username to change their password
+func (api *API) requirePasswordChange(username string) error {
+	return api.dbm.DB.Save(&passwordChangeRequirement{UserName: username, RequiredAt: time.Now()}).Error
+}
+
+// clearPasswordChange removes the requirement for username to change their password
+func (api *API) clearPasswordChange(username string) error {
+	return api.dbm.DB.Where("user_name = ?", username).Delete(&passwordChangeRequirement{}).Error
+}
+
+// mustChangePassword reports whether username is required to change their password
+func (api *API) mustChangePassword(username string) (bool, error) {
+	var count int
+	err := api.dbm.DB.Model(&passwordChangeRequirement{}).Where("user_name = ?", username).Count(&count).Error
+	return count > 0, err
+}
+
+// enforcePasswordChange aborts requests from accounts which must change their
+// password, other than those to passwordChangeRoutes
+func (api *API) enforcePasswordChange(c *gin.Context) {
+	if passwordChangeRoutes[c.FullPath()] {
+		c.Next()
+		return
+	}
+	username, err := GetAuthenticatedUserFromContext(c)
+	if err != nil {
+		api.LogError(c, err, eh.NoAPITokenError)(http.StatusBadRequest)
+		c.Abort()
+		return
+	}
+	required, err := api.mustChangePassword(username)
+	if err != nil {
+		api.LogError(c, err, "failed to check password change requirement")(http.StatusInternalServerError)
+		c.Abort()
+		return
+	}
+	if required {
+		FailNotAuthorized(c, "password must be changed")
+		c.Abort()
+		return
+	}
+	c.Next()
+}
+
+// errPasswordRecentlyUsed is returned when changing to a password
+// within the account's password history
+var errPasswordRecentlyUsed = errors.New("password recently used")
+
+// passwordHistory records a previous password of an account as its salted
+// hash, so that recently used passwords can be rejected
+type passwordHistory struct {
+	ID             uint   `gorm:"primary_key"`
+	UserName       string `gorm:"index"`
+	HashedPassword string
+	RetiredAt      time.Time
+}
+
+// passwordRecentlyUsed reports whether password is one of the last
+// Options.PasswordHistory passwords of user, including their current one
+func (api *API) passwordRecentlyUsed(user *models.User, password string) (bool, error) {
+	if api.opts.PasswordHistory <= 0 {
+		return false, nil
+	}
+	hashes := []string{user.HashedPassword}
+	if api.opts.PasswordHistory > 1 {
+		var previous []string
+		if err := api.dbm.DB.Model(&passwordHistory{}).Where("user_name = ?", user.UserName).
+			Order("retired_at desc").Limit(api.opts.PasswordHistory-1).
+			Pluck("hashed_password", &previous).Error; err != nil {
+			return false, err
+		}
+		hashes = append(hashes, previous...)
+	}
+	for _, hash := range hashes {
+		if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// retirePassword records hash as the previous password of username, forgetting
+// any passwords older than those checked by passwordRecentlyUsed
+func (api *API) retirePassword(username, hash string) error {
+	if api.opts.PasswordHistory <= 1 {
+		return nil
+	}
+	if err := api.dbm.DB.Create(&passwordHistory{
+		UserName:       username,
+		HashedPassword: hash,
+		RetiredAt:      time.Now(),
+	}).Error; err != nil {
+		return err
+	}
+	var kept []uint
+	if err := api.dbm.DB.Model(&passwordHistory{}).Where("user_name = ?", username).
+		Order("retired_at desc").Limit(api.opts.PasswordHistory-1).
+		Pluck("id", &kept).Error; err != nil {
+		return err
+	}
+	return api.dbm.DB.Where("user_name = ? AND id NOT IN (?)", username, kept).
+		Delete(&passwordHistory{}).Error
+}
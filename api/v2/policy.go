@@ -0,0 +1,107 @@
+package v2
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"time"
+
+	"github.com/RTradeLtd/Temporal/api/middleware"
+	"github.com/RTradeLtd/Temporal/eh"
+	"github.com/gin-gonic/gin"
+)
+
+// routePolicy is the access requirement of a group of routes, which is
+// enforced by middleware rather than checked within each handler
+type routePolicy int
+
+const (
+	// policyPublic routes can be accessed without a token
+	policyPublic routePolicy = iota
+	// policyAuthenticated routes require a valid token. Tokens are only
+	// issued to accounts which have verified their email address, so
+	// these routes are also restricted to verified accounts
+	policyAuthenticated
+	// policyAdmin routes require a valid token belonging to an administrator
+	policyAdmin
+	// policyService routes are restricted to trusted services holding
+	// Options.ServiceKey, rather than requiring a token
+	policyService
+	// policySensitive routes manage a user's credentials, email address or
+	// keys. They require a valid token which isn't an impersonation token
+	policySensitive
+)
+
+// serviceKeyHeader is the header trusted services submit Options.ServiceKey in
+const serviceKeyHeader = "X-Service-Key"
+
+// policy returns the middleware enforcing p, where authware
+// is the middleware used to validate tokens
+func (api *API) policy(p routePolicy, authware []gin.HandlerFunc) []gin.HandlerFunc {
+	switch p {
+	case policyPublic:
+		return nil
+	case policyAdmin:
+		return append(append([]gin.HandlerFunc{}, authware...), api.requireAdmin)
+	case policyService:
+		return []gin.HandlerFunc{api.requireService}
+	case policySensitive:
+		return append(append([]gin.HandlerFunc{}, authware...), api.rejectImpersonation)
+	default:
+		return authware
+	}
+}
+
+// validateIssuedAt rejects tokens with an implausible orig_iat claim, which
+// the jwt middleware doesn't check, as they indicate clock issues or tampering
+func (api *API) validateIssuedAt(c *gin.Context) {
+	if err := claimsFromContext(c).validIssuedAt(time.Now()); err != nil {
+		FailWithMessage(c, err.Error(), http.StatusUnauthorized)
+		c.Abort()
+		return
+	}
+	c.Next()
+}
+
+// requireAdmin aborts requests which weren't authenticated by an administrator
+func (api *API) requireAdmin(c *gin.Context) {
+	username, err := GetAuthenticatedUserFromContext(c)
+	if err != nil {
+		api.LogError(c, err, eh.NoAPITokenError)(http.StatusBadRequest)
+		c.Abort()
+		return
+	}
+	if err := api.validateAdminRequest(username); err != nil {
+		FailNotAuthorized(c, eh.UnAuthorizedAdminAccess)
+		c.Abort()
+		return
+	}
+	c.Next()
+}
+
+// routeAudiences restricts the routes within Options.RouteAudiences
+// to tokens issued to one of their permitted audiences
+func (api *API) routeAudiences() gin.HandlerFunc {
+	restricted := make(map[string]gin.HandlerFunc, len(api.opts.RouteAudiences))
+	for path, audiences := range api.opts.RouteAudiences {
+		restricted[path] = middleware.RequireAudience(audiences...)
+	}
+	return func(c *gin.Context) {
+		if require, ok := restricted[c.FullPath()]; ok {
+			require(c)
+			return
+		}
+		c.Next()
+	}
+}
+
+// requireService aborts requests which weren't made by a trusted service
+func (api *API) requireService(c *gin.Context) {
+	key := c.GetHeader(serviceKeyHeader)
+	if api.opts.ServiceKey == "" || key == "" ||
+		subtle.ConstantTimeCompare([]byte(key), []byte(api.opts.ServiceKey)) != 1 {
+		FailNotAuthorized(c, "route is restricted to trusted services")
+		c.Abort()
+		return
+	}
+	c.Next()
+}
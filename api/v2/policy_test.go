@@ -0,0 +1,71 @@
+package v2
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/RTradeLtd/config/v2"
+	"github.com/RTradeLtd/database/v2/models"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap/zaptest"
+	jwt "gopkg.in/dgrijalva/jwt-go.v3"
+)
+
+func TestAPI_policy(t *testing.T) {
+	cfg, err := config.LoadConfig("../../testenv/config.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := loadDatabase(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	api := &API{l: zaptest.NewLogger(t).Sugar(), um: models.NewUserManager(db)}
+	// stands in for the jwt middleware, treating the authorization header as the username
+	authware := []gin.HandlerFunc{func(c *gin.Context) {
+		user := c.GetHeader("Authorization")
+		if user == "" {
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+		c.Set("JWT_PAYLOAD", jwt.MapClaims{"id": user})
+	}}
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	for path, p := range map[string]routePolicy{
+		"/public":        policyPublic,
+		"/authenticated": policyAuthenticated,
+		"/admin":         policyAdmin,
+	} {
+		r.GET(path, append(api.policy(p, authware), func(c *gin.Context) {
+			c.String(http.StatusOK, "ok")
+		})...)
+	}
+	tests := []struct {
+		name     string
+		path     string
+		user     string
+		wantCode int
+	}{
+		{"Public-Anonymous", "/public", "", http.StatusOK},
+		{"Authenticated-Anonymous", "/authenticated", "", http.StatusUnauthorized},
+		{"Authenticated-User", "/authenticated", "notareallaccount", http.StatusOK},
+		{"Admin-Anonymous", "/admin", "", http.StatusUnauthorized},
+		{"Admin-Non-Admin", "/admin", "notareallaccount", http.StatusForbidden},
+		{"Admin-Admin", "/admin", testUser, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest("GET", tt.path, nil)
+			if tt.user != "" {
+				req.Header.Set("Authorization", tt.user)
+			}
+			r.ServeHTTP(rec, req)
+			if rec.Code != tt.wantCode {
+				t.Fatalf("%s returned status %v, want %v", tt.path, rec.Code, tt.wantCode)
+			}
+		})
+	}
+}
@@ -0,0 +1,50 @@
+package v2
+
+import (
+	"github.com/RTradeLtd/Temporal/api/middleware"
+	"github.com/RTradeLtd/database/v2/models"
+	"github.com/gin-gonic/gin"
+)
+
+// userProfile is the profile of an account, which may be
+// returned along with the token when signing in
+type userProfile struct {
+	UserName      string        `json:"username"`
+	EmailAddress  string        `json:"email_address"`
+	EmailVerified bool          `json:"email_verified"`
+	Credits       float64       `json:"credits"`
+	Usage         *models.Usage `json:"usage"`
+	// IPFSKeys is capped by Options.MaxKeysPerResponse like the key list route
+	IPFSKeys gin.H `json:"ipfs_keys,omitempty"`
+}
+
+// toUser returns the profile of user, leaving out their ipfs keys when omitIPFS is set
+func (api *API) toUser(user *models.User, usage *models.Usage, omitIPFS bool) userProfile {
+	profile := userProfile{
+		UserName:      user.UserName,
+		EmailAddress:  user.EmailAddress,
+		EmailVerified: user.EmailEnabled,
+		Credits:       user.Credits,
+		Usage:         usage,
+	}
+	if !omitIPFS {
+		profile.IPFSKeys = keyPage(map[string][]string{
+			"key_names": user.IPFSKeyNames,
+			"key_ids":   user.IPFSKeyIDs,
+		}, 0, api.opts.MaxKeysPerResponse)
+	}
+	return profile
+}
+
+// loginProfile returns the profile of the user signing in
+func (api *API) loginProfile(request middleware.ProfileRequest) (interface{}, error) {
+	user, err := api.um.FindByUserName(api.loginUserName(request.Username))
+	if err != nil {
+		return nil, err
+	}
+	usage, err := api.usage.FindByUserName(user.UserName)
+	if err != nil {
+		return nil, err
+	}
+	return api.toUser(user, usage, request.OmitIPFS), nil
+}
@@ -0,0 +1,74 @@
+package v2
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	jwt "gopkg.in/dgrijalva/jwt-go.v3"
+)
+
+// parseRefreshClaims parses the claims of the token being refreshed. Claims
+// validation is skipped, as whether or not an expired token may be refreshed
+// is decided by the refresh middleware
+func (api *API) parseRefreshClaims(c *gin.Context) (*TemporalClaims, error) {
+	return api.parseClaims(GetAuthToken(c), true)
+}
+
+// parseClaims parses the claims of a token signed by us, optionally
+// skipping validation of the claims
+func (api *API) parseClaims(signed string, skipValidation bool) (*TemporalClaims, error) {
+	parser := &jwt.Parser{SkipClaimsValidation: skipValidation}
+	token, err := parser.ParseWithClaims(signed, &TemporalClaims{}, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return []byte(api.jwtKey.Current()), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	claims, ok := token.Claims.(*TemporalClaims)
+	if !ok {
+		return nil, errors.New("failed to parse claims")
+	}
+	return claims, nil
+}
+
+// refreshGrace only allows expired tokens to be refreshed if they expired
+// within Options.RefreshGracePeriod, so that clients which wake up shortly
+// after their token expires don't need to sign in again. Every other route
+// strictly enforces token expiry
+func (api *API) refreshGrace(c *gin.Context) {
+	claims, err := api.parseRefreshClaims(c)
+	if err != nil {
+		FailWithMessage(c, "invalid token", http.StatusUnauthorized)
+		c.Abort()
+		return
+	}
+	if claims.ExpiresAt < time.Now().Add(-api.opts.RefreshGracePeriod).Unix() {
+		FailWithMessage(c, "token is expired", http.StatusUnauthorized)
+		c.Abort()
+		return
+	}
+	// refreshed tokens retain orig_iat, so a bad one must not be laundered
+	if err := claims.validIssuedAt(time.Now()); err != nil {
+		FailWithMessage(c, err.Error(), http.StatusUnauthorized)
+		c.Abort()
+		return
+	}
+	// revoked sessions can't be extended either
+	if err := api.checkSession(claims); err != nil {
+		FailWithMessage(c, "session is no longer valid, please sign in again", http.StatusUnauthorized)
+		c.Abort()
+		return
+	}
+	if err := api.checkSignedOut(claims); err != nil {
+		FailWithMessage(c, "session is no longer valid, please sign in again", http.StatusUnauthorized)
+		c.Abort()
+		return
+	}
+	c.Next()
+}
@@ -0,0 +1,122 @@
+package v2
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/RTradeLtd/Temporal/api/middleware"
+	"github.com/RTradeLtd/config/v2"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap/zaptest"
+	jwt "gopkg.in/dgrijalva/jwt-go.v3"
+)
+
+func TestAPI_refreshGrace(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cfg := &config.TemporalConfig{}
+	cfg.JWT.Key = "suchsecretmuchkeyverysecurewowsuchsecret"
+	api := &API{cfg: cfg, jwtKey: middleware.NewSigningKey(cfg.JWT.Key), opts: Options{RefreshGracePeriod: time.Minute}}
+	ginjwt, err := middleware.JwtConfigGenerate(cfg.JWT.Key, "temporal", nil, zaptest.NewLogger(t).Sugar(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ginjwt.MaxRefresh = ginjwt.Timeout + api.opts.RefreshGracePeriod
+	r := gin.New()
+	r.GET("/refresh", api.refreshGrace, ginjwt.RefreshHandler)
+	r.GET("/account", ginjwt.MiddlewareFunc(), func(c *gin.Context) {
+		c.String(http.StatusOK, "ok")
+	})
+	sign := func(expiredFor time.Duration) string {
+		exp := time.Now().Add(-expiredFor)
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+			"id":       "testuser",
+			"exp":      exp.Unix(),
+			"orig_iat": exp.Add(-ginjwt.Timeout).Unix(),
+		}).SignedString([]byte(cfg.JWT.Key))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	tests := []struct {
+		name        string
+		token       string
+		wantRefresh int
+	}{
+		{"Just-Expired", sign(time.Second * 30), http.StatusOK},
+		{"Expired-Beyond-Grace", sign(time.Minute * 2), http.StatusUnauthorized},
+		{"Invalid", "notatoken", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for path, want := range map[string]int{
+				"/refresh": tt.wantRefresh,
+				// every other route strictly enforces expiry
+				"/account": http.StatusUnauthorized,
+			} {
+				rec := httptest.NewRecorder()
+				req := httptest.NewRequest("GET", path, nil)
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+				r.ServeHTTP(rec, req)
+				if rec.Code != want {
+					t.Fatalf("%s returned status %v, want %v", path, rec.Code, want)
+				}
+			}
+		})
+	}
+}
+
+func TestAPI_validateIssuedAt(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cfg := &config.TemporalConfig{}
+	cfg.JWT.Key = "suchsecretmuchkeyverysecurewowsuchsecret"
+	api := &API{cfg: cfg, jwtKey: middleware.NewSigningKey(cfg.JWT.Key)}
+	ginjwt, err := middleware.JwtConfigGenerate(cfg.JWT.Key, "temporal", nil, zaptest.NewLogger(t).Sugar(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// avoid hitting the database
+	ginjwt.Authorizator = func(userID string, c *gin.Context) bool { return true }
+	r := gin.New()
+	r.GET("/refresh", api.refreshGrace, ginjwt.RefreshHandler)
+	r.GET("/account", ginjwt.MiddlewareFunc(), api.validateIssuedAt, func(c *gin.Context) {
+		c.String(http.StatusOK, "ok")
+	})
+	now := time.Now()
+	sign := func(exp, origIat time.Time) string {
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+			"id":       "testuser",
+			"exp":      exp.Unix(),
+			"orig_iat": origIat.Unix(),
+		}).SignedString([]byte(cfg.JWT.Key))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	tests := []struct {
+		name     string
+		token    string
+		wantCode int
+	}{
+		{"Valid", sign(now.Add(time.Hour), now), http.StatusOK},
+		{"Skewed-Within-Leeway", sign(now.Add(time.Hour), now.Add(time.Second*30)), http.StatusOK},
+		{"Future-Dated", sign(now.Add(time.Hour*2), now.Add(time.Hour)), http.StatusUnauthorized},
+		{"Issued-After-Expiry", sign(now.Add(time.Second*10), now.Add(time.Second*30)), http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, path := range []string{"/account", "/refresh"} {
+				rec := httptest.NewRecorder()
+				req := httptest.NewRequest("GET", path, nil)
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+				r.ServeHTTP(rec, req)
+				if rec.Code != tt.wantCode {
+					t.Fatalf("%s returned status %v, want %v", path, rec.Code, tt.wantCode)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,288 @@
+package v2
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/RTradeLtd/Temporal/api/middleware"
+	"github.com/RTradeLtd/database/v2/models"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// defaultDomainRegistrationWindow is used when Options.DomainRegistrationWindow is unset
+	defaultDomainRegistrationWindow = time.Hour * 24
+	// defaultIPRegistrationWindow is used when Options.IPRegistrationWindow is unset
+	defaultIPRegistrationWindow = time.Hour * 24
+	// defaultMaxUsernameLength is used when Options.MaxUsernameLength is unset
+	defaultMaxUsernameLength = 64
+	// defaultMaxEmailLength is used when Options.MaxEmailLength is unset,
+	// and is the maximum length of an address allowed by RFC 5321
+	defaultMaxEmailLength = 254
+	// defaultMaxPasswordLength is used when Options.MaxPasswordLength is unset.
+	// bcrypt ignores anything past 72 bytes so longer passwords only waste cpu
+	defaultMaxPasswordLength = 72
+)
+
+// usernameCharset restricts usernames to alphanumerics and limited punctuation
+var usernameCharset = regexp.MustCompile(`^[a-zA-Z0-9._-]+$`)
+
+// defaultReservedUsernames is used when Options.ReservedUsernames is unset
+var defaultReservedUsernames = []string{"admin", "administrator", "root", "support", "temporal"}
+
+// validateRegistration is used to check the size and format of account
+// registration fields before we do anything expensive with them. The name
+// of the form field which failed validation is returned with the error
+func (api *API) validateRegistration(username, email, password string) (string, error) {
+	if max := limitOrDefault(api.opts.MaxUsernameLength, defaultMaxUsernameLength); len(username) > max {
+		return "username", fmt.Errorf("username must not be longer than %v characters", max)
+	}
+	if !usernameCharset.MatchString(username) {
+		return "username", fmt.Errorf("username may only contain letters, numbers, periods, underscores and dashes")
+	}
+	if api.reservedUsername(username) {
+		return "username", errors.New("username is reserved")
+	}
+	if max := limitOrDefault(api.opts.MaxEmailLength, defaultMaxEmailLength); len(email) > max {
+		return "email_address", fmt.Errorf("email address must not be longer than %v characters", max)
+	}
+	if err := api.validatePasswordLength(password); err != nil {
+		return "password", err
+	}
+	return "", nil
+}
+
+// validateRegistrationForm rejects registrations with missing or malformed
+// fields before they reach the handler, and before the password is hashed.
+// Sizes, formats and reserved names are checked by validateRegistration
+func (api *API) validateRegistrationForm() gin.HandlerFunc {
+	fields := middleware.ValidateForm(
+		middleware.FieldRule{Name: "username", Required: true},
+		middleware.FieldRule{Name: "email_address", Required: true, Email: true},
+		middleware.FieldRule{Name: "password", Required: true},
+	)
+	return func(c *gin.Context) {
+		username, email, password := c.PostForm("username"), c.PostForm("email_address"), c.PostForm("password")
+		// missing fields are reported individually by fields
+		if username != "" && email != "" && password != "" {
+			if field, err := api.validateRegistration(
+				username, strings.TrimSpace(email), html.UnescapeString(password),
+			); err != nil {
+				c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+					"code":     http.StatusBadRequest,
+					"response": "invalid request fields",
+					"fields":   map[string]string{field: err.Error()},
+				})
+				return
+			}
+		}
+		fields(c)
+	}
+}
+
+// validateLoginForm rejects sign in requests with missing or oversized
+// credentials before they reach the jwt middleware. Users may sign in
+// with their email address, so usernames are limited to its length
+func (api *API) validateLoginForm() gin.HandlerFunc {
+	return middleware.ValidateJSON(
+		middleware.FieldRule{
+			Name: "username", Required: true,
+			MaxLength: limitOrDefault(api.opts.MaxEmailLength, defaultMaxEmailLength),
+		},
+		middleware.FieldRule{Name: "password", Required: true},
+	)
+}
+
+// validatePasswordLength is used to cap password sizes before hashing
+func (api *API) validatePasswordLength(password string) error {
+	if max := limitOrDefault(api.opts.MaxPasswordLength, defaultMaxPasswordLength); len(password) > max {
+		return fmt.Errorf("password must not be longer than %v characters", max)
+	}
+	return nil
+}
+
+// reservedUsername reports whether username matches Options.ReservedUsernames.
+// Invalid regular expressions never match
+func (api *API) reservedUsername(username string) bool {
+	reserved := api.opts.ReservedUsernames
+	if reserved == nil {
+		reserved = defaultReservedUsernames
+	}
+	username = strings.ToLower(username)
+	for _, entry := range reserved {
+		switch {
+		case len(entry) > 1 && strings.HasPrefix(entry, "/") && strings.HasSuffix(entry, "/"):
+			pattern, err := regexp.Compile("(?i)" + entry[1:len(entry)-1])
+			if err == nil && pattern.MatchString(username) {
+				return true
+			}
+		case strings.HasSuffix(entry, "*"):
+			if strings.HasPrefix(username, strings.ToLower(strings.TrimSuffix(entry, "*"))) {
+				return true
+			}
+		case strings.ToLower(entry) == username:
+			return true
+		}
+	}
+	return false
+}
+
+func limitOrDefault(limit, def int) int {
+	if limit <= 0 {
+		return def
+	}
+	return limit
+}
+
+// normalizeEmail applies Options.NormalizeEmails to email, so that addresses
+// entered with stray whitespace or a different case match the stored address
+func (api *API) normalizeEmail(email string) string {
+	if !api.opts.NormalizeEmails {
+		return email
+	}
+	email = strings.TrimSpace(email)
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return email
+	}
+	local, domain := email[:at], strings.ToLower(email[at+1:])
+	if api.opts.LowercaseEmailLocalPart {
+		local = strings.ToLower(local)
+	}
+	return local + "@" + domain
+}
+
+// likeEscaper escapes the wildcards of a LIKE pattern
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// domainRegistrationsExceeded reports whether the domain of email has reached
+// Options.MaxAccountsPerDomain registrations within the registration window.
+// Accounts are counted from the database so that the limit is shared by
+// every api instance
+func (api *API) domainRegistrationsExceeded(email string, now time.Time) (bool, error) {
+	at := strings.LastIndex(email, "@")
+	if api.opts.MaxAccountsPerDomain <= 0 || at < 0 {
+		return false, nil
+	}
+	domain := strings.ToLower(email[at+1:])
+	for _, allowed := range api.opts.DomainAllowlist {
+		if strings.ToLower(allowed) == domain {
+			return false, nil
+		}
+	}
+	window := api.opts.DomainRegistrationWindow
+	if window <= 0 {
+		window = defaultDomainRegistrationWindow
+	}
+	var count int
+	if err := api.dbm.DB.Model(&models.User{}).
+		Where("lower(email_address) LIKE ? AND created_at > ?", "%@"+likeEscaper.Replace(domain), now.Add(-window)).
+		Count(&count).Error; err != nil {
+		return false, err
+	}
+	return count >= api.opts.MaxAccountsPerDomain, nil
+}
+
+// registrationIPLimiter returns the limiter enforcing Options.MaxRegistrationsPerIP,
+// or nil if registrations aren't limited by ip
+func (api *API) registrationIPLimiter() *keyedLimiter {
+	if api.opts.MaxRegistrationsPerIP <= 0 {
+		return nil
+	}
+	window := api.opts.IPRegistrationWindow
+	if window <= 0 {
+		window = defaultIPRegistrationWindow
+	}
+	return newStoreLimiter(api.rateStore(), "registration-ip", int64(api.opts.MaxRegistrationsPerIP), window)
+}
+
+// ipRegistrationExempt reports whether ip is within Options.IPRegistrationAllowlist
+func (api *API) ipRegistrationExempt(ip string) bool {
+	return ipWithin(ip, api.opts.IPRegistrationAllowlist)
+}
+
+// ipWithin reports whether ip is within any of ranges, given as ips or cidr ranges
+func ipWithin(ip string, ranges []string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, allowed := range ranges {
+		if _, ipNet, err := net.ParseCIDR(allowed); err == nil {
+			if ipNet.Contains(parsed) {
+				return true
+			}
+		} else if allowedIP := net.ParseIP(allowed); allowedIP != nil && allowedIP.Equal(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// ipRegistrations returns the number of accounts registered from ip within the window
+func (api *API) ipRegistrations(ip string) int64 {
+	if api.limits.registrationIP == nil || api.ipRegistrationExempt(ip) {
+		return 0
+	}
+	return api.limits.registrationIP.count(ip)
+}
+
+// ipRegistrationAllowed reports whether another registration from ip is
+// within Options.MaxRegistrationsPerIP. It doesn't record the registration,
+// which is left to recordIPRegistration once the account has been created
+func (api *API) ipRegistrationAllowed(ip string) bool {
+	if api.limits.registrationIP == nil || api.ipRegistrationExempt(ip) {
+		return true
+	}
+	return api.limits.registrationIP.available(ip)
+}
+
+// recordIPRegistration counts an account registered from ip towards
+// Options.MaxRegistrationsPerIP, so that failed registrations don't
+func (api *API) recordIPRegistration(ip string) {
+	if api.limits.registrationIP == nil || api.ipRegistrationExempt(ip) {
+		return
+	}
+	api.limits.registrationIP.allow(ip)
+}
+
+// loginUserName resolves the id a user signed in with, which may be their email
+// address or a differently cased username, to their username the same way the
+// Authenticator does
+func (api *API) loginUserName(userID string) string {
+	if api.opts.NormalizeEmails && strings.ContainsRune(userID, '@') {
+		userID = api.normalizeEmail(userID)
+	}
+	if api.opts.FoldUsernames {
+		if folded := api.foldUserName(userID); folded != "" {
+			userID = folded
+		}
+	}
+	return middleware.ResolveUserName(api.dbm.DB, userID)
+}
+
+// caseFoldedDuplicate reports whether username only differs by case from a
+// registered username, when Options.FoldUsernames is set. Usernames are stored
+// as registered to preserve their display case, so this is checked before
+// accounts are created rather than enforced by the database
+func (api *API) caseFoldedDuplicate(username string) bool {
+	return api.opts.FoldUsernames && api.foldUserName(username) != ""
+}
+
+// foldUserName returns the registered username matching username when
+// compared case-insensitively, or an empty string if there is none
+func (api *API) foldUserName(username string) string {
+	var names []string
+	if err := api.dbm.DB.Model(&models.User{}).
+		Where("lower(user_name) = lower(?)", username).
+		Pluck("user_name", &names).Error; err != nil || len(names) == 0 {
+		return ""
+	}
+	return names[0]
+}
@@ -0,0 +1,161 @@
+package v2
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/RTradeLtd/Temporal/eh"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap/zaptest"
+	jwt "gopkg.in/dgrijalva/jwt-go.v3"
+)
+
+func TestAPI_validateRegistration(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     Options
+		username string
+		email    string
+		password string
+		wantErr  bool
+	}{
+		{"Valid", Options{}, "some_user-1.0", "user@example.org", "password123", false},
+		{"Username-Too-Long", Options{}, strings.Repeat("a", 65), "user@example.org", "password123", true},
+		{"Username-Configured-Limit", Options{MaxUsernameLength: 4}, "abcde", "user@example.org", "password123", true},
+		{"Username-Invalid-Characters", Options{}, "user name!", "user@example.org", "password123", true},
+		{"Username-Empty", Options{}, "", "user@example.org", "password123", true},
+		{"Email-Too-Long", Options{}, "user", strings.Repeat("a", 250) + "@example.org", "password123", true},
+		{"Password-Too-Long", Options{}, "user", "user@example.org", strings.Repeat("a", 73), true},
+		{"Password-Configured-Limit", Options{MaxPasswordLength: 100}, "user", "user@example.org", strings.Repeat("a", 73), false},
+		{"Username-Reserved", Options{}, "Admin", "user@example.org", "password123", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := &API{opts: tt.opts}
+			if _, err := api.validateRegistration(tt.username, tt.email, tt.password); (err != nil) != tt.wantErr {
+				t.Fatalf("validateRegistration() err = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAPI_reservedUsername(t *testing.T) {
+	reserved := []string{"support", "staff-*", "/^admin[0-9]*$/", "/[/"}
+	tests := []struct {
+		name     string
+		reserved []string
+		username string
+		want     bool
+	}{
+		{"Exact", reserved, "support", true},
+		{"Exact-Case-Insensitive", reserved, "SUPPORT", true},
+		{"Prefix", reserved, "staff-alice", true},
+		{"Pattern", reserved, "admin42", true},
+		{"Pattern-Case-Insensitive", reserved, "ADMIN1", true},
+		{"Allowed", reserved, "supporter", false},
+		{"Allowed-Pattern-Mismatch", reserved, "admin42x", false},
+		{"Default-List", nil, "root", true},
+		{"Default-List-Allowed", nil, "testuser", false},
+		{"Disabled", []string{}, "root", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := &API{opts: Options{ReservedUsernames: tt.reserved}}
+			if got := api.reservedUsername(tt.username); got != tt.want {
+				t.Fatalf("reservedUsername(%q) = %v, want %v", tt.username, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAPI_registerOrgUser_Reserved(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	api := &API{l: zaptest.NewLogger(t).Sugar()}
+	r := gin.New()
+	// stand in for the jwt middleware
+	r.Use(func(c *gin.Context) {
+		c.Set("JWT_PAYLOAD", jwt.MapClaims{"id": "testuser"})
+	})
+	r.POST("/org/register/user", api.validateRegistrationForm(), api.registerOrgUser)
+	form := url.Values{
+		"username":          {"admin"},
+		"password":          {"password123"},
+		"email_address":     {"admin@example.org"},
+		"organization_name": {"testorg"},
+	}
+	req := httptest.NewRequest("POST", "/org/register/user", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("got status %v, want %v", rec.Code, http.StatusBadRequest)
+	}
+	var resp struct {
+		Fields map[string]string `json:"fields"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Fields["username"] != "username is reserved" {
+		t.Fatalf("got fields %v, want a reserved username error", resp.Fields)
+	}
+}
+
+func TestAPI_normalizeEmail(t *testing.T) {
+	tests := []struct {
+		name  string
+		opts  Options
+		email string
+		want  string
+	}{
+		{"Disabled", Options{}, " Alice@Example.COM ", " Alice@Example.COM "},
+		{"Domain-Only", Options{NormalizeEmails: true}, " Alice@Example.COM ", "Alice@example.com"},
+		{"Whole-Address", Options{NormalizeEmails: true, LowercaseEmailLocalPart: true}, " Alice@Example.COM ", "alice@example.com"},
+		{"Local-Part-Requires-Normalization", Options{LowercaseEmailLocalPart: true}, "Alice@Example.COM", "Alice@Example.COM"},
+		{"Not-An-Address", Options{NormalizeEmails: true, LowercaseEmailLocalPart: true}, " NotAnAddress ", "NotAnAddress"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := &API{opts: tt.opts}
+			if got := api.normalizeEmail(tt.email); got != tt.want {
+				t.Fatalf("normalizeEmail() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAPI_handleUserCreate_ConstraintViolation(t *testing.T) {
+	api := &API{l: zaptest.NewLogger(t).Sugar()}
+	tests := []struct {
+		name        string
+		err         error
+		wantMessage string
+	}{
+		{"UserName", errors.New(`pq: duplicate key value violates unique constraint "users_user_name_key"`), eh.DuplicateUserNameError},
+		{"Email", errors.New(`pq: duplicate key value violates unique constraint "users_email_address_key"`), eh.DuplicateEmailError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(rec)
+			c.Request = httptest.NewRequest("POST", "/v2/auth/register", nil)
+			// a concurrent registration won the race, so the database rejects the insert
+			api.handleUserCreate(c, map[string]string{"username": "testuser", "email_address": "test@example.org"}, tt.err)
+			if rec.Code != http.StatusBadRequest {
+				t.Fatalf("got status %v, want %v", rec.Code, http.StatusBadRequest)
+			}
+			var resp apiResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp.Response != tt.wantMessage {
+				t.Fatalf("got response %q, want %q", resp.Response, tt.wantMessage)
+			}
+		})
+	}
+}
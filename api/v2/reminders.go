@@ -0,0 +1,84 @@
+package v2
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/RTradeLtd/database/v2/models"
+)
+
+// verificationReminder records that an account was reminded to verify
+// its email address, so that it's only reminded once
+type verificationReminder struct {
+	UserName string `gorm:"primary_key"`
+	SentAt   time.Time
+}
+
+// SendVerificationReminders re-sends the verification email of accounts which
+// registered more than olderThan ago, but no more than notAfter ago, and still
+// haven't verified their email address. Each account is only reminded once, so
+// this can be run regularly by an external scheduler. The usernames of the
+// accounts which were reminded are returned, along with an error if any of the
+// reminders failed, which are retried by the next run
+func (api *API) SendVerificationReminders(olderThan, notAfter time.Duration) ([]string, error) {
+	if notAfter <= olderThan {
+		return nil, errors.New("notAfter must be longer than olderThan")
+	}
+	now := time.Now()
+	var users []models.User
+	if err := api.dbm.DB.
+		Where("email_enabled = ? AND created_at < ? AND created_at > ?", false, now.Add(-olderThan), now.Add(-notAfter)).
+		Where("user_name NOT IN (?)", api.dbm.DB.Model(&verificationReminder{}).Select("user_name").QueryExpr()).
+		Find(&users).Error; err != nil {
+		return nil, err
+	}
+	reminded := []string{}
+	failed := 0
+	for i := range users {
+		sent, err := api.sendVerificationReminder(&users[i], now)
+		if err != nil {
+			api.l.Errorw("failed to send verification reminder", "user", users[i].UserName, "error", err.Error())
+			failed++
+			continue
+		}
+		if sent {
+			reminded = append(reminded, users[i].UserName)
+		}
+	}
+	if failed > 0 {
+		return reminded, fmt.Errorf("failed to send %v verification reminders", failed)
+	}
+	return reminded, nil
+}
+
+// sendVerificationReminder reminds user to verify their email address,
+// reporting false if they were already reminded. The reminder is recorded
+// before it's sent so that concurrent runs can't both remind the user, and
+// is removed again if it couldn't be sent so that it's retried
+func (api *API) sendVerificationReminder(user *models.User, now time.Time) (bool, error) {
+	if err := api.dbm.DB.Create(&verificationReminder{UserName: user.UserName, SentAt: now}).Error; err != nil {
+		if api.dbm.DB.Where("user_name = ?", user.UserName).First(&verificationReminder{}).Error == nil {
+			return false, nil
+		}
+		return false, err
+	}
+	forget := func() {
+		api.dbm.DB.Where("user_name = ?", user.UserName).Delete(&verificationReminder{})
+	}
+	es, err := api.verificationEmail(user, "")
+	if err == nil {
+		es.UserNames = []string{user.UserName}
+		es.Emails = []string{user.EmailAddress}
+		err = api.publishEmailThen(es, emailVerificationReminder, func(err error) {
+			if err != nil {
+				forget()
+			}
+		})
+	}
+	if err != nil {
+		forget()
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,77 @@
+package v2
+
+import (
+	"testing"
+	"time"
+
+	"github.com/RTradeLtd/Temporal/utils"
+	"github.com/RTradeLtd/database/v2/models"
+)
+
+func Test_API_SendVerificationReminders(t *testing.T) {
+	api := newTestAPI(t)
+	randUtils := utils.GenerateRandomUtils()
+	// register an account, optionally verified, created age ago
+	register := func(verified bool, age time.Duration) string {
+		username := randUtils.GenerateString(32, utils.LetterBytes)
+		registerTestUser(t, api, username)
+		if err := api.dbm.DB.Model(&models.User{}).Where("user_name = ?", username).
+			UpdateColumns(map[string]interface{}{
+				"email_enabled": verified,
+				"created_at":    time.Now().Add(-age),
+			}).Error; err != nil {
+			t.Fatal(err)
+		}
+		return username
+	}
+	due := register(false, time.Hour*30)
+	recent := register(false, time.Hour)
+	expired := register(false, time.Hour*24*7)
+	verified := register(true, time.Hour*30)
+	contains := func(names []string, name string) bool {
+		for _, n := range names {
+			if n == name {
+				return true
+			}
+		}
+		return false
+	}
+	if _, err := api.SendVerificationReminders(time.Hour*48, time.Hour*24); err == nil {
+		t.Fatal("expected an empty window to be rejected")
+	}
+	reminded, err := api.SendVerificationReminders(time.Hour*24, time.Hour*48)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !contains(reminded, due) {
+		t.Fatalf("expected %s to be reminded, got %v", due, reminded)
+	}
+	for _, name := range []string{recent, expired, verified} {
+		if contains(reminded, name) {
+			t.Fatalf("%s should not have been reminded", name)
+		}
+	}
+	var record verificationReminder
+	if err := api.dbm.DB.Where("user_name = ?", due).First(&record).Error; err != nil {
+		t.Fatal(err)
+	}
+	if record.SentAt.IsZero() {
+		t.Fatal("expected reminder time to be recorded")
+	}
+	api.emails.wait()
+	sent := api.emails.report()["sent"].(map[string]int64)[emailVerificationReminder]
+	if sent != int64(len(reminded)) {
+		t.Fatalf("got %v reminder emails, want %v", sent, len(reminded))
+	}
+	// accounts are only reminded once
+	reminded, err = api.SendVerificationReminders(time.Hour*24, time.Hour*48)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if contains(reminded, due) {
+		t.Fatal("account was reminded twice")
+	}
+	if again, _ := api.sendVerificationReminder(&models.User{UserName: due}, time.Now()); again {
+		t.Fatal("account was reminded twice")
+	}
+}
@@ -7,11 +7,13 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/RTradeLtd/Temporal/eh"
 	"github.com/RTradeLtd/Temporal/queue"
 	"github.com/RTradeLtd/database/v2/models"
 	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
 )
 
 // getUserFromToken is used to get the username of the associated token
@@ -33,12 +35,51 @@ func (api *API) verifyEmailAddress(c *gin.Context) {
 	user := c.Param("user")
 	// get the token
 	token := c.Param("token")
-	if err := api.verifyEmailJWTToken(token, user); err != nil {
-		api.LogError(c, err, err.Error())(http.StatusBadRequest)
+	// the actual failure reason is only logged, every failure returns the same
+	// response to prevent usernames from being enumerated via this route
+	if err := api.verifyVerificationToken(token, user); err != nil {
+		// links followed more than once, such as by email link scanners, are
+		// reported as a success. This is only done for genuine links issued to
+		// the user, so that the response can't be used to probe accounts
+		if usr, findErr := api.um.FindByUserName(user); findErr == nil && usr.EmailEnabled &&
+			api.verificationTokenIssuedTo(token, usr) {
+			api.respondVerified(c, "email already verified")
+			return
+		}
+		if api.opts.VerificationFailureURL != "" {
+			api.l.Errorw(eh.InvalidVerificationLinkError, "user", user, "error", err.Error())
+			c.Redirect(http.StatusFound, api.opts.VerificationFailureURL)
+			return
+		}
+		api.LogError(c, err, eh.InvalidVerificationLinkError, "user", user)(http.StatusBadRequest)
 		return
 	}
-	// log and return
-	Respond(c, http.StatusOK, gin.H{"response": "email verified"})
+	api.respondVerified(c, "email verified")
+}
+
+// respondVerified reports a successful verification, redirecting
+// to Options.VerificationSuccessURL when it is configured
+func (api *API) respondVerified(c *gin.Context, message string) {
+	if api.opts.VerificationSuccessURL != "" {
+		c.Redirect(http.StatusFound, api.opts.VerificationSuccessURL)
+		return
+	}
+	Respond(c, http.StatusOK, gin.H{"response": message})
+}
+
+// getEmailVerificationStatus is used to poll whether or not a user has verified
+// their email address without requiring authentication, along with when they
+// did so if known. Unknown users are reported as unverified so that this can't
+// be used to enumerate usernames
+func (api *API) getEmailVerificationStatus(c *gin.Context) {
+	status := gin.H{"verified": false, "verified_at": nil}
+	if user, err := api.um.FindByUserName(c.Param("user")); err == nil && user.EmailEnabled {
+		status["verified"] = true
+		if at, ok := api.verifiedAt(user.UserName); ok {
+			status["verified_at"] = at.UTC().Format(time.RFC3339)
+		}
+	}
+	Respond(c, http.StatusOK, gin.H{"response": status})
 }
 
 // ChangeAccountPassword is used to change a users password
@@ -57,7 +98,27 @@ func (api *API) changeAccountPassword(c *gin.Context) {
 	// parse html encoded strings
 	forms["old_password"] = html.UnescapeString(forms["old_password"])
 	forms["new_password"] = html.UnescapeString(forms["new_password"])
+	if err := api.validatePasswordLength(forms["new_password"]); err != nil {
+		Fail(c, err, http.StatusBadRequest)
+		return
+	}
 	api.l.With("user", username).Info("password change requested")
+	user, err := api.um.FindByUserName(username)
+	if err != nil {
+		api.LogError(c, err, eh.UserSearchError)(http.StatusBadRequest)
+		return
+	}
+	// the history is only checked once the old password is confirmed, so
+	// that previous passwords can't be guessed using a stolen token
+	if bcrypt.CompareHashAndPassword([]byte(user.HashedPassword), []byte(forms["old_password"])) == nil {
+		if reused, err := api.passwordRecentlyUsed(user, forms["new_password"]); err != nil {
+			api.LogError(c, err, eh.PasswordChangeError)(http.StatusInternalServerError)
+			return
+		} else if reused {
+			Fail(c, errPasswordRecentlyUsed, http.StatusBadRequest)
+			return
+		}
+	}
 	// change password
 	if ok, err := api.um.ChangePassword(username, forms["old_password"], forms["new_password"]); err != nil {
 		api.LogError(c, err, eh.PasswordChangeError)(http.StatusBadRequest)
@@ -67,6 +128,19 @@ func (api *API) changeAccountPassword(c *gin.Context) {
 		api.LogError(c, err, eh.PasswordChangeError)(http.StatusBadRequest)
 		return
 	}
+	// the password has been changed, so failures from here on aren't returned to the user
+	if err := api.retirePassword(username, user.HashedPassword); err != nil {
+		api.l.Errorw("failed to record password history",
+			"user", username, "error", err.Error())
+	}
+	if err := api.clearPasswordChange(username); err != nil {
+		api.l.Errorw("failed to clear password change requirement",
+			"user", username, "error", err.Error())
+	}
+	if err := api.notifyPasswordChanged(username); err != nil {
+		api.l.Errorw("failed to send password changed notification",
+			"user", username, "error", err.Error())
+	}
 	// log and return
 	api.l.Infow("password changed",
 		"user", username)
@@ -81,6 +155,14 @@ func (api *API) registerUserAccount(c *gin.Context) {
 		FailWithMissingField(c, missingField)
 		return
 	}
+	forms["email_address"] = api.normalizeEmail(forms["email_address"])
+	// optionally require a captcha to reduce automated signups
+	if api.registrationCaptchaRequired(c.ClientIP()) {
+		if err := api.validateCaptcha(c); err != nil {
+			FailNotAuthorized(c, "captcha validation failed")
+			return
+		}
+	}
 	// parse emails to prevent exploit of catch-all routing
 	// where people sign up with an email like myuser+test@example.org
 	// by having the +test they are effectively signing up under a new email
@@ -98,13 +180,39 @@ func (api *API) registerUserAccount(c *gin.Context) {
 	}
 	// parse html encoded strings
 	forms["password"] = html.UnescapeString(forms["password"])
+	// prevent signup floods from a single email domain
+	if exceeded, err := api.domainRegistrationsExceeded(forms["email_address"], time.Now()); err != nil {
+		api.LogError(c, err, eh.UserSearchError)(http.StatusInternalServerError)
+		return
+	} else if exceeded {
+		FailWithMessage(c,
+			"too many accounts have recently been registered with this email domain, please try again later",
+			http.StatusTooManyRequests)
+		return
+	}
+	// prevent signup bots from registering many accounts from a single ip
+	if !api.ipRegistrationAllowed(c.ClientIP()) {
+		api.l.Infow("registration ip rate limited", "ip", c.ClientIP())
+		FailWithMessage(c,
+			"too many accounts have recently been registered from this ip address, please try again later",
+			http.StatusTooManyRequests)
+		return
+	}
 	// create user model
-	_, err := api.um.NewUserAccount(
-		forms["username"],
-		forms["password"],
-		forms["email_address"],
-	)
+	var err error
+	if api.caseFoldedDuplicate(forms["username"]) {
+		err = eh.ErrDuplicateUserName
+	} else {
+		_, err = api.um.NewUserAccount(
+			forms["username"],
+			forms["password"],
+			forms["email_address"],
+		)
+	}
 	api.handleUserCreate(c, forms, err)
+	if err == nil && c.Writer.Status() == http.StatusOK {
+		api.recordIPRegistration(c.ClientIP())
+	}
 }
 
 // CreateIPFSKey is used to create an IPFS key
@@ -199,9 +307,65 @@ func (api *API) getIPFSKeyNamesForAuthUser(c *gin.Context) {
 		Fail(c, errors.New(eh.NoKeyError), http.StatusNotFound)
 		return
 	}
-	// log and return
+	// log and return, capping the number of keys returned for accounts with
+	// many keys, which are directed to listIPFSKeys for the rest
 	api.l.Infow("key name list requested", "user", username)
-	Respond(c, http.StatusOK, gin.H{"response": gin.H{"key_names": keys["key_names"], "key_ids": keys["key_ids"]}})
+	Respond(c, http.StatusOK, gin.H{"response": keyPage(keys, 0, api.opts.MaxKeysPerResponse)})
+}
+
+// listIPFSKeys is used to page through the keys of the authenticated user
+func (api *API) listIPFSKeys(c *gin.Context) {
+	username, err := GetAuthenticatedUserFromContext(c)
+	if err != nil {
+		api.LogError(c, err, eh.NoAPITokenError)(http.StatusBadRequest)
+		return
+	}
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		FailWithBadRequest(c, "page must be a positive number")
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "10"))
+	if err != nil || limit < 1 {
+		FailWithBadRequest(c, "limit must be a positive number")
+		return
+	}
+	if api.opts.MaxKeysPerResponse > 0 && limit > api.opts.MaxKeysPerResponse {
+		limit = api.opts.MaxKeysPerResponse
+	}
+	keys, err := api.um.GetKeysForUser(username)
+	if err != nil {
+		api.LogError(c, err, eh.KeySearchError)(http.StatusBadRequest)
+		return
+	}
+	response := keyPage(keys, (page-1)*limit, limit)
+	response["page"] = page
+	response["limit"] = limit
+	Respond(c, http.StatusOK, gin.H{"response": response})
+}
+
+// keyPage returns up to limit of the key names and ids starting from offset,
+// along with the total number of keys and whether more follow. A limit of 0
+// returns every key from offset
+func keyPage(keys map[string][]string, offset, limit int) gin.H {
+	names, ids := keys["key_names"], keys["key_ids"]
+	total := len(names)
+	if len(ids) < total {
+		total = len(ids)
+	}
+	if offset > total {
+		offset = total
+	}
+	end := total
+	if limit > 0 && offset+limit < total {
+		end = offset + limit
+	}
+	return gin.H{
+		"key_names": names[offset:end],
+		"key_ids":   ids[offset:end],
+		"total":     total,
+		"more":      end < total,
+	}
 }
 
 // GetCredits is used to get a users available credits
@@ -239,80 +403,145 @@ func (api *API) forgotEmail(c *gin.Context) {
 	Respond(c, http.StatusOK, gin.H{"response": user.EmailAddress})
 }
 
-// ForgotUserName is used to send a username reminder to the email associated with the account
+// resendAccountEmail re-sends an account email which may have failed to send,
+// rebuilt from the current state of the account. The type field selects the
+// email, either "verification" or "upgrade". The same response is returned
+// regardless of outcome so that this can't be used to enumerate emails
+func (api *API) resendAccountEmail(c *gin.Context) {
+	forms, missingField := api.extractPostForms(c, "email_address", "type")
+	if missingField != "" {
+		FailWithMissingField(c, missingField)
+		return
+	}
+	kind := forms["type"]
+	if kind != emailVerification && kind != emailUpgrade {
+		Fail(c, errors.New("type must be one of verification, upgrade"))
+		return
+	}
+	forms["email_address"] = api.normalizeEmail(forms["email_address"])
+	defer Respond(c, http.StatusOK, gin.H{"response": "if an account with this email exists, the email has been resent"})
+	// limit how often emails can be resent to an address
+	if !api.recoveryAllowed(c, "resend-"+kind, forms["email_address"]) {
+		return
+	}
+	user, err := api.um.FindByEmail(forms["email_address"])
+	if err != nil {
+		api.LogError(c, err, eh.UserSearchError)
+		return
+	}
+	var es queue.EmailSend
+	switch kind {
+	case emailVerification:
+		if user.EmailEnabled {
+			api.l.Infow("verification email resend requested for verified account", "user", user.UserName)
+			return
+		}
+		es, err = api.verificationEmail(user, "")
+	case emailUpgrade:
+		var usage *models.Usage
+		if usage, err = api.usage.FindByUserName(user.UserName); err == nil {
+			es, err = upgradeEmailForTier(api.tiers, usage.Tier)
+		}
+	}
+	if err != nil {
+		api.l.Infow("failed to rebuild account email", "user", user.UserName, "type", kind, "error", err)
+		return
+	}
+	es.UserNames = []string{user.UserName}
+	es.Emails = []string{user.EmailAddress}
+	if err = api.publishEmail(es, kind); err != nil {
+		api.LogError(c, err, eh.QueuePublishError, "user", user.UserName)
+	}
+}
+
+// ForgotUserName is used to send a username reminder to the email associated with the account.
+// The same response is returned regardless of outcome so that this can't be used to enumerate emails
 func (api *API) forgotUserName(c *gin.Context) {
 	forms, missingField := api.extractPostForms(c, "email_address")
 	if missingField != "" {
 		FailWithMissingField(c, missingField)
 		return
 	}
+	forms["email_address"] = api.normalizeEmail(forms["email_address"])
+	defer Respond(c, http.StatusOK, gin.H{"response": "if an account with this email exists, a username reminder has been sent"})
+	// limit how often reminders can be sent to an address
+	if !api.recoveryAllowed(c, "username", forms["email_address"]) {
+		return
+	}
 	// find email address associated with the user account
 	user, err := api.um.FindByEmail(forms["email_address"])
 	if err != nil {
-		Fail(c, errors.New(eh.UserSearchError), http.StatusBadRequest)
+		api.LogError(c, err, eh.UserSearchError)
 		return
 	}
 	// account email must be enabled in order to engage in account recovery processes
 	if !user.EmailEnabled {
-		Fail(c, errors.New("account does not have email enabled, unfortunately for security reasons we can't assist in recovery"))
+		api.l.Infow("username reminder requested for account without email enabled", "user", user.UserName)
 		return
 	}
 	// construct email message
-	es := queue.EmailSend{
-		Subject:     "TEMPORAL User Name Reminder",
-		Content:     fmt.Sprintf("your username is %s", user.UserName),
-		ContentType: "text/html",
-		UserNames:   []string{user.UserName},
-		Emails:      []string{user.EmailAddress},
-	}
+	es := usernameReminderEmail(user.UserName)
+	es.UserNames = []string{user.UserName}
+	es.Emails = []string{user.EmailAddress}
 	// send message for processing
-	if err = api.queues.email.PublishMessage(es); err != nil {
-		api.LogError(c, err, eh.QueuePublishError)(http.StatusBadRequest)
-		return
+	if err = api.publishEmail(es, emailUsernameReminder); err != nil {
+		api.LogError(c, err, eh.QueuePublishError, "user", user.UserName)
 	}
-	// return
-	Respond(c, http.StatusOK, gin.H{"response": "username reminder sent account email"})
 }
 
-// ResetPassword is used to reset the password associated with a user account
+// ResetPassword is used to reset the password associated with a user account.
+// The same response is returned regardless of outcome so that this can't be used to enumerate emails
 func (api *API) resetPassword(c *gin.Context) {
 	forms, missingField := api.extractPostForms(c, "email_address")
 	if missingField != "" {
 		FailWithMissingField(c, missingField)
 		return
 	}
+	forms["email_address"] = api.normalizeEmail(forms["email_address"])
+	defer Respond(c, http.StatusOK, gin.H{"response": "if an account with this email exists, a password reset has been sent"})
+	// limit how often resets can be sent to an address
+	if !api.recoveryAllowed(c, "password", forms["email_address"]) {
+		return
+	}
 	// find user account associated with the email
 	user, err := api.um.FindByEmail(forms["email_address"])
 	if err != nil {
-		api.LogError(c, err, eh.UserSearchError)(http.StatusBadRequest)
+		api.LogError(c, err, eh.UserSearchError)
 		return
 	}
 	// account email must be enabled in order to engage in account reovery process
 	if !user.EmailEnabled {
-		Fail(c, errors.New("account does not have email enabled, unfortunately for security reasons we can't assist in recovery"))
+		api.l.Infow("password reset requested for account without email enabled", "user", user.UserName)
 		return
 	}
-	// reset password, generating a random one
-	newPass, err := api.um.ResetPassword(user.UserName)
-	if err != nil {
-		api.LogError(c, err, eh.PasswordResetError)(http.StatusBadRequest)
-		return
+	// reset password, generating a random one which isn't within the password history
+	var newPass string
+	for attempts := 0; ; attempts++ {
+		if newPass, err = api.um.ResetPassword(user.UserName); err != nil {
+			api.LogError(c, err, eh.PasswordResetError, "user", user.UserName)
+			return
+		}
+		if reused, err := api.passwordRecentlyUsed(user, newPass); err != nil || !reused || attempts == 2 {
+			break
+		}
 	}
-	// create email message
-	es := queue.EmailSend{
-		Subject:     "TEMPORAL Password Reset",
-		Content:     fmt.Sprintf("your password is %s", newPass),
-		ContentType: "text/html",
-		UserNames:   []string{user.UserName},
-		Emails:      []string{user.EmailAddress},
+	if err := api.retirePassword(user.UserName, user.HashedPassword); err != nil {
+		api.l.Errorw("failed to record password history",
+			"user", user.UserName, "error", err.Error())
 	}
+	// create email message
+	es := passwordResetEmail(newPass)
+	es.UserNames = []string{user.UserName}
+	es.Emails = []string{user.EmailAddress}
 	// send message to queue system for processing
-	if err = api.queues.email.PublishMessage(es); err != nil {
-		api.LogError(c, err, eh.QueuePublishError)(http.StatusBadRequest)
-		return
+	if err = api.publishEmail(es, emailPasswordReset); err != nil {
+		api.LogError(c, err, eh.QueuePublishError, "user", user.UserName)
+	}
+	// completed recoveries are notified like any other password change
+	if err := api.notifyPasswordChanged(user.UserName); err != nil {
+		api.l.Errorw("failed to send password changed notification",
+			"user", user.UserName, "error", err.Error())
 	}
-	// return
-	Respond(c, http.StatusOK, gin.H{"response": "password reset, please check your email for a new password"})
 }
 
 // UpgradeAccount is used to remove free tier restrictions and enable paid access
@@ -330,14 +559,16 @@ func (api *API) upgradeAccount(c *gin.Context) {
 	}
 	if usages.Tier == models.Unverified {
 		Fail(c, errors.New("unverified account upgrade process must be done via email verification"))
+		return
 	}
 	// prevent people from repeatedly calling this granting perpetual credits
-	if usages.Tier != models.Free {
+	info, _ := api.tiers.get(usages.Tier)
+	if info.UpgradeTo == "" {
 		Fail(c, errors.New("user account is already upgrade"))
 		return
 	}
 	// update tier
-	if err := api.usage.UpdateTier(username, models.Paid); err != nil {
+	if err := api.setTier(username, info.UpgradeTo); err != nil {
 		api.LogError(c, err, eh.TierUpgradeError)(http.StatusBadRequest)
 		return
 	}
@@ -348,15 +579,11 @@ func (api *API) upgradeAccount(c *gin.Context) {
 		return
 	}
 	// create email message
-	es := queue.EmailSend{
-		Subject:     "TEMPORAL Account Upgraded",
-		Content:     "your account has been upgraded to a paid account!",
-		ContentType: "text/html",
-		UserNames:   []string{username},
-		Emails:      []string{user.EmailAddress},
-	}
+	es := accountUpgradedEmail(info.UpgradeTo)
+	es.UserNames = []string{username}
+	es.Emails = []string{user.EmailAddress}
 	// send message to queue system for processing
-	if err = api.queues.email.PublishMessage(es); err != nil {
+	if err = api.publishEmail(es, emailUpgrade); err != nil {
 		api.LogError(c, err, eh.QueuePublishError)(http.StatusBadRequest)
 		return
 	}
@@ -377,5 +604,8 @@ func (api *API) usageData(c *gin.Context) {
 		return
 	}
 	// return data
-	Respond(c, http.StatusOK, gin.H{"response": usages})
+	Respond(c, http.StatusOK, gin.H{
+		"response":   usages,
+		"next_reset": NextUsageReset(time.Now()),
+	})
 }
@@ -1,12 +1,20 @@
 package v2
 
 import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/RTradeLtd/Temporal/eh"
 	"github.com/RTradeLtd/Temporal/mocks"
+	"github.com/RTradeLtd/Temporal/utils"
 	"github.com/RTradeLtd/config/v2"
 	"github.com/RTradeLtd/database/v2/models"
+	jwt "gopkg.in/dgrijalva/jwt-go.v3"
 )
 
 func Test_API_Routes_Account(t *testing.T) {
@@ -162,6 +170,28 @@ func Test_API_Routes_Account(t *testing.T) {
 	if mapAPIResp.Code != 200 {
 		t.Fatal("bad api status code from /v2/account/key/ipfs/get")
 	}
+	// capped key lists point to the paginated list for the rest
+	api.opts.MaxKeysPerResponse = 1
+	mapAPIResp = mapAPIResponse{}
+	if err := sendRequest(
+		api, "GET", "/v2/account/key/ipfs/get", 200, nil, nil, &mapAPIResp,
+	); err != nil {
+		t.Fatal(err)
+	}
+	if names := mapAPIResp.Response["key_names"].([]interface{}); len(names) != 1 {
+		t.Fatalf("expected 1 key name, got %v", len(names))
+	}
+	total := int(mapAPIResp.Response["total"].(float64))
+	mapAPIResp = mapAPIResponse{}
+	if err := sendRequest(
+		api, "GET", "/v2/account/key/ipfs/list?page=2&limit=10", 200, nil, nil, &mapAPIResp,
+	); err != nil {
+		t.Fatal(err)
+	}
+	if names := mapAPIResp.Response["key_names"].([]interface{}); len(names) != total-1 {
+		t.Fatalf("expected %v remaining key names, got %v", total-1, len(names))
+	}
+	api.opts.MaxKeysPerResponse = 0
 
 	// get available credits
 	// /v2/account/credits/available
@@ -185,16 +215,62 @@ func Test_API_Routes_Account(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	token, err := api.generateEmailJWTToken("verificationtestuser", userModel.EmailVerificationToken)
+	token, err := api.generateEmailJWTToken("verificationtestuser", userModel.EmailVerificationToken, userModel.EmailAddress)
 	if err != nil {
 		t.Fatal(err)
 	}
+	// check verification status before verifying
+	// /v2/account/email/status/:user
+	mapAPIResp = mapAPIResponse{}
+	if err := sendRequest(
+		api, "GET", "/v2/account/email/status/"+userModel.UserName, 200, nil, nil, &mapAPIResp,
+	); err != nil {
+		t.Fatal(err)
+	}
+	if mapAPIResp.Response["verified"] != false || mapAPIResp.Response["verified_at"] != nil {
+		t.Fatal("user should not be verified")
+	}
 	apiResp = apiResponse{}
 	if err := sendRequest(
 		api, "GET", "/v2/account/email/verify/"+userModel.UserName+"/"+token, 200, nil, nil, &apiResp,
 	); err != nil {
 		t.Fatal(err)
 	}
+	// check verification status after verifying
+	// /v2/account/email/status/:user
+	mapAPIResp = mapAPIResponse{}
+	if err := sendRequest(
+		api, "GET", "/v2/account/email/status/"+userModel.UserName, 200, nil, nil, &mapAPIResp,
+	); err != nil {
+		t.Fatal(err)
+	}
+	if mapAPIResp.Response["verified"] != true {
+		t.Fatal("user should be verified")
+	}
+	verifiedAt, _ := mapAPIResp.Response["verified_at"].(string)
+	if at, err := time.Parse(time.RFC3339, verifiedAt); err != nil || time.Since(at) > time.Minute {
+		t.Fatalf("unexpected verified_at %v", mapAPIResp.Response["verified_at"])
+	}
+	// following the same link again is a success
+	apiResp = apiResponse{}
+	if err := sendRequest(
+		api, "GET", "/v2/account/email/verify/"+userModel.UserName+"/"+token, 200, nil, nil, &apiResp,
+	); err != nil {
+		t.Fatal(err)
+	}
+	if apiResp.Response != "email already verified" {
+		t.Fatalf("unexpected response %s", apiResp.Response)
+	}
+	// invalid links for a verified user are rejected like any other
+	apiResp = apiResponse{}
+	if err := sendRequest(
+		api, "GET", "/v2/account/email/verify/"+userModel.UserName+"/notavalidtoken", 400, nil, nil, &apiResp,
+	); err != nil {
+		t.Fatal(err)
+	}
+	if apiResp.Response != eh.InvalidVerificationLinkError {
+		t.Fatalf("unexpected response %s", apiResp.Response)
+	}
 
 	// forgot email
 	// /v2/account/email/forgot
@@ -253,6 +329,15 @@ func Test_API_Routes_Account(t *testing.T) {
 	if apiResp.Code != 200 {
 		t.Fatal("bad api status code from /v2/account/upgrade")
 	}
+	// upgraded accounts are given the limits reported for their tier
+	usage, err := api.usage.FindByUserName("testuser")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info, _ := api.tiers.get(usage.Tier); usage.KeysAllowed != info.KeysAllowed ||
+		usage.IPNSRecordsAllowed != info.IPNSRecordsAllowed {
+		t.Fatalf("usage limits %+v don't match tier policies %+v", usage, info)
+	}
 
 	// usage data
 	// /v2/account/upgrade
@@ -267,3 +352,280 @@ func Test_API_Routes_Account(t *testing.T) {
 		t.Fatal("bad api status code from /v2/account/usage")
 	}
 }
+
+func Test_API_Routes_Account_ResetPassword_Notification(t *testing.T) {
+	api := newTestAPI(t)
+	randUser := utils.GenerateRandomUtils().GenerateString(32, utils.LetterBytes)
+	registerTestUser(t, api, randUser)
+	// only verified accounts can recover their password
+	verifyTestUser(t, api, randUser)
+	urlValues := url.Values{}
+	urlValues.Add("email_address", randUser+"@example.org")
+	if err := sendRequest(
+		api, "POST", "/v2/forgot/password", 200, nil, urlValues, nil,
+	); err != nil {
+		t.Fatal(err)
+	}
+	// the new password is sent, along with the notice that it was changed
+	api.emails.wait()
+	sent := api.emails.report()["sent"].(map[string]int64)
+	if sent[emailPasswordReset] != 1 || sent[emailPasswordChanged] != 1 {
+		t.Fatalf("expected a password reset and a password changed email, sent %v", sent)
+	}
+}
+
+func Test_API_Routes_Account_PasswordHistory(t *testing.T) {
+	api := newTestAPI(t)
+	api.opts.PasswordHistory = 2
+	defer func() { api.opts.PasswordHistory = 0 }()
+	randUser := utils.GenerateRandomUtils().GenerateString(32, utils.LetterBytes)
+	registerTestUser(t, api, randUser)
+	verifyTestUser(t, api, randUser)
+	token := loginTestUser(t, api, randUser, testUserPassword)
+	change := func(oldPassword, newPassword string, wantCode int) {
+		testRecorder := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "/v2/account/password/change", nil)
+		req.Header.Add("Authorization", "Bearer "+token)
+		req.PostForm = url.Values{"old_password": {oldPassword}, "new_password": {newPassword}}
+		api.r.ServeHTTP(testRecorder, req)
+		if testRecorder.Code != wantCode {
+			t.Fatalf("bad status code changing %s to %s, got %v, want %v",
+				oldPassword, newPassword, testRecorder.Code, wantCode)
+		}
+		if wantCode == http.StatusBadRequest && !strings.Contains(testRecorder.Body.String(), "password recently used") {
+			t.Fatalf("expected password reuse to be reported, got %s", testRecorder.Body.String())
+		}
+	}
+	// the current and previous passwords can't be reused
+	change(testUserPassword, testUserPassword, http.StatusBadRequest)
+	change(testUserPassword, "password1", http.StatusOK)
+	change("password1", testUserPassword, http.StatusBadRequest)
+	change("password1", "password2", http.StatusOK)
+	// while passwords outside of the history can be
+	change("password2", testUserPassword, http.StatusOK)
+	var count int
+	if err := api.dbm.DB.Model(&passwordHistory{}).Where("user_name = ?", randUser).Count(&count).Error; err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Fatalf("expected 1 previous password to be kept, got %v", count)
+	}
+}
+
+func Test_API_Routes_Account_NotificationPreferences(t *testing.T) {
+	api := newTestAPI(t)
+	randUser := utils.GenerateRandomUtils().GenerateString(32, utils.LetterBytes)
+	registerTestUser(t, api, randUser)
+	verifyTestUser(t, api, randUser)
+	token := loginTestUser(t, api, randUser, testUserPassword)
+	request := func(method, path string, forms url.Values, wantCode int) {
+		testRecorder := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Add("Authorization", "Bearer "+token)
+		req.PostForm = forms
+		api.r.ServeHTTP(testRecorder, req)
+		if testRecorder.Code != wantCode {
+			t.Fatalf("bad status code from %s, got %v, want %v", path, testRecorder.Code, wantCode)
+		}
+	}
+	// critical emails can't be opted out of
+	request("POST", "/v2/account/notifications/update",
+		url.Values{"type": {emailPasswordReset}, "enabled": {"false"}}, http.StatusBadRequest)
+	request("POST", "/v2/account/notifications/update",
+		url.Values{"type": {emailUpgrade}, "enabled": {"false"}}, http.StatusOK)
+	if preferences, err := api.notificationPreferences(randUser); err != nil {
+		t.Fatal(err)
+	} else if preferences[emailUpgrade] {
+		t.Fatal("expected upgrade emails to be opted out of")
+	}
+	// opted out users don't receive the upgrade email
+	request("POST", "/v2/account/upgrade", nil, http.StatusOK)
+	// but still receive password resets
+	urlValues := url.Values{}
+	urlValues.Add("email_address", randUser+"@example.org")
+	if err := sendRequest(api, "POST", "/v2/forgot/password", 200, nil, urlValues, nil); err != nil {
+		t.Fatal(err)
+	}
+	api.emails.wait()
+	sent := api.emails.report()["sent"].(map[string]int64)
+	if sent[emailUpgrade] != 0 {
+		t.Fatalf("expected no upgrade email, sent %v", sent[emailUpgrade])
+	}
+	if sent[emailPasswordReset] != 1 {
+		t.Fatalf("expected a password reset email, sent %v", sent[emailPasswordReset])
+	}
+}
+
+func Test_API_Routes_Account_Verification_Stale(t *testing.T) {
+	api := newTestAPI(t)
+	defer func() { api.opts.OpaqueVerificationCodes = false }()
+	randUtils := utils.GenerateRandomUtils()
+	for _, opaque := range []bool{false, true} {
+		api.opts.OpaqueVerificationCodes = opaque
+		randUser := randUtils.GenerateString(32, utils.LetterBytes)
+		registerTestUser(t, api, randUser)
+		// regenerating the verification string invalidates every previously issued token
+		var tokens []string
+		for i := 0; i < 2; i++ {
+			userModel, err := api.um.GenerateEmailVerificationToken(randUser)
+			if err != nil {
+				t.Fatal(err)
+			}
+			token, err := api.generateVerificationToken(randUser, userModel.EmailVerificationToken, userModel.EmailAddress)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tokens = append(tokens, token)
+		}
+		if err := sendRequest(
+			api, "GET", "/v2/account/email/verify/"+randUser+"/"+tokens[0], 400, nil, nil, nil,
+		); err != nil {
+			t.Fatalf("stale token with opaque codes %v: %s", opaque, err)
+		}
+		if err := sendRequest(
+			api, "GET", "/v2/account/email/verify/"+randUser+"/"+tokens[1], 200, nil, nil, nil,
+		); err != nil {
+			t.Fatalf("latest token with opaque codes %v: %s", opaque, err)
+		}
+	}
+}
+
+func Test_API_Routes_Account_Verification_Failures(t *testing.T) {
+	api := newTestAPI(t)
+	randUtils := utils.GenerateRandomUtils()
+	randUser := randUtils.GenerateString(32, utils.LetterBytes)
+	registerTestUser(t, api, randUser)
+	userModel, err := api.um.GenerateEmailVerificationToken(randUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// token for a user that does not exist
+	unknownUserToken, err := api.generateEmailJWTToken("notarealuseraccount", userModel.EmailVerificationToken, userModel.EmailAddress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// token with a verification string that doesn't match
+	wrongToken, err := api.generateEmailJWTToken(randUser, "notthecorrectverificationstring", userModel.EmailAddress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// token that has already expired
+	expiredToken, err := jwt.NewWithClaims(jwt.SigningMethodHS512, jwt.MapClaims{
+		"user":                    randUser,
+		"emailVerificationString": userModel.EmailVerificationToken,
+		"expire":                  time.Now().Add(-time.Hour).UTC().String(),
+	}).SignedString([]byte(api.cfg.API.JWT.Key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name  string
+		user  string
+		token string
+	}{
+		{"Unknown-User", "notarealuseraccount", unknownUserToken},
+		{"Wrong-Token", randUser, wrongToken},
+		{"Expired-Token", randUser, expiredToken},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var apiResp apiResponse
+			if err := sendRequest(
+				api, "GET", "/v2/account/email/verify/"+tt.user+"/"+tt.token, 400, nil, nil, &apiResp,
+			); err != nil {
+				t.Fatal(err)
+			}
+			// all failures must be indistinguishable to the caller
+			if apiResp != (apiResponse{Code: 400, Response: eh.InvalidVerificationLinkError}) {
+				t.Fatalf("unexpected response %+v", apiResp)
+			}
+		})
+	}
+}
+
+func Test_API_Routes_Account_Verification_Redirect(t *testing.T) {
+	api := newTestAPI(t)
+	defer func() {
+		api.opts.VerificationSuccessURL = ""
+		api.opts.VerificationFailureURL = ""
+	}()
+	randUtils := utils.GenerateRandomUtils()
+	tests := []struct {
+		name         string
+		successURL   string
+		failureURL   string
+		valid        bool
+		wantCode     int
+		wantLocation string
+	}{
+		{"Success-Redirect", "https://app.example.org/verified", "https://app.example.org/failed", true, http.StatusFound, "https://app.example.org/verified"},
+		{"Failure-Redirect", "https://app.example.org/verified", "https://app.example.org/failed", false, http.StatusFound, "https://app.example.org/failed"},
+		{"Success-JSON", "", "https://app.example.org/failed", true, http.StatusOK, ""},
+		{"Failure-JSON", "https://app.example.org/verified", "", false, http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api.opts.VerificationSuccessURL = tt.successURL
+			api.opts.VerificationFailureURL = tt.failureURL
+			randUser := randUtils.GenerateString(32, utils.LetterBytes)
+			registerTestUser(t, api, randUser)
+			userModel, err := api.um.GenerateEmailVerificationToken(randUser)
+			if err != nil {
+				t.Fatal(err)
+			}
+			verificationString := userModel.EmailVerificationToken
+			if !tt.valid {
+				verificationString = "notthecorrectverificationstring"
+			}
+			token, err := api.generateEmailJWTToken(randUser, verificationString, userModel.EmailAddress)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rec := httptest.NewRecorder()
+			api.r.ServeHTTP(rec, httptest.NewRequest("GET", "/v2/account/email/verify/"+randUser+"/"+token, nil))
+			if rec.Code != tt.wantCode {
+				t.Fatalf("got status %v, want %v", rec.Code, tt.wantCode)
+			}
+			if location := rec.Header().Get("Location"); location != tt.wantLocation {
+				t.Fatalf("got location %q, want %q", location, tt.wantLocation)
+			}
+		})
+	}
+}
+
+func Test_keyPage(t *testing.T) {
+	keys := map[string][]string{}
+	for i := 0; i < 25; i++ {
+		keys["key_names"] = append(keys["key_names"], fmt.Sprintf("key%v", i))
+		keys["key_ids"] = append(keys["key_ids"], fmt.Sprintf("id%v", i))
+	}
+	tests := []struct {
+		name      string
+		offset    int
+		limit     int
+		wantFirst string
+		wantLen   int
+		wantMore  bool
+	}{
+		{"Uncapped", 0, 0, "key0", 25, false},
+		{"Capped", 0, 10, "key0", 10, true},
+		{"Second-Page", 10, 10, "key10", 10, true},
+		{"Remainder", 20, 10, "key20", 5, false},
+		{"Past-End", 30, 10, "", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			page := keyPage(keys, tt.offset, tt.limit)
+			names, ids := page["key_names"].([]string), page["key_ids"].([]string)
+			if len(names) != tt.wantLen || len(ids) != tt.wantLen {
+				t.Fatalf("got %v names and %v ids, want %v", len(names), len(ids), tt.wantLen)
+			}
+			if tt.wantLen > 0 && names[0] != tt.wantFirst {
+				t.Fatalf("first key = %v, want %v", names[0], tt.wantFirst)
+			}
+			if page["more"] != tt.wantMore || page["total"] != 25 {
+				t.Fatalf("more = %v, total = %v", page["more"], page["total"])
+			}
+		})
+	}
+}
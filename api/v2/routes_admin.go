@@ -0,0 +1,261 @@
+package v2
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/RTradeLtd/Temporal/api/middleware"
+	"github.com/RTradeLtd/Temporal/eh"
+	"github.com/RTradeLtd/database/v2/models"
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+)
+
+// resetMonthlyUsage allows an administrator to trigger the monthly usage reset
+func (api *API) resetMonthlyUsage(c *gin.Context) {
+	username, err := GetAuthenticatedUserFromContext(c)
+	if err != nil {
+		api.LogError(c, err, eh.NoAPITokenError)(http.StatusBadRequest)
+		return
+	}
+	reset, err := NewQuotaChecker(api.usage).ResetMonthlyUsage()
+	if err != nil {
+		api.LogError(c, err, "failed to reset monthly usage")(http.StatusInternalServerError)
+		return
+	}
+	api.l.Infow("monthly usage reset", "admin", username, "accounts", reset)
+	Respond(c, http.StatusOK, gin.H{"response": gin.H{
+		"accounts_reset": reset,
+		"next_reset":     NextUsageReset(time.Now()),
+	}})
+}
+
+// purgeUnverifiedAccounts allows an administrator to delete accounts which never
+// verified their email address within older_than, such as "720h". Setting
+// dry_run only reports the accounts which would be purged
+func (api *API) purgeUnverifiedAccounts(c *gin.Context) {
+	username, err := GetAuthenticatedUserFromContext(c)
+	if err != nil {
+		api.LogError(c, err, eh.NoAPITokenError)(http.StatusBadRequest)
+		return
+	}
+	forms, missingField := api.extractPostForms(c, "older_than")
+	if missingField != "" {
+		FailWithMissingField(c, missingField)
+		return
+	}
+	olderThan, err := time.ParseDuration(forms["older_than"])
+	if err != nil {
+		Fail(c, err)
+		return
+	}
+	dryRun := c.PostForm("dry_run") == "true"
+	purged, err := NewAccountMaintainer(api.dbm.DB).PurgeUnverifiedAccounts(olderThan, dryRun)
+	if err != nil {
+		api.LogError(c, err, "failed to purge unverified accounts")(http.StatusBadRequest)
+		return
+	}
+	api.l.Infow("unverified accounts purged",
+		"admin", username, "accounts", len(purged), "older_than", olderThan, "dry_run", dryRun)
+	Respond(c, http.StatusOK, gin.H{"response": gin.H{
+		"accounts": purged,
+		"dry_run":  dryRun,
+	}})
+}
+
+// broadcastEmail allows an administrator to email every verified user
+func (api *API) broadcastEmail(c *gin.Context) {
+	username, err := GetAuthenticatedUserFromContext(c)
+	if err != nil {
+		api.LogError(c, err, eh.NoAPITokenError)(http.StatusBadRequest)
+		return
+	}
+	forms, missingField := api.extractPostForms(c, "subject", "content")
+	if missingField != "" {
+		FailWithMissingField(c, missingField)
+		return
+	}
+	var users []models.User
+	if err := api.dbm.DB.Select("user_name, email_address").
+		Where("email_enabled = ?", true).Find(&users).Error; err != nil {
+		api.LogError(c, err, eh.UserSearchError)(http.StatusInternalServerError)
+		return
+	}
+	es := htmlEmail(forms["subject"], forms["content"])
+	for _, user := range users {
+		es.UserNames = append(es.UserNames, user.UserName)
+		es.Emails = append(es.Emails, user.EmailAddress)
+	}
+	batches := batchEmail(es, broadcastBatchSize)
+	for _, batch := range batches {
+		if err := api.publishEmail(batch, emailBroadcast); err != nil {
+			api.LogError(c, err, eh.QueuePublishError)(http.StatusBadRequest)
+			return
+		}
+	}
+	api.l.Infow("broadcast email sent",
+		"admin", username, "recipients", len(users), "messages", len(batches))
+	Respond(c, http.StatusOK, gin.H{"response": gin.H{
+		"recipients": len(users),
+		"messages":   len(batches),
+	}})
+}
+
+// grantCredits allows an administrator to grant credits to many users at once,
+// such as for promotions. Recipients are given as repeated username fields, or
+// as every user within a tier. Each grant is identified by grant_id, so that a
+// grant which partially failed can be re-run without granting credits twice
+func (api *API) grantCredits(c *gin.Context) {
+	username, err := GetAuthenticatedUserFromContext(c)
+	if err != nil {
+		api.LogError(c, err, eh.NoAPITokenError)(http.StatusBadRequest)
+		return
+	}
+	forms, missingField := api.extractPostForms(c, "grant_id", "amount", "reason")
+	if missingField != "" {
+		FailWithMissingField(c, missingField)
+		return
+	}
+	amount, err := strconv.ParseFloat(forms["amount"], 64)
+	if err != nil || amount <= 0 {
+		FailWithBadRequest(c, "amount must be a positive number")
+		return
+	}
+	usernames := c.PostFormArray("username")
+	if tier := c.PostForm("tier"); tier != "" {
+		if len(usernames) > 0 {
+			FailWithBadRequest(c, "only one of username or tier may be given")
+			return
+		}
+		if err := api.dbm.DB.Model(&models.Usage{}).
+			Where("tier = ?", tier).Pluck("user_name", &usernames).Error; err != nil {
+			api.LogError(c, err, eh.UserSearchError)(http.StatusInternalServerError)
+			return
+		}
+	}
+	if len(usernames) == 0 {
+		FailWithBadRequest(c, "no recipients given")
+		return
+	}
+	results := runCreditGrant(api.dbm.DB, forms["grant_id"], usernames, func(tx *gorm.DB, recipient string) error {
+		if _, err := models.NewUserManager(tx).AddCredits(recipient, amount); err != nil {
+			return err
+		}
+		api.l.Infow("credits granted", "admin", username, "user", recipient,
+			"amount", amount, "reason", forms["reason"], "grant_id", forms["grant_id"])
+		return nil
+	})
+	Respond(c, http.StatusOK, gin.H{"response": results})
+}
+
+// provisionAccount allows an administrator to create an account with a
+// temporary password, which must be changed before the account can be used.
+// The email address is trusted, so the account is verified immediately
+func (api *API) provisionAccount(c *gin.Context) {
+	username, err := GetAuthenticatedUserFromContext(c)
+	if err != nil {
+		api.LogError(c, err, eh.NoAPITokenError)(http.StatusBadRequest)
+		return
+	}
+	forms, missingField := api.extractPostForms(c, "username", "password", "email_address")
+	if missingField != "" {
+		FailWithMissingField(c, missingField)
+		return
+	}
+	forms["email_address"] = api.normalizeEmail(forms["email_address"])
+	if err := api.validatePasswordLength(forms["password"]); err != nil {
+		Fail(c, err, http.StatusBadRequest)
+		return
+	}
+	if api.caseFoldedDuplicate(forms["username"]) {
+		api.LogError(c, eh.ErrDuplicateUserName, eh.DuplicateUserNameError,
+			"username", forms["username"])(eh.StatusFor(eh.ErrDuplicateUserName))
+		return
+	}
+	if _, err := api.um.NewUserAccount(forms["username"], forms["password"], forms["email_address"]); err != nil {
+		err = eh.Sentinel(err)
+		api.LogError(c, err, eh.UserAccountCreationError, "username", forms["username"])(eh.StatusFor(err))
+		return
+	}
+	// the requirement is recorded before the account is enabled,
+	// so that the temporary password can't be used for anything else
+	if err := api.requirePasswordChange(forms["username"]); err != nil {
+		api.LogError(c, err, "failed to require password change")(http.StatusInternalServerError)
+		return
+	}
+	user, err := api.um.GenerateEmailVerificationToken(forms["username"])
+	if err == nil {
+		_, err = api.activateAccount(user.UserName, user.EmailVerificationToken)
+	}
+	if err != nil {
+		api.LogError(c, err, eh.UserAccountCreationError)(http.StatusInternalServerError)
+		return
+	}
+	api.l.Infow("account provisioned", "admin", username, "user", user.UserName)
+	Respond(c, http.StatusOK, gin.H{"response": gin.H{
+		"username":             user.UserName,
+		"must_change_password": true,
+	}})
+}
+
+// suspendAccount allows an administrator to suspend an account, such as while
+// investigating abuse. Suspended accounts can't sign in or use existing tokens,
+// but are otherwise left intact and can still be emailed. The suspension is
+// recorded along with its reason, the administrator and when it happened
+func (api *API) suspendAccount(c *gin.Context) {
+	api.setAccountSuspended(c, true)
+}
+
+// unsuspendAccount allows an administrator to restore a suspended account.
+// Only the suspension is removed, so accounts disabled for any other reason
+// remain disabled
+func (api *API) unsuspendAccount(c *gin.Context) {
+	api.setAccountSuspended(c, false)
+}
+
+// setAccountSuspended suspends or restores the account given by the username
+// form, notifying the user along with the optional reason form
+func (api *API) setAccountSuspended(c *gin.Context, suspended bool) {
+	username, err := GetAuthenticatedUserFromContext(c)
+	if err != nil {
+		api.LogError(c, err, eh.NoAPITokenError)(http.StatusBadRequest)
+		return
+	}
+	forms, missingField := api.extractPostForms(c, "username")
+	if missingField != "" {
+		FailWithMissingField(c, missingField)
+		return
+	}
+	user, err := api.um.FindByUserName(forms["username"])
+	if err != nil {
+		api.LogError(c, err, eh.UserSearchError)(http.StatusNotFound)
+		return
+	}
+	reason := c.PostForm("reason")
+	if suspended {
+		err = middleware.SuspendAccount(api.dbm.DB, user.UserName, username, reason)
+	} else {
+		err = middleware.RestoreAccount(api.dbm.DB, user.UserName)
+	}
+	if err != nil {
+		api.LogError(c, err, "failed to update account suspension")(http.StatusInternalServerError)
+		return
+	}
+	es := accountRestoredEmail()
+	if suspended {
+		es = accountSuspendedEmail(reason)
+	}
+	es.UserNames = []string{user.UserName}
+	es.Emails = []string{user.EmailAddress}
+	// the account has been updated, so failing to notify the user isn't fatal
+	if err := api.publishEmail(es, emailSuspension); err != nil {
+		api.l.Errorw("failed to send account suspension email", "error", err, "user", user.UserName)
+	}
+	api.l.Infow("account suspension updated",
+		"admin", username, "user", user.UserName, "suspended", suspended, "reason", reason)
+	Respond(c, http.StatusOK, gin.H{"response": gin.H{
+		"username":  user.UserName,
+		"suspended": suspended,
+	}})
+}
@@ -143,6 +143,7 @@ func (api *API) registerOrgUser(c *gin.Context) {
 		FailWithMissingField(c, missingField)
 		return
 	}
+	forms["email_address"] = api.normalizeEmail(forms["email_address"])
 	// prevent people from registering usernames that contain an `@` sign
 	// this prevents griefing by prevent user sign-ins by using a username
 	// that is based off an email address
@@ -157,12 +158,16 @@ func (api *API) registerOrgUser(c *gin.Context) {
 	forms["password"] = html.UnescapeString(forms["password"])
 	// create the org user. this process is similar to regular
 	// user registration, so we handle the errors in the same way
-	_, err = api.orgs.RegisterOrgUser(
-		forms["organization_name"],
-		forms["username"],
-		forms["password"],
-		forms["email_address"],
-	)
+	if api.caseFoldedDuplicate(forms["username"]) {
+		err = eh.ErrDuplicateUserName
+	} else {
+		_, err = api.orgs.RegisterOrgUser(
+			forms["organization_name"],
+			forms["username"],
+			forms["password"],
+			forms["email_address"],
+		)
+	}
 	api.handleUserCreate(c, forms, err)
 }
 
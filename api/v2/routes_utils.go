@@ -10,7 +10,6 @@ import (
 	"time"
 
 	"github.com/RTradeLtd/Temporal/eh"
-	"github.com/RTradeLtd/Temporal/queue"
 	"github.com/RTradeLtd/crypto/v2"
 	"github.com/RTradeLtd/database/v2/models"
 	mnemonics "github.com/RTradeLtd/entropy-mnemonics"
@@ -270,22 +269,23 @@ func (api *API) downloadContentHash(c *gin.Context) {
 
 func (api *API) handleUserCreate(c *gin.Context, forms map[string]string, createErr error) {
 	if createErr != nil {
-		switch createErr.Error() {
-		case eh.DuplicateEmailError:
+		createErr = eh.Sentinel(createErr)
+		switch {
+		case errors.Is(createErr, eh.ErrDuplicateEmail):
 			api.LogError(
 				c,
 				createErr,
 				eh.DuplicateEmailError,
 				"email",
-				forms["email_address"])(http.StatusBadRequest)
+				forms["email_address"])(eh.StatusFor(createErr))
 			return
-		case eh.DuplicateUserNameError:
+		case errors.Is(createErr, eh.ErrDuplicateUserName):
 			api.LogError(
 				c,
 				createErr,
 				eh.DuplicateUserNameError,
 				"username",
-				forms["username"])(http.StatusBadRequest)
+				forms["username"])(eh.StatusFor(createErr))
 			return
 		default:
 			api.LogError(
@@ -295,72 +295,35 @@ func (api *API) handleUserCreate(c *gin.Context, forms map[string]string, create
 			return
 		}
 	}
+	api.funnel.accountRegistered()
+	api.alerts.accountRegistered()
 	// generate a random token to validate email
 	user, err := api.um.GenerateEmailVerificationToken(forms["username"])
 	if err != nil {
 		api.LogError(c, err, eh.EmailTokenGenerationError)(http.StatusBadRequest)
 		return
 	}
-	// generate a jwt used to trigger email validation
-	token, err := api.generateEmailJWTToken(user.UserName, user.EmailVerificationToken)
-	if err != nil {
-		api.LogError(c, err, "failed to generate email verification jwt")
-		return
-	}
-	var url string
-	// format the url the user clicks to activate email
-	if dev {
-		url = fmt.Sprintf(
-			"https://dev.api.temporal.cloud/v2/account/email/verify/%s/%s",
-			user.UserName, token,
-		)
+	if api.opts.SkipEmailVerification {
+		// enable the account immediately, without sending a verification email
+		if user, err = api.activateAccount(user.UserName, user.EmailVerificationToken); err != nil {
+			api.LogError(c, err, eh.UserAccountCreationError)(http.StatusBadRequest)
+			return
+		}
 	} else {
-		url = fmt.Sprintf(
-			"https://api.temporal.cloud/v2/account/email/verify/%s/%s",
-			user.UserName, token,
-		)
-
-	}
-	// format a link tag
-	link := fmt.Sprintf("<a href=\"%s\">link</a>", url)
-	emailSubject := fmt.Sprintf(
-		"%s Welcome To Temporal 🌌 Read This For Crucial Getting Started Tips", forms["organization_name"],
-	)
-	// build email message
-	es := queue.EmailSend{
-		Subject: emailSubject,
-		Content: fmt.Sprintf(
-			"%s%s%s%s%s%s%s%s%s%s%s%s%s%s%s%s%s%s%s%s%s",
-			"Thanks for signing up with Temporal, before you get started it's important we discuss our pinning system.\n",
-			"When uploading to Temporal you must specify a \"hold time\" which tells our system how long your data should be around for.\n",
-			"When you're using Temporal via the playground, or the API directly you can configure this for up to 24 months with paid accounts, and up to 12 months for free accounts.\n",
-			"Temporal’s free tier offers 3GB of storage on the house, paid tier rates are just $0.07/GB and partner tier rates are $0.05/GB.\n",
-			"<br>",
-			"<br>",
-			"When using Temporal through third-party implementations like our IPFS HTTP API reverse proxy, or the ENS management app, we use a default hold time of 12 months for free users and 1 month for paid users.\n",
-			"We use 1 month for paid users because being in the paid tier means you have to pay for the data consumption and we don't want to overcharge paid users.\n",
-			"For example if you used the ENS management app to upload your website, and want it to stick around for longer than the default duration you need to extend the pin.\n",
-			"Pin extension can be done via the <a href=\"https://play2.temporal.cloud\">Temporal Playground</a> or via the API.\n",
-			"<br>",
-			"<br>",
-			"Lastly let's talk about emails! We try our best to not spam your inbox, so we limit emails to a few things: payment notifications, pin expiration warnings, password/username retrieval and processing failures.\n",
-			"But before we do this, you must validate your email. Additionally before validating your email, you are in the 'unverified' tier which is limited to 100MB of data consumption. Email verification is now mandatory\n",
-			"To validate your email, just click the following "+link+"\n",
-			"<br>",
-			"<br>",
-			"Questions, comments, concerns, or just feeling talkative? Join us on Telegram where you can receive live support and updates: <a href=\"https://t.me/RTradeTEMPORAL\">click here</a>\n",
-			"<br>",
-			"<br>",
-			"Thanks for signing up!",
-		),
-		ContentType: "text/html",
-		UserNames:   []string{user.UserName},
-		Emails:      []string{user.EmailAddress},
-	}
-	// send email message to queue for processing
-	if err = api.queues.email.PublishMessage(es); err != nil {
-		api.LogError(c, err, eh.QueuePublishError)(http.StatusBadRequest)
-		return
+		// build email message containing the link or code used to verify the account
+		es, err := api.verificationEmail(user, forms["organization_name"])
+		if err != nil {
+			api.LogError(c, err, "failed to generate email verification token")
+			return
+		}
+		es.UserNames = []string{user.UserName}
+		es.Emails = []string{user.EmailAddress}
+		// send email message to queue for processing
+		if err = api.publishEmail(es, emailVerification); err != nil {
+			api.LogError(c, err, eh.QueuePublishError)(http.StatusBadRequest)
+			return
+		}
+		api.funnel.verificationEmailSent()
 	}
 	// remove hashed password from output
 	user.HashedPassword = "scrubbed"
@@ -391,13 +354,18 @@ func (api *API) handleUserCreate(c *gin.Context, forms map[string]string, create
 }
 
 func (api *API) verifyCaptcha(c *gin.Context) {
-	err := api.captcha.VerifyWithOptions(
+	if err := api.validateCaptcha(c); err != nil {
+		Fail(c, errors.New("captcha validation failed"))
+		return
+	}
+	Respond(c, http.StatusOK, gin.H{"response": "captcha validation succeeded"})
+}
+
+// validateCaptcha is used to validate the captcha response submitted with a request
+func (api *API) validateCaptcha(c *gin.Context) error {
+	return api.captcha.VerifyWithOptions(
 		c.PostForm("g-recaptcha-response"),
 		// require a threshold of 0.8, default is 0.5
 		recaptcha.VerifyOption{Threshold: 0.8},
 	)
-	if err != nil {
-		Fail(c, errors.New("captcha validation failed"))
-	}
-	Respond(c, http.StatusOK, gin.H{"response": "captcha validation succeeded"})
 }
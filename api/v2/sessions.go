@@ -0,0 +1,125 @@
+package v2
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/RTradeLtd/Temporal/api/middleware"
+	"github.com/RTradeLtd/Temporal/eh"
+	jwt "github.com/appleboy/gin-jwt"
+	"github.com/gin-gonic/gin"
+)
+
+// checkSession ensures the session of a token hasn't been revoked,
+// it always succeeds when sessions aren't tracked
+func (api *API) checkSession(claims *TemporalClaims) error {
+	if api.sessions == nil {
+		return nil
+	}
+	return middleware.CheckSession(api.sessions, claims.Custom["sid"], claims.ID)
+}
+
+// checkSignedOut ensures the user of a token hasn't signed out everywhere
+// since it was issued, it always succeeds before routes are set up
+func (api *API) checkSignedOut(claims *TemporalClaims) error {
+	if api.signOuts == nil {
+		return nil
+	}
+	return middleware.CheckSignOut(api.signOuts, claims.ID, claims.OrigIssuedAt)
+}
+
+// signOutEverywhere returns a handler which invalidates every token issued to
+// the authenticated user, such as when they suspect their account was
+// compromised. When reissue is set a fresh token is returned for the current
+// session, equivalent to one issued by signing in through mw
+func (api *API) signOutEverywhere(mw *jwt.GinJWTMiddleware) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		username, err := GetAuthenticatedUserFromContext(c)
+		if err != nil {
+			api.LogError(c, err, eh.NoAPITokenError)(http.StatusBadRequest)
+			return
+		}
+		at, err := middleware.SignOutEverywhere(api.signOuts, username)
+		if err != nil {
+			api.LogError(c, err, "failed to sign out everywhere")(http.StatusInternalServerError)
+			return
+		}
+		api.l.Infow("signed out everywhere", "user", username)
+		response := gin.H{"response": "signed out everywhere"}
+		if reissue, _ := strconv.ParseBool(c.PostForm("reissue")); reissue {
+			// the fresh token is issued as of the sign out so that it remains valid
+			token, expire, err := signSessionToken(mw, username, at)
+			if err != nil {
+				api.LogError(c, err, "failed to generate token")(http.StatusInternalServerError)
+				return
+			}
+			response["token"] = token
+			response["expire"] = expire.Format(time.RFC3339)
+		}
+		Respond(c, http.StatusOK, response)
+	}
+}
+
+// logout revokes the session of the token used to authenticate
+// the request, along with any tokens refreshed from it
+func (api *API) logout(c *gin.Context) {
+	sid, _ := Claim(c, "sid").(string)
+	if err := api.sessions.Revoke(sid); err != nil {
+		api.LogError(c, err, "failed to revoke session")(http.StatusInternalServerError)
+		return
+	}
+	// the signed out token is no longer active
+	if exp, ok := Claim(c, "exp").(float64); ok && api.tokens != nil {
+		api.tokens.revoke(time.Unix(int64(exp), 0))
+	}
+	Respond(c, http.StatusOK, gin.H{"response": "signed out"})
+}
+
+// listSessions returns the active sessions of the authenticated user,
+// flagging the one the request was authenticated with
+func (api *API) listSessions(c *gin.Context) {
+	username, err := GetAuthenticatedUserFromContext(c)
+	if err != nil {
+		api.LogError(c, err, "failed to extract username")(http.StatusBadRequest)
+		return
+	}
+	sessions, err := api.sessions.ListByUser(username)
+	if err != nil {
+		api.LogError(c, err, "failed to list sessions")(http.StatusInternalServerError)
+		return
+	}
+	current, _ := Claim(c, "sid").(string)
+	list := make([]gin.H, 0, len(sessions))
+	for _, session := range sessions {
+		list = append(list, gin.H{
+			"id":      session.ID,
+			"created": session.Created,
+			"expires": session.Expires,
+			"current": session.ID == current,
+		})
+	}
+	Respond(c, http.StatusOK, gin.H{"response": list})
+}
+
+// revokeSession revokes one of the authenticated user's sessions,
+// such as one belonging to a lost device
+func (api *API) revokeSession(c *gin.Context) {
+	username, err := GetAuthenticatedUserFromContext(c)
+	if err != nil {
+		api.LogError(c, err, "failed to extract username")(http.StatusBadRequest)
+		return
+	}
+	session, err := api.sessions.Get(c.Param("id"))
+	// sessions of other users are reported as missing
+	if err != nil || session.User != username {
+		Fail(c, errors.New("session not found"), http.StatusNotFound)
+		return
+	}
+	if err := api.sessions.Revoke(session.ID); err != nil {
+		api.LogError(c, err, "failed to revoke session")(http.StatusInternalServerError)
+		return
+	}
+	Respond(c, http.StatusOK, gin.H{"response": "session revoked"})
+}
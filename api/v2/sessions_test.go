@@ -0,0 +1,135 @@
+package v2
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/RTradeLtd/Temporal/api/middleware"
+	"github.com/RTradeLtd/Temporal/utils"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap/zaptest"
+	jwt "gopkg.in/dgrijalva/jwt-go.v3"
+)
+
+func TestAPI_Sessions(t *testing.T) {
+	store := middleware.NewMemorySessionStore()
+	api := &API{l: zaptest.NewLogger(t).Sugar(), sessions: store, tokens: newTokenStats(time.Hour)}
+	issued := time.Now()
+	api.tokens.issue(issued)
+	current, err := middleware.StartSession(store, "testuser", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := middleware.StartSession(store, "testuser", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	foreign, err := middleware.StartSession(store, "otheruser", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	// stand in for the jwt middleware
+	r.Use(func(c *gin.Context) {
+		c.Set("JWT_PAYLOAD", jwt.MapClaims{
+			"id": "testuser", "sid": current.ID, "exp": float64(issued.Add(time.Hour).Unix()),
+		})
+	})
+	r.GET("/sessions", api.listSessions)
+	r.DELETE("/sessions/:id", api.revokeSession)
+	r.POST("/logout", api.logout)
+	send := func(method, url string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest(method, url, nil))
+		return rec
+	}
+
+	rec := send("GET", "/sessions")
+	var list struct {
+		Response []struct {
+			ID      string `json:"id"`
+			Current bool   `json:"current"`
+		} `json:"response"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Response) != 2 {
+		t.Fatalf("unexpected session list %s", rec.Body.String())
+	}
+	for _, session := range list.Response {
+		if session.Current != (session.ID == current.ID) {
+			t.Fatalf("unexpected session list %s", rec.Body.String())
+		}
+	}
+	// sessions of other users can't be revoked
+	if rec := send("DELETE", "/sessions/"+foreign.ID); rec.Code != http.StatusNotFound {
+		t.Fatalf("got status %v revoking another user's session, want %v", rec.Code, http.StatusNotFound)
+	}
+	if _, err := store.Get(foreign.ID); err != nil {
+		t.Fatal("another user's session was revoked")
+	}
+	if rec := send("DELETE", "/sessions/"+other.ID); rec.Code != http.StatusOK {
+		t.Fatalf("got status %v revoking a session, want %v", rec.Code, http.StatusOK)
+	}
+	if _, err := store.Get(other.ID); err == nil {
+		t.Fatal("session was not revoked")
+	}
+	// logging out revokes the current session
+	if rec := send("POST", "/logout"); rec.Code != http.StatusOK {
+		t.Fatalf("got status %v logging out, want %v", rec.Code, http.StatusOK)
+	}
+	if _, err := store.Get(current.ID); err == nil {
+		t.Fatal("current session was not revoked by logout")
+	}
+	if report := api.tokens.report(time.Now()); report["active"] != 0 {
+		t.Fatalf("signed out token is still counted as active %v", report)
+	}
+}
+
+func TestAPI_SignOutEverywhere(t *testing.T) {
+	api := newTestAPI(t)
+	randUser := utils.GenerateRandomUtils().GenerateString(32, utils.LetterBytes)
+	registerTestUser(t, api, randUser)
+	verifyTestUser(t, api, randUser)
+	login := func() string {
+		return loginTestUser(t, api, randUser, testUserPassword)
+	}
+	send := func(method, path, token string, forms url.Values, wantCode int) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Add("Authorization", "Bearer "+token)
+		req.PostForm = forms
+		api.r.ServeHTTP(rec, req)
+		if rec.Code != wantCode {
+			t.Fatalf("bad status code from %s, got %v, want %v", path, rec.Code, wantCode)
+		}
+		return rec
+	}
+	current, other := login(), login()
+	send("GET", "/v2/account/token/username", other, nil, http.StatusOK)
+	rec := send("POST", "/v2/auth/logout/everywhere", current, url.Values{"reissue": {"true"}}, http.StatusOK)
+	var resp struct {
+		Token string `json:"token"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Token == "" {
+		t.Fatal("expected a fresh token to be issued")
+	}
+	// every earlier token is invalidated, and can't be refreshed
+	send("GET", "/v2/account/token/username", current, nil, http.StatusUnauthorized)
+	send("GET", "/v2/account/token/username", other, nil, http.StatusUnauthorized)
+	send("GET", "/v2/auth/refresh", other, nil, http.StatusUnauthorized)
+	// while the fresh token remains valid
+	send("GET", "/v2/account/token/username", resp.Token, nil, http.StatusOK)
+	// as do tokens issued by signing in again
+	time.Sleep(time.Until(time.Now().Truncate(time.Second).Add(time.Second)))
+	send("GET", "/v2/account/token/username", login(), nil, http.StatusOK)
+}
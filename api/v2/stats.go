@@ -2,24 +2,19 @@ package v2
 
 import (
 	"net/http"
+	"time"
 
-	"github.com/RTradeLtd/Temporal/eh"
 	"github.com/gin-gonic/gin"
 	stats "github.com/semihalev/gin-stats"
 )
 
+// getStats returns request, token and email statistics, it is only available to administrators
 func (api *API) getStats(c *gin.Context) {
-	username, err := GetAuthenticatedUserFromContext(c)
-	if err != nil {
-		api.LogError(c, err, eh.NoAPITokenError)(http.StatusBadRequest)
-		return
-	}
-	if err := api.validateAdminRequest(username); err != nil {
-		FailNotAuthorized(c, eh.UnAuthorizedAdminAccess)
-		return
-	}
 	c.JSON(http.StatusOK, gin.H{
-		"version":  api.version,
-		"response": stats.Report(),
+		"version":       api.version,
+		"response":      stats.Report(),
+		"tokens":        api.tokens.report(time.Now()),
+		"registrations": api.funnel.report(),
+		"emails":        api.emails.report(),
 	})
 }
@@ -0,0 +1,159 @@
+package v2
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/RTradeLtd/Temporal/api/middleware"
+	"github.com/RTradeLtd/database/v2/models"
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+)
+
+// tierInfo describes the policies applied to accounts within a usage tier
+type tierInfo struct {
+	// MaxHoldTimeMonths is the longest an upload can be pinned for
+	MaxHoldTimeMonths int64 `json:"max_hold_time_months"`
+	// UpgradeTo is the tier accounts can upgrade themselves to,
+	// an empty value indicates that no self upgrade is possible
+	UpgradeTo models.DataUsageTier `json:"upgrade_to,omitempty"`
+	// MaxConcurrentRequests is the number of requests an account can have
+	// in flight at once, when Options.LimitConcurrentRequests is set
+	MaxConcurrentRequests int `json:"max_concurrent_requests"`
+	// DataLimitBytes is the amount of data that can be uploaded each month.
+	// It and the following limits are assigned by the database module, and
+	// are read from it by withLimits
+	DataLimitBytes int64 `json:"data_limit_bytes"`
+	// KeysAllowed is the number of keys that can be created
+	KeysAllowed int64 `json:"keys_allowed"`
+	// PubSubMessagesAllowed is the number of pubsub messages that can be sent
+	PubSubMessagesAllowed int64 `json:"pubsub_messages_allowed"`
+	// IPNSRecordsAllowed is the number of IPNS records that can be published
+	IPNSRecordsAllowed int64 `json:"ipns_records_allowed"`
+	// CreditGrant is the amount of credits granted when moving into the tier
+	CreditGrant float64 `json:"credit_grant"`
+}
+
+// tierRegistry is used to look up the policies of each usage tier,
+// so that adding a tier doesn't involve updating multiple switches
+type tierRegistry map[models.DataUsageTier]tierInfo
+
+// defaultTiers returns the registry of our standard usage tiers
+func defaultTiers() tierRegistry {
+	return tierRegistry{
+		models.Unverified:   {MaxHoldTimeMonths: 12, MaxConcurrentRequests: 2},
+		models.Free:         {MaxHoldTimeMonths: 12, MaxConcurrentRequests: 5, UpgradeTo: models.Paid},
+		models.Paid:         {MaxHoldTimeMonths: 24, MaxConcurrentRequests: 20},
+		models.Partner:      {MaxHoldTimeMonths: 24, MaxConcurrentRequests: 50},
+		models.WhiteLabeled: {MaxHoldTimeMonths: 24, MaxConcurrentRequests: 50},
+	}
+}
+
+// validateVerifiedTier ensures that tier can be granted to verified accounts
+func (tr tierRegistry) validateVerifiedTier(tier models.DataUsageTier) error {
+	if _, ok := tr[tier]; !ok {
+		return fmt.Errorf("verified tier %s is not a known usage tier", tier)
+	}
+	if tier == models.Unverified {
+		return errors.New("verified tier must not be the unverified tier")
+	}
+	return nil
+}
+
+// get returns the policies for tier. If the tier is not registered the
+// policies of the unverified tier are returned, along with false
+func (tr tierRegistry) get(tier models.DataUsageTier) (tierInfo, bool) {
+	if info, ok := tr[tier]; ok {
+		return info, true
+	}
+	return tr[models.Unverified], false
+}
+
+// tierLimitsProbe is the account withLimits reads tier limits through. It is
+// never committed, and isn't a valid username so it can't clash with a user
+const tierLimitsProbe = "tier limits probe"
+
+// withLimits returns a copy of the registry with the limits the database module
+// assigns to each tier. Rather than copying them, they are read back from a
+// probe account which is registered and moved through each tier within a
+// transaction that is rolled back, so they can't drift from what is enforced
+func (tr tierRegistry) withLimits(db *gorm.DB) (tierRegistry, error) {
+	tx := db.Begin()
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	defer tx.Rollback()
+	if _, err := models.NewUserManager(tx).NewUserAccount(
+		tierLimitsProbe, tierLimitsProbe, "tier-limits-probe@example.org",
+	); err != nil {
+		return nil, err
+	}
+	usage := models.NewUsageManager(tx)
+	limited := make(tierRegistry, len(tr))
+	// new accounts start out unverified, so those limits are read before
+	// moving the probe between tiers
+	for _, tier := range append([]models.DataUsageTier{models.Unverified}, tr.tiers()...) {
+		info, ok := tr[tier]
+		if !ok {
+			continue
+		}
+		if tier != models.Unverified {
+			if err := usage.UpdateTier(tierLimitsProbe, tier); err != nil {
+				return nil, err
+			}
+		}
+		probe, err := usage.FindByUserName(tierLimitsProbe)
+		if err != nil {
+			return nil, err
+		}
+		info.DataLimitBytes = int64(probe.MonthlyDataLimitBytes)
+		info.KeysAllowed = probe.KeysAllowed
+		info.PubSubMessagesAllowed = probe.PubSubMessagesAllowed
+		info.IPNSRecordsAllowed = probe.IPNSRecordsAllowed
+		limited[tier] = info
+	}
+	return limited, nil
+}
+
+// tiers returns every registered tier other than the unverified tier
+func (tr tierRegistry) tiers() []models.DataUsageTier {
+	var tiers []models.DataUsageTier
+	for tier := range tr {
+		if tier != models.Unverified {
+			tiers = append(tiers, tier)
+		}
+	}
+	return tiers
+}
+
+// setTier moves username into tier, which applies its limits, and grants
+// the credits registered for it so that they match what getTiers reports
+func (api *API) setTier(username string, tier models.DataUsageTier) error {
+	if err := api.usage.UpdateTier(username, tier); err != nil {
+		return err
+	}
+	if info, _ := api.tiers.get(tier); info.CreditGrant > 0 {
+		if _, err := api.um.AddCredits(username, info.CreditGrant); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// tokenTTLBounds returns the token lifetimes a user signing in as userID may
+// select, falling back to the unverified tier for unknown users
+func (api *API) tokenTTLBounds(userID string) (middleware.TTLBounds, bool) {
+	tier := models.Unverified
+	if usage, err := api.usage.FindByUserName(api.loginUserName(userID)); err == nil {
+		tier = usage.Tier
+	}
+	bounds, ok := api.opts.TokenTTLs[tier]
+	return bounds, ok
+}
+
+// getTiers is used to return the policies of each usage tier, so that clients
+// such as pricing pages display the same values that we enforce
+func (api *API) getTiers(c *gin.Context) {
+	Respond(c, http.StatusOK, gin.H{"response": api.tiers})
+}
@@ -0,0 +1,128 @@
+package v2
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/RTradeLtd/Temporal/utils"
+	"github.com/RTradeLtd/database/v2/models"
+	"github.com/gin-gonic/gin"
+)
+
+func Test_tierRegistry(t *testing.T) {
+	gold := models.DataUsageTier("gold")
+	tiers := defaultTiers()
+	tiers[gold] = tierInfo{MaxHoldTimeMonths: 36}
+	api := &API{tiers: tiers}
+	tests := []struct {
+		name     string
+		tier     models.DataUsageTier
+		holdTime int64
+		wantErr  bool
+	}{
+		{"Free-Within-Limit", models.Free, 11, false},
+		{"Free-Over-Limit", models.Free, 13, true},
+		{"Paid-Within-Limit", models.Paid, 23, false},
+		{"Paid-Over-Limit", models.Paid, 25, true},
+		// registering a tier is enough for it to be enforced
+		{"Gold-Within-Limit", gold, 35, false},
+		{"Gold-Over-Limit", gold, 37, true},
+		{"Unknown-Tier", models.DataUsageTier("thetierisalie"), 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upload := &models.Upload{GarbageCollectDate: time.Now()}
+			if err := api.ensureLEMaxPinTime(upload, tt.holdTime, tt.tier); (err != nil) != tt.wantErr {
+				t.Fatalf("ensureLEMaxPinTime() err = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+	// unknown tiers fall back to the unverified policies
+	if info, ok := tiers.get(models.DataUsageTier("thetierisalie")); ok {
+		t.Fatal("unknown tier should not be found")
+	} else if info != tiers[models.Unverified] {
+		t.Fatal("unknown tier should use unverified policies")
+	}
+	if info, _ := tiers.get(models.Free); info.UpgradeTo != models.Paid {
+		t.Fatal("free tier should upgrade to paid")
+	}
+}
+
+func Test_tierRegistry_withLimits(t *testing.T) {
+	api := newTestAPI(t)
+	// every tier reports the limits the database module applies to its accounts
+	for tier, info := range api.tiers {
+		if info.DataLimitBytes == 0 || info.KeysAllowed == 0 ||
+			info.PubSubMessagesAllowed == 0 || info.IPNSRecordsAllowed == 0 {
+			t.Fatalf("tier %s is missing limits %+v", tier, info)
+		}
+	}
+	// new accounts are given the limits of the unverified tier, without them
+	// being overwritten by the api
+	randUser := utils.GenerateRandomUtils().GenerateString(32, utils.LetterBytes)
+	registerTestUser(t, api, randUser)
+	usage, err := api.usage.FindByUserName(randUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info := api.tiers[models.Unverified]; int64(usage.MonthlyDataLimitBytes) != info.DataLimitBytes ||
+		usage.KeysAllowed != info.KeysAllowed {
+		t.Fatalf("unverified tier limits %+v don't match those of new accounts %+v", info, usage)
+	}
+	// the probe account is never committed
+	if _, err := api.um.FindByUserName(tierLimitsProbe); err == nil {
+		t.Fatal("tier limits probe account should not exist")
+	}
+}
+
+func TestAPI_verifiedTier(t *testing.T) {
+	tests := []struct {
+		name     string
+		tier     models.DataUsageTier
+		wantTier models.DataUsageTier
+		wantErr  bool
+	}{
+		{"Default", "", models.Free, false},
+		{"Paid", models.Paid, models.Paid, false},
+		{"Unverified", models.Unverified, models.Unverified, true},
+		{"Unknown", models.DataUsageTier("thetierisalie"), models.DataUsageTier("thetierisalie"), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := &API{opts: Options{VerifiedTier: tt.tier}, tiers: defaultTiers()}
+			if got := api.verifiedTier(); got != tt.wantTier {
+				t.Fatalf("verifiedTier() = %v, want %v", got, tt.wantTier)
+			}
+			if err := api.tiers.validateVerifiedTier(api.verifiedTier()); (err != nil) != tt.wantErr {
+				t.Fatalf("validateVerifiedTier() err = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAPI_getTiers(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	api := &API{tiers: defaultTiers()}
+	r := gin.New()
+	r.GET("/tiers", api.getTiers)
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest("GET", "/tiers", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %v, got %v", http.StatusOK, rec.Code)
+	}
+	var resp struct {
+		Code     int          `json:"code"`
+		Response tierRegistry `json:"response"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	// the returned policies must be exactly those we enforce
+	if !reflect.DeepEqual(resp.Response, api.tiers) {
+		t.Fatalf("getTiers() = %+v, want %+v", resp.Response, api.tiers)
+	}
+}
@@ -0,0 +1,113 @@
+package v2
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// tokenStats keeps track of the api tokens we issue. Tokens are stateless,
+// so the number of active tokens is approximated by counting the tokens
+// issued within the token lifetime, less those which were signed out
+type tokenStats struct {
+	mux      sync.Mutex
+	lifetime time.Duration
+	issued   int64
+	// issue times of tokens which may still be active, oldest first
+	active []time.Time
+}
+
+func newTokenStats(lifetime time.Duration) *tokenStats {
+	return &tokenStats{lifetime: lifetime}
+}
+
+// track wraps a handler that issues tokens, such as login
+// or refresh, recording every token it successfully issues
+func (ts *tokenStats) track(handler gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		w := &issueWriter{ResponseWriter: c.Writer}
+		c.Writer = w
+		handler(c)
+		c.Writer = w.ResponseWriter
+		if c.Writer.Status() != http.StatusOK {
+			return
+		}
+		// the issue time is derived from the expiry in the response, the
+		// same way revoke derives it from the expiry claim, so they match
+		var issued struct {
+			Expire time.Time `json:"expire"`
+		}
+		if err := json.Unmarshal(w.body.Bytes(), &issued); err != nil || issued.Expire.IsZero() {
+			ts.issue(time.Now())
+			return
+		}
+		ts.issue(issued.Expire.Add(-ts.lifetime))
+	}
+}
+
+// issueWriter keeps a copy of the response body,
+// from which the expiry of the issued token is read
+type issueWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *issueWriter) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *issueWriter) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+func (ts *tokenStats) issue(at time.Time) {
+	ts.mux.Lock()
+	defer ts.mux.Unlock()
+	ts.prune(at)
+	ts.issued++
+	ts.active = append(ts.active, at)
+}
+
+// revoke stops counting the token expiring at expires as active, such as when
+// its session is signed out. Tokens only carry their expiry to the second, so
+// a token issued within the same second is removed, and nothing is removed if
+// there is none, such as when the token was issued before a restart
+func (ts *tokenStats) revoke(expires time.Time) {
+	ts.mux.Lock()
+	defer ts.mux.Unlock()
+	issuedAt := expires.Add(-ts.lifetime).Unix()
+	for i, at := range ts.active {
+		if at.Unix() == issuedAt {
+			ts.active = append(ts.active[:i], ts.active[i+1:]...)
+			return
+		}
+	}
+}
+
+// prune drops tokens which have expired by now, so that
+// tracking doesn't grow without bound between reports
+func (ts *tokenStats) prune(now time.Time) {
+	var expired int
+	for expired < len(ts.active) && now.Sub(ts.active[expired]) >= ts.lifetime {
+		expired++
+	}
+	ts.active = ts.active[expired:]
+}
+
+// report returns the total number of tokens
+// issued, and the number which haven't expired
+func (ts *tokenStats) report(now time.Time) gin.H {
+	ts.mux.Lock()
+	defer ts.mux.Unlock()
+	ts.prune(now)
+	return gin.H{
+		"issued": ts.issued,
+		"active": len(ts.active),
+	}
+}
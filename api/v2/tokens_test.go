@@ -0,0 +1,84 @@
+package v2
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func Test_tokenStats(t *testing.T) {
+	ts := newTokenStats(time.Hour)
+	_, engine := gin.CreateTestContext(httptest.NewRecorder())
+	engine.POST("/login", ts.track(func(c *gin.Context) {
+		if c.Query("fail") != "" {
+			c.JSON(http.StatusUnauthorized, gin.H{})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"token": "sometoken"})
+	}))
+	for _, target := range []string{"/login", "/login", "/login?fail=true"} {
+		engine.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", target, nil))
+	}
+	report := ts.report(time.Now())
+	if report["issued"] != int64(2) || report["active"] != 2 {
+		t.Fatalf("unexpected report after login %v", report)
+	}
+	// once the token lifetime passes they are no longer active
+	report = ts.report(time.Now().Add(time.Hour))
+	if report["issued"] != int64(2) || report["active"] != 0 {
+		t.Fatalf("unexpected report after expiry %v", report)
+	}
+	// expired tokens are dropped as new ones are issued
+	now := time.Now()
+	ts.issue(now)
+	ts.issue(now.Add(time.Hour * 2))
+	if len(ts.active) != 1 {
+		t.Fatalf("got %v tracked tokens, want 1", len(ts.active))
+	}
+}
+
+func Test_tokenStats_revoke(t *testing.T) {
+	ts := newTokenStats(time.Hour)
+	first := time.Unix(time.Now().Unix(), 0)
+	second := first.Add(time.Second)
+	ts.issue(first)
+	ts.issue(second)
+	// signing out the later token leaves the earlier one active
+	ts.revoke(second.Add(time.Hour))
+	if len(ts.active) != 1 || !ts.active[0].Equal(first) {
+		t.Fatalf("unexpected tokens after revoking the later token %v", ts.active)
+	}
+	// tokens which aren't tracked leave the others alone
+	ts.revoke(second.Add(time.Hour))
+	ts.revoke(first.Add(-time.Second).Add(time.Hour))
+	if len(ts.active) != 1 {
+		t.Fatalf("got %v tracked tokens, want 1", len(ts.active))
+	}
+	ts.revoke(first.Add(time.Hour))
+	if len(ts.active) != 0 {
+		t.Fatalf("got %v tracked tokens, want 0", len(ts.active))
+	}
+}
+
+func Test_tokenStats_track_expiry(t *testing.T) {
+	ts := newTokenStats(time.Hour)
+	expire := time.Now().Add(2 * time.Hour).Truncate(time.Second)
+	_, engine := gin.CreateTestContext(httptest.NewRecorder())
+	engine.POST("/login", ts.track(func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"token": "sometoken", "expire": expire.Format(time.RFC3339)})
+	}))
+	rec := httptest.NewRecorder()
+	engine.ServeHTTP(rec, httptest.NewRequest("POST", "/login", nil))
+	if !bytes.Contains(rec.Body.Bytes(), []byte("sometoken")) {
+		t.Fatal("expected the response to be passed through")
+	}
+	// the token is matched by the expiry it was issued with
+	ts.revoke(expire)
+	if len(ts.active) != 0 {
+		t.Fatalf("got %v tracked tokens, want 0", len(ts.active))
+	}
+}
@@ -1,9 +1,15 @@
 package v2
 
 import (
+	"time"
+
+	"github.com/RTradeLtd/Temporal/api/middleware"
 	"github.com/RTradeLtd/Temporal/queue"
+	"github.com/RTradeLtd/database/v2/models"
 	"github.com/RTradeLtd/kaas/v2"
 	xss "github.com/dvwright/xss-mw"
+	recaptcha "github.com/ezzarghili/recaptcha-go"
+	"github.com/ulule/limiter/v3"
 
 	pbLens "github.com/RTradeLtd/grpc/lensv2"
 	pbOrch "github.com/RTradeLtd/grpc/nexus"
@@ -23,6 +29,169 @@ var (
 type Options struct {
 	DebugLogging bool
 	DevMode      bool
+	// EmailPublishAttempts is the number of times we will try to publish
+	// an email message to the queue before failing. Defaults to 3
+	EmailPublishAttempts int
+	// EmailPublishDelay is the initial delay between publish attempts,
+	// which doubles after each failure. Defaults to 250ms
+	EmailPublishDelay time.Duration
+	// RegistrationCaptcha requires a valid captcha response when registering
+	// an account. It has no effect in dev mode
+	RegistrationCaptcha bool
+	// EmailFromName, EmailFromAddress and EmailReplyTo set the sender details of
+	// outbound emails. When empty, the mail service defaults are used
+	EmailFromName    string
+	EmailFromAddress string
+	EmailReplyTo     string
+	// CookieSessions additionally issues login tokens as HttpOnly cookies
+	// for browser clients, protected by a double-submit csrf token
+	CookieSessions bool
+	// MaxUsernameLength, MaxEmailLength and MaxPasswordLength limit the size
+	// of registration fields. Defaults to 64, 254 and 72 respectively
+	MaxUsernameLength int
+	MaxEmailLength    int
+	MaxPasswordLength int
+	// ReservedUsernames can't be registered, regardless of case. Entries ending
+	// with * reserve every username with that prefix, and entries wrapped in
+	// slashes, such as /^admin[0-9]*$/, are regular expressions. Defaults to
+	// reserving admin, administrator, root, support and temporal, while an
+	// empty list reserves nothing
+	ReservedUsernames []string
+	// VerifiedTier is the usage tier accounts are placed in once their email
+	// address is verified, allowing promotions for new signups. Defaults to free
+	VerifiedTier models.DataUsageTier
+	// SkipEmailVerification enables accounts as soon as they are registered,
+	// without sending a verification email. Intended for private deployments
+	SkipEmailVerification bool
+	// PasswordHashCost is the bcrypt cost password hashes are upgraded
+	// to when users sign in. Disabled when 0
+	PasswordHashCost int
+	// OpaqueVerificationCodes uses short hmac signed codes in email
+	// verification links instead of jwts
+	OpaqueVerificationCodes bool
+	// NumericVerificationCodes sends a six digit code to be entered by the
+	// user instead of a verification link, for use by mobile clients
+	NumericVerificationCodes bool
+	// BindVerificationEmail binds verification links and codes to the email
+	// address they were sent to, rejecting them if the address has changed
+	BindVerificationEmail bool
+	// CompressResponses gzip compresses large account responses
+	// for clients which send an appropriate Accept-Encoding header
+	CompressResponses bool
+	// ClaimsAugmenter is used to embed additional claims within issued tokens,
+	// which are available to handlers through the Claim function
+	ClaimsAugmenter middleware.ClaimsAugmenter
+	// RateLimitStore holds the state of our rate limiters. Setting this to a
+	// shared store, such as redis, enforces limits across every api instance.
+	// Defaults to an in-memory store
+	RateLimitStore limiter.Store
+	// RefreshGracePeriod allows tokens which expired within the period to be
+	// refreshed, while every other route rejects them. Defaults to 0
+	RefreshGracePeriod time.Duration
+	// FoldUsernames compares usernames case-insensitively at registration and
+	// sign in, so that accounts such as Alice and alice can't both be registered
+	FoldUsernames bool
+	// AdminAlertEmail is notified when suspicious events cross their
+	// thresholds within an hour. Thresholds of 0 disable alerts
+	AdminAlertEmail            string
+	FailedLoginAlertThreshold  int64
+	RegistrationAlertThreshold int64
+	// LimitConcurrentRequests limits the number of requests each user can
+	// have in flight at once, based on their usage tier
+	LimitConcurrentRequests bool
+	// MaxAccountsPerDomain limits the number of accounts registered with
+	// email addresses of a single domain within DomainRegistrationWindow,
+	// which defaults to a day. Domains within DomainAllowlist are exempt,
+	// and 0 disables the limit
+	MaxAccountsPerDomain     int
+	DomainRegistrationWindow time.Duration
+	DomainAllowlist          []string
+	// TokenAudiences are the client types, such as web, mobile and cli, which
+	// may be selected when signing in. Tokens are issued with the selected
+	// client type as their audience
+	TokenAudiences []string
+	// RouteAudiences restricts routes, keyed by their full path such as
+	// /v2/account/key/export/:name, to tokens issued to the given audiences
+	RouteAudiences map[string][]string
+	// MaxRegistrationsPerIP limits the number of accounts registered from a
+	// single ip address within IPRegistrationWindow, which defaults to a day.
+	// Addresses within IPRegistrationAllowlist, given as ips or cidr ranges,
+	// are exempt, and 0 disables the limit
+	MaxRegistrationsPerIP   int
+	IPRegistrationWindow    time.Duration
+	IPRegistrationAllowlist []string
+	// CaptchaRepeatRegistrations requires a captcha response when registering
+	// from an ip address which has already registered an account within the
+	// window, even if RegistrationCaptcha is unset
+	CaptchaRepeatRegistrations bool
+	// ServiceKey authenticates trusted services, such as api gateways, which
+	// submit it in the X-Service-Key header. Routes for trusted services,
+	// like token introspection, are disabled when it is empty
+	ServiceKey string
+	// NormalizeEmails trims surrounding whitespace from email addresses and
+	// lowercases their domain before they are stored or looked up. When
+	// LowercaseEmailLocalPart is also set the whole address is lowercased,
+	// treating addresses which only differ by case as the same account
+	NormalizeEmails         bool
+	LowercaseEmailLocalPart bool
+	// MinimalTokens issues tokens which only carry the claims required to
+	// validate them, such as id and exp, referencing the rest through a jti
+	// claim. The remaining claims are held in ClaimsStore, which defaults to
+	// memory, meaning tokens can only be used with the instance that issued them
+	MinimalTokens bool
+	ClaimsStore   middleware.ClaimsStore
+	// StatefulSessions tracks every sign in as a session within SessionStore,
+	// referenced by the sid claim of its tokens, so that sessions can be listed
+	// and revoked before their tokens expire. SessionStore defaults to memory,
+	// meaning tokens can only be used with the instance that issued them
+	StatefulSessions bool
+	SessionStore     middleware.SessionStore
+	// MagicLinkURL enables passwordless sign in through single use links
+	// emailed to the user. It is the page the links point to, which receives
+	// the username and token query parameters and redeems them with the api
+	MagicLinkURL string
+	// MaxKeysPerResponse caps the number of keys returned when listing the
+	// keys of an account, with the rest available through the paginated
+	// key list route. 0 returns every key
+	MaxKeysPerResponse int
+	// ReportUnverifiedLogins fails sign in attempts to accounts which haven't
+	// verified their email address with a 412 "email not verified" error,
+	// instead of the generic failed login response. Tokens are never issued
+	// to unverified accounts either way
+	ReportUnverifiedLogins bool
+	// VerificationWebhookURL is posted a json payload whenever an account
+	// verifies its email address. The X-Temporal-Signature header holds the
+	// hmac-sha256 of the X-Temporal-Timestamp header and the body, joined by
+	// a period, keyed with VerificationWebhookSecret. Receivers should reject
+	// webhooks signed outside of WebhookTolerance, see VerifyWebhookSignature.
+	// Delivery is retried, and failures don't affect the verification. Empty
+	// disables the webhook
+	VerificationWebhookURL    string
+	VerificationWebhookSecret string
+	// VerificationSuccessURL and VerificationFailureURL redirect browsers
+	// following email verification links to the app, rather than responding
+	// with json. Either may be empty to keep the json response for that outcome
+	VerificationSuccessURL string
+	VerificationFailureURL string
+	// RetiringVerificationKeys are previous jwt signing keys, whose email
+	// verification links remain valid after the key is rotated. Links are
+	// valid for a day, so keys only need to be kept for a day after rotation
+	RetiringVerificationKeys []string
+	// TokenTTLs allows users to select the lifetime of their tokens through
+	// the ttl field of a login request, in seconds, within the bounds of their
+	// usage tier. Lifetimes outside of the bounds are rejected, unless
+	// ClampTokenTTLs is set to adjust them to the nearest bound. Users of tiers
+	// without bounds can't select a lifetime
+	TokenTTLs      map[models.DataUsageTier]middleware.TTLBounds
+	ClampTokenTTLs bool
+	// SignOutStore records when users signed out everywhere, invalidating
+	// their earlier tokens. It defaults to memory, meaning sign outs only
+	// apply to the instance they were made with
+	SignOutStore middleware.SignOutStore
+	// PasswordHistory is the number of an account's most recent passwords,
+	// including its current one, which can't be reused when changing or
+	// resetting its password. 0 disables the check
+	PasswordHistory int
 }
 
 // Clients is used to configure service clients we use
@@ -40,6 +209,11 @@ type CreditRefund struct {
 	Cost     float64
 }
 
+// captchaVerifier is used to validate captcha challenge responses
+type captchaVerifier interface {
+	VerifyWithOptions(challengeResponse string, options recaptcha.VerifyOption) error
+}
+
 type queues struct {
 	pin     *queue.Manager
 	cluster *queue.Manager
@@ -52,6 +226,20 @@ type queues struct {
 	ens     *queue.Manager
 }
 
+// rate limiters for actions that can't be covered by the global rate limit
+type limits struct {
+	recoveryEmail   *keyedLimiter
+	recoveryIP      *keyedLimiter
+	passwordChanged *keyedLimiter
+	// verificationAttempts guards against brute forcing numeric codes
+	verificationAttempts *keyedLimiter
+	// registrationIP is set up from Options.MaxRegistrationsPerIP,
+	// and is nil when registrations aren't limited by ip
+	registrationIP *keyedLimiter
+	// magicLinks ensures magic links are only used once
+	magicLinks *keyedLimiter
+}
+
 // kaas key managers
 type keys struct {
 	kb1 *kaas.Client
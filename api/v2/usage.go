@@ -0,0 +1,135 @@
+package v2
+
+import (
+	"errors"
+	"time"
+
+	"github.com/RTradeLtd/database/v2/models"
+	"github.com/jinzhu/gorm"
+)
+
+// QuotaKind identifies a resource whose consumption is limited by an account's usage tier
+type QuotaKind int
+
+const (
+	// QuotaData is the monthly data limit, measured in bytes
+	QuotaData QuotaKind = iota
+	// QuotaIPNSRecords is the number of IPNS records that can be published
+	QuotaIPNSRecords
+	// QuotaPubSubMessages is the number of pubsub messages that can be sent
+	QuotaPubSubMessages
+	// QuotaKeys is the number of keys that can be created
+	QuotaKeys
+)
+
+// QuotaChecker is used to check the remaining quota of an account
+type QuotaChecker struct {
+	usage *models.UsageManager
+}
+
+// NewQuotaChecker returns a QuotaChecker backed by the given usage manager
+func NewQuotaChecker(usage *models.UsageManager) *QuotaChecker {
+	return &QuotaChecker{usage: usage}
+}
+
+// CheckQuota is used to check whether or not user can consume amount of the given resource.
+// The amount of the resource remaining before amount is consumed is also returned
+func (qc *QuotaChecker) CheckQuota(user string, resource QuotaKind, amount int64) (allowed bool, remaining int64, err error) {
+	usage, err := qc.usage.FindByUserName(user)
+	if err != nil {
+		return false, 0, err
+	}
+	return checkQuota(usage, resource, amount)
+}
+
+// IncrementUsage atomically adds amount to the consumption of the given resource,
+// as long as doing so doesn't exceed the user's limit. The resulting consumption
+// is returned, along with whether or not the increment was rejected for exceeding
+// the limit. It is safe to call concurrently for the same user
+func (qc *QuotaChecker) IncrementUsage(user string, resource QuotaKind, amount int64) (used int64, exceeded bool, err error) {
+	usedColumn, limitColumn, err := quotaColumns(resource)
+	if err != nil {
+		return 0, false, err
+	}
+	// the limit check and increment happen within a single statement
+	// so that concurrent increments can't race past the limit
+	res := qc.usage.DB.Model(&models.Usage{}).
+		Where("user_name = ? AND "+usedColumn+" + ? <= "+limitColumn, user, amount).
+		UpdateColumn(usedColumn, gorm.Expr(usedColumn+" + ?", amount))
+	if res.Error != nil {
+		return 0, false, res.Error
+	}
+	usage, err := qc.usage.FindByUserName(user)
+	if err != nil {
+		return 0, false, err
+	}
+	_, used, err = quotaValues(usage, resource)
+	if err != nil {
+		return 0, false, err
+	}
+	return used, res.RowsAffected == 0, nil
+}
+
+// ResetMonthlyUsage zeroes the monthly data consumption of the given
+// accounts, or of every account if none are given. Limits are left untouched.
+// The number of accounts whose consumption was reset is returned
+func (qc *QuotaChecker) ResetMonthlyUsage(usernames ...string) (int64, error) {
+	query := qc.usage.DB.Model(&models.Usage{}).Where("current_data_used_bytes > 0")
+	if len(usernames) > 0 {
+		query = query.Where("user_name IN (?)", usernames)
+	}
+	res := query.UpdateColumn("current_data_used_bytes", 0)
+	return res.RowsAffected, res.Error
+}
+
+// NextUsageReset returns when monthly usage is next reset,
+// which is the start of the following month in UTC
+func NextUsageReset(now time.Time) time.Time {
+	now = now.UTC()
+	return time.Date(now.Year(), now.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+}
+
+// quotaColumns returns the database columns tracking consumption and limit of a resource
+func quotaColumns(resource QuotaKind) (used, limit string, err error) {
+	switch resource {
+	case QuotaData:
+		return "current_data_used_bytes", "monthly_data_limit_bytes", nil
+	case QuotaIPNSRecords:
+		return "ipns_records_published", "ipns_records_allowed", nil
+	case QuotaPubSubMessages:
+		return "pub_sub_messages_sent", "pub_sub_messages_allowed", nil
+	case QuotaKeys:
+		return "keys_created", "keys_allowed", nil
+	default:
+		return "", "", errors.New("unknown quota kind")
+	}
+}
+
+// checkQuota performs the quota calculation against an already retrieved usage model
+func checkQuota(usage *models.Usage, resource QuotaKind, amount int64) (bool, int64, error) {
+	limit, used, err := quotaValues(usage, resource)
+	if err != nil {
+		return false, 0, err
+	}
+	remaining := limit - used
+	if remaining < 0 {
+		remaining = 0
+	}
+	return amount <= remaining, remaining, nil
+}
+
+// quotaValues returns the limit and consumption of a resource from a usage model
+func quotaValues(usage *models.Usage, resource QuotaKind) (limit, used int64, err error) {
+	switch resource {
+	case QuotaData:
+		return int64(usage.MonthlyDataLimitBytes), int64(usage.CurrentDataUsedBytes), nil
+	case QuotaIPNSRecords:
+		return usage.IPNSRecordsAllowed, usage.IPNSRecordsPublished, nil
+	case QuotaPubSubMessages:
+		return usage.PubSubMessagesAllowed, usage.PubSubMessagesSent, nil
+	case QuotaKeys:
+		return usage.KeysAllowed, usage.KeysCreated, nil
+	default:
+		return 0, 0, errors.New("unknown quota kind")
+	}
+}
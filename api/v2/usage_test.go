@@ -0,0 +1,172 @@
+package v2
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/RTradeLtd/config/v2"
+	"github.com/RTradeLtd/database/v2/models"
+)
+
+func Test_checkQuota(t *testing.T) {
+	usage := &models.Usage{
+		MonthlyDataLimitBytes: 100,
+		CurrentDataUsedBytes:  60,
+		IPNSRecordsAllowed:    10,
+		IPNSRecordsPublished:  6,
+		PubSubMessagesAllowed: 10,
+		PubSubMessagesSent:    6,
+		KeysAllowed:           10,
+		KeysCreated:           6,
+	}
+	type args struct {
+		resource QuotaKind
+		amount   int64
+	}
+	tests := []struct {
+		name          string
+		args          args
+		wantAllowed   bool
+		wantRemaining int64
+		wantErr       bool
+	}{
+		{"Data-Within-Limit", args{QuotaData, 10}, true, 40, false},
+		{"Data-At-Limit", args{QuotaData, 40}, true, 40, false},
+		{"Data-Over-Limit", args{QuotaData, 41}, false, 40, false},
+		{"IPNS-Within-Limit", args{QuotaIPNSRecords, 1}, true, 4, false},
+		{"IPNS-At-Limit", args{QuotaIPNSRecords, 4}, true, 4, false},
+		{"IPNS-Over-Limit", args{QuotaIPNSRecords, 5}, false, 4, false},
+		{"PubSub-Within-Limit", args{QuotaPubSubMessages, 1}, true, 4, false},
+		{"PubSub-At-Limit", args{QuotaPubSubMessages, 4}, true, 4, false},
+		{"PubSub-Over-Limit", args{QuotaPubSubMessages, 5}, false, 4, false},
+		{"Keys-Within-Limit", args{QuotaKeys, 1}, true, 4, false},
+		{"Keys-At-Limit", args{QuotaKeys, 4}, true, 4, false},
+		{"Keys-Over-Limit", args{QuotaKeys, 5}, false, 4, false},
+		{"Unknown-Kind", args{QuotaKind(100), 1}, false, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			allowed, remaining, err := checkQuota(usage, tt.args.resource, tt.args.amount)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("checkQuota() err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if allowed != tt.wantAllowed {
+				t.Fatalf("checkQuota() allowed = %v, want %v", allowed, tt.wantAllowed)
+			}
+			if remaining != tt.wantRemaining {
+				t.Fatalf("checkQuota() remaining = %v, want %v", remaining, tt.wantRemaining)
+			}
+		})
+	}
+	// an account that has exceeded its limit has nothing remaining
+	if allowed, remaining, err := checkQuota(&models.Usage{KeysAllowed: 1, KeysCreated: 2}, QuotaKeys, 0); err != nil {
+		t.Fatal(err)
+	} else if !allowed || remaining != 0 {
+		t.Fatalf("unexpected result allowed = %v, remaining = %v", allowed, remaining)
+	}
+}
+
+func TestQuotaChecker_IncrementUsage(t *testing.T) {
+	cfg, err := config.LoadConfig("../../testenv/config.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := loadDatabase(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	usage := models.NewUsageManager(db)
+	qc := NewQuotaChecker(usage)
+	before, err := usage.FindByUserName("testuser")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer usage.ReduceDataUsage("testuser", uint64(50))
+	// increment concurrently, the total must be exact
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, exceeded, err := qc.IncrementUsage("testuser", QuotaData, 1); err != nil {
+				t.Error(err)
+			} else if exceeded {
+				t.Error("increment should not have exceeded limit")
+			}
+		}()
+	}
+	wg.Wait()
+	after, err := usage.FindByUserName("testuser")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.CurrentDataUsedBytes != before.CurrentDataUsedBytes+50 {
+		t.Fatalf("got %v bytes used, want %v", after.CurrentDataUsedBytes, before.CurrentDataUsedBytes+50)
+	}
+	// increments past the limit are rejected and leave usage untouched
+	used, exceeded, err := qc.IncrementUsage("testuser", QuotaData, int64(after.MonthlyDataLimitBytes))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !exceeded {
+		t.Fatal("increment should have exceeded limit")
+	}
+	if used != int64(after.CurrentDataUsedBytes) {
+		t.Fatalf("got %v bytes used, want %v", used, after.CurrentDataUsedBytes)
+	}
+}
+
+func TestNextUsageReset(t *testing.T) {
+	tests := []struct {
+		name string
+		now  time.Time
+		want time.Time
+	}{
+		{"Mid-Month", time.Date(2020, 3, 15, 12, 0, 0, 0, time.UTC), time.Date(2020, 4, 1, 0, 0, 0, 0, time.UTC)},
+		{"First-Of-Month", time.Date(2020, 3, 1, 0, 0, 0, 0, time.UTC), time.Date(2020, 4, 1, 0, 0, 0, 0, time.UTC)},
+		{"December", time.Date(2020, 12, 31, 23, 59, 0, 0, time.UTC), time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"Non-UTC", time.Date(2020, 3, 31, 22, 0, 0, 0, time.FixedZone("UTC-4", -4*60*60)), time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := NextUsageReset(tt.now); !got.Equal(tt.want) {
+				t.Errorf("NextUsageReset() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQuotaChecker_ResetMonthlyUsage(t *testing.T) {
+	cfg, err := config.LoadConfig("../../testenv/config.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := loadDatabase(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	usage := models.NewUsageManager(db)
+	before, err := usage.FindByUserName("testuser")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// restore the previous usage once done
+	defer usage.UpdateDataUsage("testuser", before.CurrentDataUsedBytes)
+	if err := usage.UpdateDataUsage("testuser", 100); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewQuotaChecker(usage).ResetMonthlyUsage("testuser"); err != nil {
+		t.Fatal(err)
+	}
+	after, err := usage.FindByUserName("testuser")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.CurrentDataUsedBytes != 0 {
+		t.Fatalf("got %v bytes used after reset", after.CurrentDataUsedBytes)
+	}
+	if after.MonthlyDataLimitBytes != before.MonthlyDataLimitBytes {
+		t.Fatal("reset should not change limits")
+	}
+}
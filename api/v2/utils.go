@@ -8,6 +8,7 @@ import (
 	"net/http"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/RTradeLtd/Temporal/eh"
@@ -104,29 +105,45 @@ func (api *API) FileSizeCheck(size int64) error {
 }
 
 // generateEmailJWTToken is used to generate a jwt token used to validate emails
-func (api *API) generateEmailJWTToken(username, verificationString string) (string, error) {
-	// generate a jwt with claims to verify email
-	verificationJWT := jwt.NewWithClaims(jwt.SigningMethodHS512, jwt.MapClaims{
+func (api *API) generateEmailJWTToken(username, verificationString, email string) (string, error) {
+	claims := jwt.MapClaims{
 		"user":                    username,
 		"emailVerificationString": verificationString,
 		"expire":                  time.Now().Add(time.Hour * 24).UTC().String(),
-	})
+	}
+	if api.opts.BindVerificationEmail {
+		// bind the token to the address it was sent to
+		claims["email"] = strings.ToLower(email)
+	}
+	// generate a jwt with claims to verify email
+	verificationJWT := jwt.NewWithClaims(jwt.SigningMethodHS512, claims)
+	// identify the key, so that links outlive its rotation
+	verificationJWT.Header["kid"] = verificationKeyID(api.jwtKey.Current())
 	// return a signed version of the jwt
-	return verificationJWT.SignedString([]byte(api.cfg.API.JWT.Key))
+	return verificationJWT.SignedString([]byte(api.jwtKey.Current()))
+}
+
+// emailJWTKey returns the key an email verification jwt was signed with,
+// resolved from its kid header
+func (api *API) emailJWTKey(token *jwt.Token) (interface{}, error) {
+	// Don't forget to validate the alg is what you expect:
+	if method, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+		return nil, fmt.Errorf("unable to validate signing method: %v", token.Header["alg"])
+	} else if method != jwt.SigningMethodHS512 {
+		return nil, errors.New("expect hs512 signing method")
+	}
+	kid, _ := token.Header["kid"].(string)
+	key, err := api.verificationKey(kid)
+	if err != nil {
+		return nil, err
+	}
+	// return byte version of signing key
+	return []byte(key), nil
 }
 
 func (api *API) verifyEmailJWTToken(jwtString, username string) error {
 	// parse the jwt for a token
-	token, err := jwt.Parse(jwtString, func(token *jwt.Token) (interface{}, error) {
-		// Don't forget to validate the alg is what you expect:
-		if method, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unable to validate signing method: %v", token.Header["alg"])
-		} else if method != jwt.SigningMethodHS512 {
-			return nil, errors.New("expect hs512 signing method")
-		}
-		// return byte version of signing key
-		return []byte(api.cfg.JWT.Key), nil
-	})
+	token, err := jwt.Parse(jwtString, api.emailJWTKey)
 	// verify jwt was parsed properly
 	if err != nil {
 		return err
@@ -157,6 +174,10 @@ func (api *API) verifyEmailJWTToken(jwtString, username string) error {
 	if claims["emailVerificationString"] != user.EmailVerificationToken {
 		return errors.New("failed to validate email verification token")
 	}
+	// validate the token was sent to the user's current email address
+	if api.opts.BindVerificationEmail && claims["email"] != strings.ToLower(user.EmailAddress) {
+		return errors.New("email address from claim does not match the user's email address")
+	}
 	// ensure we can cast claims["expire"] to string type
 	expireString, ok := claims["expire"].(string)
 	if !ok {
@@ -171,22 +192,45 @@ func (api *API) verifyEmailJWTToken(jwtString, username string) error {
 	if time.Now().UTC().Unix() > expireTime.Unix() {
 		return errors.New("token is expired")
 	}
+	_, err = api.activateAccount(username, emailVerificationString)
+	return err
+}
+
+// activateAccount enables email activity for the account, and moves
+// it out of the unverified tier. It returns the updated user model
+func (api *API) activateAccount(username, emailVerificationToken string) (*models.User, error) {
 	// enable email activity
-	if _, err := api.um.ValidateEmailVerificationToken(username, emailVerificationString); err != nil {
-		return err
+	user, err := api.um.ValidateEmailVerificationToken(username, emailVerificationToken)
+	if err != nil {
+		return nil, err
 	}
-	// upgrade to free tier if unverified
+	// upgrade to the verified tier if unverified
 	usg, err := api.usage.FindByUserName(username)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	// only update tier if they are an unverified user
 	// this is to provide backwards compatability where some unverified users
 	// may already be in a different tier
 	if usg.Tier == models.Unverified {
-		api.usage.UpdateTier(username, models.Free)
+		if err := api.setTier(username, api.verifiedTier()); err != nil {
+			return nil, err
+		}
 	}
-	return nil
+	if err := api.recordVerification(username, time.Now()); err != nil {
+		api.l.Errorw("failed to record email verification", "user", username, "error", err)
+	}
+	api.funnel.accountVerified()
+	api.notifyVerified(user)
+	return user, nil
+}
+
+// verifiedTier returns the tier accounts are placed in after verification
+func (api *API) verifiedTier() models.DataUsageTier {
+	if api.opts.VerifiedTier == "" {
+		return models.Free
+	}
+	return api.opts.VerifiedTier
 }
 
 // validateUserCredits is used to validate whether or not a user has enough credits to pay for an action
@@ -247,12 +291,6 @@ func (api *API) extractPostForms(c *gin.Context, formNames ...string) (map[strin
 // ValidateHoldTime is used to perform parsing of requested hold times,
 // returning an int64 type of the provided hold time
 func (api *API) validateHoldTime(username, holdTime string) (int64, error) {
-	var (
-		// 1 year
-		freeHoldTimeLimitInMonths int64 = 12
-		// two years
-		nonFreeHoldTimeLimitInMonths int64 = 24
-	)
 	holdTimeInt, err := strconv.ParseInt(holdTime, 10, 64)
 	if err != nil {
 		return 0, err
@@ -261,30 +299,23 @@ func (api *API) validateHoldTime(username, holdTime string) (int64, error) {
 	if err != nil {
 		return 0, err
 	}
-	switch usageTier.Tier {
-	case models.Free, models.Unverified:
-		if holdTimeInt > freeHoldTimeLimitInMonths {
-			return 0, errors.New("free accounts are limited to maximum hold times of 12 month")
-
-		}
-	default:
-		if holdTimeInt > nonFreeHoldTimeLimitInMonths {
-			return 0, errors.New("non free accounts are limited to a maximum hold time of 24 months")
-		}
+	// unknown tiers fall back to the most restrictive policy
+	info, _ := api.tiers.get(usageTier.Tier)
+	if holdTimeInt > info.MaxHoldTimeMonths {
+		return 0, fmt.Errorf(
+			"%s accounts are limited to a maximum hold time of %v months",
+			usageTier.Tier, info.MaxHoldTimeMonths,
+		)
 	}
 	return holdTimeInt, nil
 }
 
 func (api *API) ensureLEMaxPinTime(upload *models.Upload, holdTime int64, tier models.DataUsageTier) error {
-	var limit time.Time
-	switch tier {
-	case models.Free, models.Unverified:
-		limit = time.Now().AddDate(1, 0, 0)
-	case models.Paid, models.Partner, models.WhiteLabeled:
-		limit = time.Now().AddDate(2, 0, 0)
-	default:
+	info, ok := api.tiers.get(tier)
+	if !ok {
 		return errors.New("invalid usage tier")
 	}
+	limit := time.Now().AddDate(0, int(info.MaxHoldTimeMonths), 0)
 	if upload.GarbageCollectDate.AddDate(0, int(holdTime), 0).After(limit) {
 		return errors.New(eh.MaxHoldTimeError)
 	}
@@ -305,6 +336,16 @@ func (api *API) getCaptchaKey() string {
 	return api.cfg.APIKeys.ReCAPTCHA
 }
 
+// registrationCaptchaRequired returns whether or not account registrations
+// from ip must include a valid captcha response. This is never enforced in dev mode
+func (api *API) registrationCaptchaRequired(ip string) bool {
+	if dev || !api.captchaEnabled {
+		return false
+	}
+	return api.opts.RegistrationCaptcha ||
+		(api.opts.CaptchaRepeatRegistrations && api.ipRegistrations(ip) > 0)
+}
+
 // swarmUpload allows upload a file to multiple swarm backends
 // and is a poor mans way of replicating data amongst multiple swarm nodes
 func (api *API) swarmUpload(data []byte, isTar bool) (string, error) {
@@ -42,7 +42,7 @@ func TestEmailJWT(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	tkn, err := api.generateEmailJWTToken(randUser, userModel.EmailVerificationToken)
+	tkn, err := api.generateEmailJWTToken(randUser, userModel.EmailVerificationToken, userModel.EmailAddress)
 	if err != nil {
 		t.Fatal(err)
 	}
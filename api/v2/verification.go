@@ -0,0 +1,287 @@
+package v2
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/RTradeLtd/Temporal/eh"
+	"github.com/RTradeLtd/Temporal/queue"
+	"github.com/RTradeLtd/database/v2/models"
+	"github.com/gin-gonic/gin"
+	jwt "gopkg.in/dgrijalva/jwt-go.v3"
+)
+
+// verificationLifetime is how long email verification links are valid for
+const verificationLifetime = time.Hour * 24
+
+// verificationRecord records when an account verified its
+// email address, which the user model doesn't track
+type verificationRecord struct {
+	UserName   string `gorm:"primary_key"`
+	VerifiedAt time.Time
+}
+
+// recordVerification stores the time username verified their email address
+func (api *API) recordVerification(username string, at time.Time) error {
+	return api.um.DB.Save(&verificationRecord{UserName: username, VerifiedAt: at}).Error
+}
+
+// verifiedAt returns when username verified their email address. Accounts
+// verified before this was recorded report false
+func (api *API) verifiedAt(username string) (time.Time, bool) {
+	var record verificationRecord
+	if err := api.um.DB.Where("user_name = ?", username).First(&record).Error; err != nil {
+		return time.Time{}, false
+	}
+	return record.VerifiedAt, true
+}
+
+// generateVerificationToken returns the token placed in email verification
+// links, which is either a jwt or an opaque code depending on configuration
+func (api *API) generateVerificationToken(username, verificationString, email string) (string, error) {
+	if api.opts.OpaqueVerificationCodes {
+		return api.generateVerificationCode(
+			username, api.verificationSecret(verificationString, email), time.Now().Add(verificationLifetime),
+		), nil
+	}
+	return api.generateEmailJWTToken(username, verificationString, email)
+}
+
+// verificationSecret returns the value opaque and numeric verification codes are
+// derived from. When Options.BindVerificationEmail is set this includes the
+// email address, so that codes sent before an address change are rejected
+func (api *API) verificationSecret(verificationString, email string) string {
+	if !api.opts.BindVerificationEmail {
+		return verificationString
+	}
+	return verificationString + "\n" + strings.ToLower(email)
+}
+
+// verificationEmail builds the welcome email, which contains either a link or
+// a numeric code used to verify the account depending on configuration
+func (api *API) verificationEmail(user *models.User, organizationName string) (queue.EmailSend, error) {
+	username := user.UserName
+	if api.opts.NumericVerificationCodes {
+		// mobile clients verify by entering a code rather than following a link
+		return welcomeCodeEmail(
+			organizationName,
+			api.generateNumericCode(
+				username, api.verificationSecret(user.EmailVerificationToken, user.EmailAddress), time.Now(),
+			),
+		), nil
+	}
+	// generate a token used to trigger email validation
+	token, err := api.generateVerificationToken(username, user.EmailVerificationToken, user.EmailAddress)
+	if err != nil {
+		return queue.EmailSend{}, err
+	}
+	var url string
+	// format the url the user clicks to activate email
+	if dev {
+		url = fmt.Sprintf(
+			"https://dev.api.temporal.cloud/v2/account/email/verify/%s/%s",
+			username, token,
+		)
+	} else {
+		url = fmt.Sprintf(
+			"https://api.temporal.cloud/v2/account/email/verify/%s/%s",
+			username, token,
+		)
+	}
+	return welcomeEmail(organizationName, url), nil
+}
+
+// verifyVerificationToken validates a token generated by generateVerificationToken
+// and activates the account. Changing the configured token type invalidates any
+// outstanding verification links. Every token type is checked against the user's
+// current email verification string, so regenerating it with
+// GenerateEmailVerificationToken invalidates earlier tokens before they expire
+func (api *API) verifyVerificationToken(token, username string) error {
+	if api.opts.OpaqueVerificationCodes {
+		return api.verifyVerificationCode(token, username)
+	}
+	return api.verifyEmailJWTToken(token, username)
+}
+
+// verificationTokenIssuedTo reports whether token is a genuine verification token
+// for user, without checking its expiry or activating the account. It is used to
+// recognise links which are followed again once the account is verified
+func (api *API) verificationTokenIssuedTo(token string, user *models.User) bool {
+	if api.opts.OpaqueVerificationCodes {
+		// the zero time precedes every expiry
+		return api.checkVerificationCode(
+			token, user.UserName, api.verificationSecret(user.EmailVerificationToken, user.EmailAddress), time.Time{},
+		) == nil
+	}
+	parsed, err := jwt.Parse(token, api.emailJWTKey)
+	if err != nil || !parsed.Valid {
+		return false
+	}
+	claims, ok := parsed.Claims.(jwt.MapClaims)
+	return ok && claims["user"] == user.UserName
+}
+
+// verificationKeyID returns the kid identifying a signing key within verification
+// tokens. It is derived from the key so that keys don't need to be named
+func verificationKeyID(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:4])
+}
+
+// verificationKey resolves the key that signed a verification token from its kid,
+// which is either the current jwt key, the key it replaced when it was last reloaded,
+// or one of Options.RetiringVerificationKeys. Tokens without a kid were issued
+// before keys were identified and use the current key
+func (api *API) verificationKey(kid string) (string, error) {
+	current := api.jwtKey.Current()
+	if kid == "" || kid == verificationKeyID(current) {
+		return current, nil
+	}
+	if previous := api.jwtKey.Previous(); previous != "" && kid == verificationKeyID(previous) {
+		return previous, nil
+	}
+	for _, key := range api.opts.RetiringVerificationKeys {
+		if kid == verificationKeyID(key) {
+			return key, nil
+		}
+	}
+	return "", errors.New("verification token was signed with an unknown key")
+}
+
+// generateVerificationCode returns a short opaque code consisting of its expiry,
+// a hmac over the expiry and the user's email verification string, and the kid
+// of the key used. This keeps verification links short, and avoids exposing
+// claims within them
+func (api *API) generateVerificationCode(username, verificationString string, expire time.Time) string {
+	exp := strconv.FormatInt(expire.Unix(), 36)
+	return exp + "." + api.verificationMAC(username, verificationString, exp) + "." + verificationKeyID(api.jwtKey.Current())
+}
+
+// verifyVerificationCode validates an opaque verification code and activates the account
+func (api *API) verifyVerificationCode(code, username string) error {
+	user, err := api.um.FindByUserName(username)
+	if err != nil {
+		return errors.New(eh.UserSearchError)
+	}
+	if err := api.checkVerificationCode(
+		code, username, api.verificationSecret(user.EmailVerificationToken, user.EmailAddress), time.Now(),
+	); err != nil {
+		return err
+	}
+	_, err = api.activateAccount(username, user.EmailVerificationToken)
+	return err
+}
+
+// checkVerificationCode ensures code was generated for the given user
+// and email verification string, and that it hasn't expired
+func (api *API) checkVerificationCode(code, username, verificationString string, now time.Time) error {
+	parts := strings.Split(code, ".")
+	// codes issued before keys were identified don't carry a kid
+	if len(parts) == 2 {
+		parts = append(parts, "")
+	}
+	if len(parts) != 3 {
+		return errors.New("malformed verification code")
+	}
+	key, err := api.verificationKey(parts[2])
+	if err != nil {
+		return err
+	}
+	mac := keyedVerificationMAC(key, username, verificationString, parts[0])
+	if !hmac.Equal([]byte(mac), []byte(parts[1])) {
+		return errors.New("failed to validate verification code")
+	}
+	exp, err := strconv.ParseInt(parts[0], 36, 64)
+	if err != nil {
+		return err
+	}
+	if now.Unix() > exp {
+		return errors.New("verification code is expired")
+	}
+	return nil
+}
+
+func (api *API) verificationMAC(username, verificationString, exp string) string {
+	return keyedVerificationMAC(api.jwtKey.Current(), username, verificationString, exp)
+}
+
+func keyedVerificationMAC(key, username, verificationString, exp string) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(username + "\n" + verificationString + "\n" + exp))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// numericCodeStep is the window each numeric verification code is generated
+// for. Codes from the previous window are also accepted, so a code is valid
+// for at least one full window after it is sent
+const numericCodeStep = time.Hour * 24
+
+// generateNumericCode returns a six digit verification code for the user. Codes
+// are derived from the user's email verification string rather than stored
+func (api *API) generateNumericCode(username, verificationString string, now time.Time) string {
+	return api.numericCode(username, verificationString, now.Unix()/int64(numericCodeStep.Seconds()))
+}
+
+// checkNumericCode ensures code was generated for the given user and
+// email verification string within the current or previous window
+func (api *API) checkNumericCode(code, username, verificationString string, now time.Time) error {
+	step := now.Unix() / int64(numericCodeStep.Seconds())
+	for _, s := range []int64{step, step - 1} {
+		if hmac.Equal([]byte(code), []byte(api.numericCode(username, verificationString, s))) {
+			return nil
+		}
+	}
+	return errors.New("invalid or expired verification code")
+}
+
+func (api *API) numericCode(username, verificationString string, step int64) string {
+	mac := hmac.New(sha256.New, []byte(api.jwtKey.Current()))
+	mac.Write([]byte(username + "\n" + verificationString + "\n" + strconv.FormatInt(step, 10)))
+	return fmt.Sprintf("%06d", binary.BigEndian.Uint32(mac.Sum(nil))%1000000)
+}
+
+// verifyEmailCode is used to verify a users email with the numeric code
+// sent to them, without requiring authentication. Attempts are limited per
+// user, as there are only a million possible codes
+func (api *API) verifyEmailCode(c *gin.Context) {
+	forms, missingField := api.extractPostForms(c, "username", "code")
+	if missingField != "" {
+		FailWithMissingField(c, missingField)
+		return
+	}
+	if !api.limits.verificationAttempts.allow(forms["username"]) {
+		FailWithMessage(c, "too many verification attempts, please try again later", http.StatusTooManyRequests)
+		return
+	}
+	user, err := api.um.FindByUserName(forms["username"])
+	if err != nil {
+		api.LogError(c, err, eh.InvalidVerificationLinkError, "user", forms["username"])(http.StatusBadRequest)
+		return
+	}
+	// the code is checked first, so that verified accounts
+	// are only reported to users holding a valid code
+	if err := api.checkNumericCode(
+		forms["code"], user.UserName, api.verificationSecret(user.EmailVerificationToken, user.EmailAddress), time.Now(),
+	); err != nil {
+		api.LogError(c, err, eh.InvalidVerificationLinkError, "user", user.UserName)(http.StatusBadRequest)
+		return
+	}
+	if user.EmailEnabled {
+		Respond(c, http.StatusOK, gin.H{"response": "email already verified"})
+		return
+	}
+	if _, err := api.activateAccount(user.UserName, user.EmailVerificationToken); err != nil {
+		api.LogError(c, err, eh.InvalidVerificationLinkError, "user", user.UserName)(http.StatusBadRequest)
+		return
+	}
+	Respond(c, http.StatusOK, gin.H{"response": "email verified"})
+}
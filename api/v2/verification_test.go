@@ -0,0 +1,237 @@
+package v2
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/RTradeLtd/Temporal/api/middleware"
+	"github.com/RTradeLtd/config/v2"
+	"github.com/RTradeLtd/database/v2/models"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap/zaptest"
+	jwt "gopkg.in/dgrijalva/jwt-go.v3"
+)
+
+func TestAPI_checkVerificationCode(t *testing.T) {
+	cfg := &config.TemporalConfig{}
+	cfg.JWT.Key = "suchsecretmuchkeyverysecurewowsuchsecret"
+	api := &API{cfg: cfg, jwtKey: middleware.NewSigningKey(cfg.JWT.Key)}
+	now := time.Now()
+	code := api.generateVerificationCode("testuser", "verificationstring", now.Add(verificationLifetime))
+	if len(code) > 64 {
+		t.Fatalf("verification code is too long: %v", len(code))
+	}
+	tests := []struct {
+		name               string
+		code               string
+		username           string
+		verificationString string
+		now                time.Time
+		wantErr            bool
+	}{
+		{"Valid", code, "testuser", "verificationstring", now, false},
+		{"Expired", code, "testuser", "verificationstring", now.Add(verificationLifetime + time.Minute), true},
+		{"Wrong-User", code, "otheruser", "verificationstring", now, true},
+		{"Wrong-Verification-String", code, "testuser", "otherstring", now, true},
+		{"Tampered-Expiry", "zzzzzzzz" + code[strings.Index(code, "."):], "testuser", "verificationstring", now, true},
+		{"Malformed", "notacode", "testuser", "verificationstring", now, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := api.checkVerificationCode(
+				tt.code, tt.username, tt.verificationString, tt.now,
+			); (err != nil) != tt.wantErr {
+				t.Fatalf("checkVerificationCode() err = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAPI_verificationKey_Rotation(t *testing.T) {
+	cfg := &config.TemporalConfig{}
+	cfg.JWT.Key = "suchsecretmuchkeyverysecurewowsuchsecret"
+	api := &API{cfg: cfg, jwtKey: middleware.NewSigningKey(cfg.JWT.Key)}
+	now := time.Now()
+	// links issued before the key is rotated
+	code := api.generateVerificationCode("testuser", "verificationstring", now.Add(verificationLifetime))
+	token, err := api.generateEmailJWTToken("testuser", "verificationstring", "test@example.org")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.JWT.Key = "suchnewsecretmuchrotatedverysecurewowsuchsecret"
+	tests := []struct {
+		name     string
+		retiring []string
+		wantErr  bool
+	}{
+		{"Retiring-Key", []string{"suchsecretmuchkeyverysecurewowsuchsecret"}, false},
+		{"Retired-Key", nil, true},
+		{"Other-Key", []string{"someotherretiringkeywhichisntthesigningkey"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api.opts.RetiringVerificationKeys = tt.retiring
+			if err := api.checkVerificationCode(
+				code, "testuser", "verificationstring", now,
+			); (err != nil) != tt.wantErr {
+				t.Fatalf("checkVerificationCode() err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if _, err := jwt.Parse(token, api.emailJWTKey); (err != nil) != tt.wantErr {
+				t.Fatalf("emailJWTKey() err = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+	// links issued after rotation use the new key
+	api.opts.RetiringVerificationKeys = nil
+	code = api.generateVerificationCode("testuser", "verificationstring", now.Add(verificationLifetime))
+	if err := api.checkVerificationCode(code, "testuser", "verificationstring", now); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAPI_checkNumericCode(t *testing.T) {
+	cfg := &config.TemporalConfig{}
+	cfg.JWT.Key = "suchsecretmuchkeyverysecurewowsuchsecret"
+	api := &API{cfg: cfg, jwtKey: middleware.NewSigningKey(cfg.JWT.Key)}
+	now := time.Now()
+	code := api.generateNumericCode("testuser", "verificationstring", now)
+	if len(code) != 6 {
+		t.Fatalf("numeric code should be 6 digits, got %q", code)
+	}
+	tests := []struct {
+		name               string
+		code               string
+		username           string
+		verificationString string
+		now                time.Time
+		wantErr            bool
+	}{
+		{"Valid", code, "testuser", "verificationstring", now, false},
+		{"Previous-Window", code, "testuser", "verificationstring", now.Add(numericCodeStep), false},
+		{"Expired", code, "testuser", "verificationstring", now.Add(numericCodeStep * 2), true},
+		{"Wrong-User", code, "otheruser", "verificationstring", now, true},
+		{"Wrong-Verification-String", code, "testuser", "otherstring", now, true},
+		{"Empty", "", "testuser", "verificationstring", now, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := api.checkNumericCode(
+				tt.code, tt.username, tt.verificationString, tt.now,
+			); (err != nil) != tt.wantErr {
+				t.Fatalf("checkNumericCode() err = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAPI_verifyEmailCode_Lockout(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	api := &API{limits: limits{verificationAttempts: newKeyedLimiter(1, time.Hour)}}
+	// exhaust the attempts for this user
+	api.limits.verificationAttempts.allow("testuser")
+	r := gin.New()
+	r.POST("/verify/code", api.verifyEmailCode)
+	form := url.Values{"username": {"testuser"}, "code": {"123456"}}
+	req := httptest.NewRequest("POST", "/verify/code", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected status %v, got %v", http.StatusTooManyRequests, rec.Code)
+	}
+}
+
+func TestAPI_verifyEmailCode_Verified(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cfg, err := config.LoadConfig("../../testenv/config.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := loadDatabase(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	api := &API{
+		cfg:    cfg,
+		jwtKey: middleware.NewSigningKey(cfg.JWT.Key),
+		um:     models.NewUserManager(db),
+		l:      zaptest.NewLogger(t).Sugar(),
+		limits: limits{verificationAttempts: newKeyedLimiter(10, time.Hour)},
+	}
+	user, err := api.um.FindByUserName("testuser")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !user.EmailEnabled {
+		t.Fatal("testuser should be verified")
+	}
+	r := gin.New()
+	r.POST("/verify/code", api.verifyEmailCode)
+	tests := []struct {
+		name     string
+		code     string
+		wantCode int
+	}{
+		{"Invalid-Code", "000000x", http.StatusBadRequest},
+		{"Valid-Code", api.generateNumericCode(
+			user.UserName, api.verificationSecret(user.EmailVerificationToken, user.EmailAddress), time.Now(),
+		), http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{"username": {"testuser"}, "code": {tt.code}}
+			req := httptest.NewRequest("POST", "/verify/code", strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, req)
+			if rec.Code != tt.wantCode {
+				t.Fatalf("expected status %v, got %v", tt.wantCode, rec.Code)
+			}
+			// only valid codes reveal that the account is verified
+			if verified := strings.Contains(rec.Body.String(), "email already verified"); verified != (tt.wantCode == http.StatusOK) {
+				t.Fatalf("unexpected response %s", rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestAPI_verificationSecret_EmailChanged(t *testing.T) {
+	cfg := &config.TemporalConfig{}
+	cfg.JWT.Key = "suchsecretmuchkeyverysecurewowsuchsecret"
+	now := time.Now()
+	tests := []struct {
+		name    string
+		bind    bool
+		wantErr bool
+	}{
+		{"Unbound", false, false},
+		{"Bound", true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := &API{cfg: cfg, jwtKey: middleware.NewSigningKey(cfg.JWT.Key), opts: Options{BindVerificationEmail: tt.bind}}
+			// codes are issued to the original address
+			issued := api.verificationSecret("verificationstring", "old@example.org")
+			code := api.generateVerificationCode("testuser", issued, now.Add(verificationLifetime))
+			numeric := api.generateNumericCode("testuser", issued, now)
+			// then checked after the address has been changed
+			current := api.verificationSecret("verificationstring", "new@example.org")
+			if err := api.checkVerificationCode(code, "testuser", current, now); (err != nil) != tt.wantErr {
+				t.Fatalf("checkVerificationCode() err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err := api.checkNumericCode(numeric, "testuser", current, now); (err != nil) != tt.wantErr {
+				t.Fatalf("checkNumericCode() err = %v, wantErr %v", err, tt.wantErr)
+			}
+			// case differences in the address are not treated as a change
+			if err := api.checkVerificationCode(
+				code, "testuser", api.verificationSecret("verificationstring", "OLD@example.org"), now,
+			); err != nil {
+				t.Fatalf("checkVerificationCode() err = %v for same address", err)
+			}
+		})
+	}
+}
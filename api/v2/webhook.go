@@ -0,0 +1,121 @@
+package v2
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/RTradeLtd/database/v2/models"
+)
+
+const (
+	// webhookSignatureHeader holds the hex encoded hmac-sha256 of the timestamp
+	// and request body, joined by a period, keyed with Options.VerificationWebhookSecret
+	webhookSignatureHeader = "X-Temporal-Signature"
+	// webhookTimestampHeader holds the unix time in seconds the webhook was signed at
+	webhookTimestampHeader = "X-Temporal-Timestamp"
+	// WebhookTolerance is how far the timestamp of a webhook may be from the
+	// time it is received. Receivers should reject webhooks outside of it, so
+	// that captured deliveries can't be replayed later
+	WebhookTolerance = time.Minute * 5
+	// webhookAttempts is the number of times delivery of a webhook is attempted
+	webhookAttempts = 3
+	// webhookDelay is the initial delay between webhook delivery attempts
+	webhookDelay = time.Second
+	// webhookTimeout bounds each webhook delivery attempt
+	webhookTimeout = time.Second * 10
+)
+
+// verificationWebhook is the payload posted to Options.VerificationWebhookURL
+// when an account verifies its email address
+type verificationWebhook struct {
+	Event        string `json:"event"`
+	UserName     string `json:"user_name"`
+	EmailAddress string `json:"email_address"`
+	VerifiedAt   int64  `json:"verified_at"`
+}
+
+// notifyVerified posts a verification webhook for user if one is configured.
+// It is delivered asynchronously so that retries don't delay, and failures
+// don't fail, the verification itself
+func (api *API) notifyVerified(user *models.User) {
+	if api.opts.VerificationWebhookURL == "" {
+		return
+	}
+	hook := verificationWebhook{
+		Event:        "account.verified",
+		UserName:     user.UserName,
+		EmailAddress: user.EmailAddress,
+		VerifiedAt:   time.Now().Unix(),
+	}
+	go func() {
+		if err := api.sendWebhook(api.opts.VerificationWebhookURL, hook); err != nil {
+			api.l.Errorw("failed to deliver verification webhook", "user", hook.UserName, "error", err)
+		}
+	}()
+}
+
+// sendWebhook posts payload to url, signed with the configured webhook
+// secret. Delivery is retried until a 2xx response is received, with each
+// attempt signed at the time it is made
+func (api *API) sendWebhook(url string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Timeout: webhookTimeout}
+	return retryWithBackoff(webhookAttempts, webhookDelay, func() error {
+		req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(webhookTimestampHeader, timestamp)
+		req.Header.Set(webhookSignatureHeader, webhookSignature(api.opts.VerificationWebhookSecret, timestamp, body))
+		resp, err := client.Do(req)
+		if err != nil {
+			api.l.Warnw("failed to send webhook", "error", err)
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			api.l.Warnw("webhook rejected", "status", resp.StatusCode)
+			return fmt.Errorf("webhook responded with status %v", resp.StatusCode)
+		}
+		return nil
+	})
+}
+
+// webhookSignature returns the hex encoded hmac-sha256 of timestamp
+// and body, joined by a period, keyed with secret
+func webhookSignature(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyWebhookSignature is used by receivers to check that a webhook was sent
+// by us, given the values of its X-Temporal-Timestamp and X-Temporal-Signature
+// headers, and that it was signed within WebhookTolerance of now
+func VerifyWebhookSignature(secret, timestamp, signature string, body []byte, now time.Time) error {
+	signedAt, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errors.New("malformed webhook timestamp")
+	}
+	if age := now.Sub(time.Unix(signedAt, 0)); age > WebhookTolerance || age < -WebhookTolerance {
+		return errors.New("webhook timestamp is outside of the tolerance window")
+	}
+	if !hmac.Equal([]byte(signature), []byte(webhookSignature(secret, timestamp, body))) {
+		return errors.New("invalid webhook signature")
+	}
+	return nil
+}
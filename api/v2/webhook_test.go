@@ -0,0 +1,90 @@
+package v2
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/RTradeLtd/database/v2/models"
+	"go.uber.org/zap/zaptest"
+)
+
+func TestAPI_notifyVerified(t *testing.T) {
+	type delivery struct {
+		body      []byte
+		timestamp string
+		signature string
+	}
+	deliveries := make(chan delivery, 1)
+	var attempts int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// fail the first attempt to ensure delivery is retried
+		if atomic.AddInt32(&attempts, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		deliveries <- delivery{body, r.Header.Get(webhookTimestampHeader), r.Header.Get(webhookSignatureHeader)}
+	}))
+	defer server.Close()
+	api := &API{
+		l: zaptest.NewLogger(t).Sugar(),
+		opts: Options{
+			VerificationWebhookURL:    server.URL,
+			VerificationWebhookSecret: "webhooksecret",
+		},
+	}
+	api.notifyVerified(&models.User{UserName: "testuser", EmailAddress: "testuser@example.org"})
+	select {
+	case d := <-deliveries:
+		if err := VerifyWebhookSignature("webhooksecret", d.timestamp, d.signature, d.body, time.Now()); err != nil {
+			t.Fatal(err)
+		}
+		var hook verificationWebhook
+		if err := json.Unmarshal(d.body, &hook); err != nil {
+			t.Fatal(err)
+		}
+		if hook.Event != "account.verified" || hook.UserName != "testuser" ||
+			hook.EmailAddress != "testuser@example.org" {
+			t.Fatalf("unexpected webhook payload %+v", hook)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("webhook was not delivered")
+	}
+}
+
+func TestVerifyWebhookSignature(t *testing.T) {
+	now := time.Now()
+	body := []byte(`{"event":"account.verified"}`)
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	signature := webhookSignature("webhooksecret", timestamp, body)
+	tests := []struct {
+		name      string
+		secret    string
+		timestamp string
+		body      []byte
+		now       time.Time
+		wantErr   bool
+	}{
+		{"Valid", "webhooksecret", timestamp, body, now, false},
+		{"Wrong-Secret", "othersecret", timestamp, body, now, true},
+		{"Tampered-Body", "webhooksecret", timestamp, []byte(`{"event":"account.deleted"}`), now, true},
+		{"Tampered-Timestamp", "webhooksecret", strconv.FormatInt(now.Unix()+1, 10), body, now, true},
+		{"Replayed", "webhooksecret", timestamp, body, now.Add(WebhookTolerance + time.Minute), true},
+		{"Malformed-Timestamp", "webhooksecret", "notatime", body, now, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := VerifyWebhookSignature(
+				tt.secret, tt.timestamp, signature, tt.body, tt.now,
+			); (err != nil) != tt.wantErr {
+				t.Fatalf("VerifyWebhookSignature() err = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
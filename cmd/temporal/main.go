@@ -14,6 +14,7 @@ import (
 	"go.bobheadxi.dev/zapx/zapx"
 	"go.uber.org/zap"
 
+	"github.com/RTradeLtd/Temporal/api/middleware"
 	v2 "github.com/RTradeLtd/Temporal/api/v2"
 	clients "github.com/RTradeLtd/Temporal/grpc-clients"
 	"github.com/RTradeLtd/Temporal/queue"
@@ -149,6 +150,20 @@ func initClients(l *zap.SugaredLogger, cfg *config.TemporalConfig) (closers []fu
 	return
 }
 
+// reloadJWTKey reads the jwt signing key from the configuration file or the
+// environment, and replaces the key used by service with it
+func reloadJWTKey(service *v2.API) error {
+	cfg, err := config.LoadConfig(*configPath)
+	if err != nil {
+		return err
+	}
+	key, err := middleware.LoadJWTKey(cfg.JWT.Key)
+	if err != nil {
+		return err
+	}
+	return service.ReloadJWTKey(key)
+}
+
 var commands = map[string]cmd.Cmd{
 	"api": {
 		Blurb:       "start Temporal api server",
@@ -176,6 +191,11 @@ var commands = map[string]cmd.Cmd{
 				Signer:    signer,
 				BchWallet: bchWallet,
 			}
+			// the signing key may be provided through the environment
+			// rather than stored in the configuration file
+			if cfg.JWT.Key, err = middleware.LoadJWTKey(cfg.JWT.Key); err != nil {
+				l.Fatal(err)
+			}
 			// init api service
 			service, err := v2.Initialize(
 				ctx,
@@ -199,6 +219,18 @@ var commands = map[string]cmd.Cmd{
 				service.Close()
 			}()
 
+			// reload the signing key on SIGHUP, so that it can be rotated without
+			// a restart. The key is read the same way as it was at startup
+			reloadChannel := make(chan os.Signal, 1)
+			signal.Notify(reloadChannel, syscall.SIGHUP)
+			go func() {
+				for range reloadChannel {
+					if err := reloadJWTKey(service); err != nil {
+						l.Errorw("failed to reload jwt signing key", "error", err)
+					}
+				}
+			}()
+
 			// go!
 			var addr = fmt.Sprintf("%s:%s", args["listenAddress"], *apiPort)
 			var (
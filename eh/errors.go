@@ -91,6 +91,9 @@ const (
 	UnableToSaveUserError = "saving user account to database failed"
 	// EmailVerificationError is an error used when a user fails to validate their email address
 	EmailVerificationError = "failed to verify email address"
+	// InvalidVerificationLinkError is an error used when an email verification link can't be validated.
+	// It is deliberately vague so that it doesn't reveal whether or not the user exists
+	InvalidVerificationLinkError = "invalid or expired verification link"
 	// EmailTokenGenerationError is an error messaged used when failing to generate a token
 	EmailTokenGenerationError = "failed to generate email verification token"
 	// ZoneSearchError is an error message used when failing to search for a zone
@@ -0,0 +1,73 @@
+package eh
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// Sentinel errors for the error messages that callers need to match on.
+// Use Sentinel to convert errors created from our message constants
+// elsewhere, such as the database package, before using errors.Is
+var (
+	// ErrDuplicateEmail is returned when an email address is already taken
+	ErrDuplicateEmail = errors.New(DuplicateEmailError)
+	// ErrDuplicateUserName is returned when a username is already taken
+	ErrDuplicateUserName = errors.New(DuplicateUserNameError)
+	// ErrDuplicateKey is returned when a key name already exists
+	ErrDuplicateKey = errors.New(DuplicateKeyCreationError)
+	// ErrUserSearch is returned when a user can't be found
+	ErrUserSearch = errors.New(UserSearchError)
+	// ErrInvalidBalance is returned when a user can't pay for an api call
+	ErrInvalidBalance = errors.New(InvalidBalanceError)
+	// ErrUnAuthorizedAdminAccess is returned when a user isn't an administrator
+	ErrUnAuthorizedAdminAccess = errors.New(UnAuthorizedAdminAccess)
+	// ErrNoAPIToken is returned when a request's token can't be validated
+	ErrNoAPIToken = errors.New(NoAPITokenError)
+)
+
+// statuses maps sentinel errors to the http status code returned for them
+var statuses = map[error]int{
+	// duplicates are reported as bad requests for compatibility with existing clients
+	ErrDuplicateEmail:          http.StatusBadRequest,
+	ErrDuplicateUserName:       http.StatusBadRequest,
+	ErrDuplicateKey:            http.StatusBadRequest,
+	ErrUserSearch:              http.StatusNotFound,
+	ErrInvalidBalance:          http.StatusPaymentRequired,
+	ErrUnAuthorizedAdminAccess: http.StatusForbidden,
+	ErrNoAPIToken:              http.StatusUnauthorized,
+}
+
+// Sentinel returns the sentinel error whose message matches err, so that it
+// can be compared with errors.Is. Unique constraint violations of the email
+// address and username columns, which are returned instead of the duplicate
+// errors when concurrent registrations race past the database's own checks,
+// are matched as well. If there is no match err is returned as is
+func Sentinel(err error) error {
+	if err == nil {
+		return nil
+	}
+	for sentinel := range statuses {
+		if errors.Is(err, sentinel) || err.Error() == sentinel.Error() {
+			return sentinel
+		}
+	}
+	if msg := err.Error(); strings.Contains(msg, "violates unique constraint") {
+		switch {
+		case strings.Contains(msg, "email_address"):
+			return ErrDuplicateEmail
+		case strings.Contains(msg, "user_name"):
+			return ErrDuplicateUserName
+		}
+	}
+	return err
+}
+
+// StatusFor returns the http status code for err, defaulting
+// to http.StatusBadRequest for errors that aren't known
+func StatusFor(err error) int {
+	if status, ok := statuses[Sentinel(err)]; ok {
+		return status
+	}
+	return http.StatusBadRequest
+}
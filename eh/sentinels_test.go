@@ -0,0 +1,45 @@
+package eh
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestStatusFor(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		sentinel error
+		want     int
+	}{
+		{"Duplicate-Email", errors.New(DuplicateEmailError), ErrDuplicateEmail, http.StatusBadRequest},
+		{"Duplicate-UserName", errors.New(DuplicateUserNameError), ErrDuplicateUserName, http.StatusBadRequest},
+		{"Duplicate-Key", errors.New(DuplicateKeyCreationError), ErrDuplicateKey, http.StatusBadRequest},
+		{"User-Search", errors.New(UserSearchError), ErrUserSearch, http.StatusNotFound},
+		{"Invalid-Balance", errors.New(InvalidBalanceError), ErrInvalidBalance, http.StatusPaymentRequired},
+		{"Not-Admin", errors.New(UnAuthorizedAdminAccess), ErrUnAuthorizedAdminAccess, http.StatusForbidden},
+		{"No-Token", errors.New(NoAPITokenError), ErrNoAPIToken, http.StatusUnauthorized},
+		{"Wrapped", fmt.Errorf("register: %w", ErrDuplicateEmail), ErrDuplicateEmail, http.StatusBadRequest},
+		{"Email-Constraint", errors.New(`pq: duplicate key value violates unique constraint "users_email_address_key"`), ErrDuplicateEmail, http.StatusBadRequest},
+		{"UserName-Constraint", errors.New(`pq: duplicate key value violates unique constraint "users_user_name_key"`), ErrDuplicateUserName, http.StatusBadRequest},
+		{"Other-Constraint", errors.New(`pq: duplicate key value violates unique constraint "ipfs_keys_pkey"`), nil, http.StatusBadRequest},
+		{"Unknown", errors.New("some other error"), nil, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := StatusFor(tt.err); got != tt.want {
+				t.Errorf("StatusFor() = %v, want %v", got, tt.want)
+			}
+			got := Sentinel(tt.err)
+			if tt.sentinel == nil {
+				if got != tt.err {
+					t.Error("Sentinel() should return unknown errors as is")
+				}
+			} else if !errors.Is(got, tt.sentinel) {
+				t.Errorf("Sentinel() = %v, want %v", got, tt.sentinel)
+			}
+		})
+	}
+}
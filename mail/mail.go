@@ -61,20 +61,49 @@ func (mm *Manager) BulkSend(subject, content, contentType string, recipientNames
 	return nil
 }
 
+// Sender is used to override the sender details of an email
+type Sender struct {
+	Name    string
+	Address string
+	ReplyTo string
+}
+
 // SendEmail is used to send an email to temporal users
 func (mm *Manager) SendEmail(subject, content, contentType, recipientName, recipientEmail string) (int, error) {
+	return mm.SendEmailFrom(Sender{}, subject, content, contentType, "", recipientName, recipientEmail)
+}
+
+// SendEmailFrom is used to send an email to temporal users using the given sender details.
+// The name and address of the manager are used for any sender fields which are empty.
+// If plainContent is not empty it is included as a plaintext alternative to content
+func (mm *Manager) SendEmailFrom(sender Sender, subject, content, contentType, plainContent, recipientName, recipientEmail string) (int, error) {
 	mm.cmux.Lock()
 	if contentType == "" {
 		contentType = "text/html"
 	}
+	if sender.Name == "" {
+		sender.Name = mm.EmailName
+	}
+	if sender.Address == "" {
+		sender.Address = mm.EmailAddress
+	}
 
 	var (
-		from    = mail.NewEmail(mm.EmailName, mm.EmailAddress)
-		to      = mail.NewEmail(recipientName, recipientEmail)
-		message = mail.NewContent(contentType, content)
+		from     = mail.NewEmail(sender.Name, sender.Address)
+		to       = mail.NewEmail(recipientName, recipientEmail)
+		messages []*mail.Content
 	)
+	// the plaintext part must come before any others
+	if plainContent != "" && contentType != "text/plain" {
+		messages = append(messages, mail.NewContent("text/plain", plainContent))
+	}
+	messages = append(messages, mail.NewContent(contentType, content))
+	email := mail.NewV3MailInit(from, subject, to, messages...)
+	if sender.ReplyTo != "" {
+		email.SetReplyTo(mail.NewEmail(sender.Name, sender.ReplyTo))
+	}
 
-	response, err := mm.client.Send(mail.NewV3MailInit(from, subject, to, message))
+	response, err := mm.client.Send(email)
 	mm.cmux.Unlock()
 	if err != nil {
 		return -1, err
@@ -65,3 +65,23 @@ func TestMail(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestHTMLToText(t *testing.T) {
+	tests := []struct {
+		name string
+		html string
+		want string
+	}{
+		{"Plain", "your username is testuser", "your username is testuser"},
+		{"Line-Breaks", "hello<br>world<br/>again", "hello\nworld\nagain"},
+		{"Link", `click the following <a href="https://temporal.cloud">link</a>`, "click the following link (https://temporal.cloud)"},
+		{"Entities-And-Tags", "<b>fish &amp; chips</b>", "fish & chips"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := mail.HTMLToText(tt.html); got != tt.want {
+				t.Errorf("HTMLToText() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
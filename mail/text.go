@@ -0,0 +1,28 @@
+package mail
+
+import (
+	"html"
+	"regexp"
+	"strings"
+)
+
+var (
+	lineBreaks = regexp.MustCompile(`(?i)<br\s*/?>|</p>`)
+	links      = regexp.MustCompile(`(?is)<a\s[^>]*href="([^"]*)"[^>]*>(.*?)</a>`)
+	tags       = regexp.MustCompile(`(?s)<[^>]*>`)
+)
+
+// HTMLToText converts html email content into a plaintext alternative,
+// preserving line breaks and the destination of any links
+func HTMLToText(content string) string {
+	text := lineBreaks.ReplaceAllString(content, "\n")
+	text = links.ReplaceAllString(text, "$2 ($1)")
+	text = tags.ReplaceAllString(text, "")
+	text = html.UnescapeString(text)
+	// trim whitespace left behind by removed markup
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSpace(line)
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
@@ -49,8 +49,9 @@ func (qm *Manager) processMailSend(d amqp.Delivery, wg *sync.WaitGroup, mm *mail
 		d.Ack(false)
 		return
 	}
+	sender := mail.Sender{Name: es.FromName, Address: es.FromAddress, ReplyTo: es.ReplyTo}
 	for k, v := range es.Emails {
-		_, err := mm.SendEmail(es.Subject, es.Content, es.ContentType, es.UserNames[k], v)
+		_, err := mm.SendEmailFrom(sender, es.Subject, es.Content, es.ContentType, es.PlainContent, es.UserNames[k], v)
 		if err != nil {
 			qm.l.Errorw(
 				"failed to send email",
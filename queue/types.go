@@ -136,6 +136,13 @@ type EmailSend struct {
 	ContentType string   `json:"content_type"`
 	UserNames   []string `json:"user_names"`
 	Emails      []string `json:"emails,omitempty"`
+	// optional plaintext alternative to html content
+	PlainContent string `json:"plain_content,omitempty"`
+	// optional sender details, the mail manager
+	// defaults are used when these are empty
+	FromName    string `json:"from_name,omitempty"`
+	FromAddress string `json:"from_address,omitempty"`
+	ReplyTo     string `json:"reply_to,omitempty"`
 }
 
 // IPNSEntry is used to hold relevant information needed to process IPNS entry creation requests